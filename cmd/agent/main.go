@@ -21,6 +21,7 @@ func main() {
 	address := flag.String("a", "0.0.0.0:8080", "server port")
 	reportInterval := flag.Int("r", 10, "report interval in seconds")
 	pollInterval := flag.Int("p", 2, "poll interval in seconds")
+	grpcAddress := flag.String("grpc-address", "", "send metrics to this server grpc address instead of http")
 	flag.Parse()
 
 	addressEnv := os.Getenv("ADDRESS")
@@ -39,16 +40,33 @@ func main() {
 		*pollInterval = utils.StrToInt(reportIntervalEnv, *pollInterval)
 	}
 
+	if envGRPCAddress := os.Getenv("GRPC_ADDRESS"); envGRPCAddress != "" {
+		*grpcAddress = envGRPCAddress
+	}
+
 	initLogger()
 
 	log.WithFields(log.Fields{
 		"address":        *address,
 		"reportInterval": *reportInterval,
 		"pollInterval":   *pollInterval,
+		"grpcAddress":    *grpcAddress,
 	}).Infoln("starting goMetrics agent")
 
 	store := agent.NewInMemoryMetricsStore()
-	sender := agent.NewHTTPMetricsSender(fmt.Sprintf("http://%s", *address))
+	var sender agent.StatsSender = agent.NewHTTPMetricsSender(fmt.Sprintf("http://%s", *address))
+	if *grpcAddress != "" {
+		grpcSender, err := agent.NewGRPCMetricsSender(*grpcAddress)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"grpcAddress": *grpcAddress,
+				"error":       err.Error(),
+			}).Fatal("Ошибка подключения к gRPC-серверу")
+		}
+		defer grpcSender.Close()
+
+		sender = grpcSender
+	}
 
 	service := services.NewCollectMetricsService(store, sender, time.Duration(*pollInterval)*time.Second, time.Duration(*reportInterval)*time.Second)
 	service.Run()
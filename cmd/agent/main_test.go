@@ -1,6 +1,8 @@
 package main
 
 import (
+	"errors"
+	"github.com/Oresst/goMetrics/internal/agent"
 	"github.com/Oresst/goMetrics/internal/services"
 	"github.com/stretchr/testify/assert"
 	"testing"
@@ -32,10 +34,21 @@ func (s *mockStore) IncreaseCountMetric(metricName string, by int) {
 	s.increaseCountMetricCount++
 }
 
+func (s *mockStore) ObserveHistogram(metricName string, values ...float64) {}
+
+func (s *mockStore) TakeHistograms() map[string][]float64 {
+	return map[string][]float64{
+		"GCPauseSeconds": {0.001},
+	}
+}
+
 type mockSender struct {
 	sendGaugeMetricCount int
 	sendCountMetricCount int
 	sendMetricJSONCount  int
+
+	sendHistogramJSONCount int
+	histogramErr           error
 }
 
 func (s *mockSender) SendGaugeMetric(metricName string, metricValue string) {
@@ -50,6 +63,11 @@ func (s *mockSender) SendMetricJSON(metricName string, metricType string, value
 	s.sendMetricJSONCount++
 }
 
+func (s *mockSender) SendHistogramJSON(metricName string, observations []float64) error {
+	s.sendHistogramJSONCount++
+	return s.histogramErr
+}
+
 func TestCollectStats(t *testing.T) {
 	store := &mockStore{}
 	sender := &mockSender{}
@@ -86,3 +104,18 @@ func TestSendStats(t *testing.T) {
 
 	assert.GreaterOrEqual(t, sender.sendMetricJSONCount, 1)
 }
+
+func TestSendStatsKeepsUnsentHistograms(t *testing.T) {
+	store := agent.NewInMemoryMetricsStore()
+	store.ObserveHistogram("GCPauseSeconds", 0.001, 0.2)
+	sender := &mockSender{histogramErr: errors.New("server unavailable")}
+
+	service := services.NewCollectMetricsService(store, sender, time.Second, time.Hour)
+	go service.SendStats()
+
+	// Остановка дожидается конца первой отправки.
+	service.WaitSendStats <- true
+
+	assert.Equal(t, 1, sender.sendHistogramJSONCount)
+	assert.Equal(t, map[string][]float64{"GCPauseSeconds": {0.001, 0.2}}, store.TakeHistograms())
+}
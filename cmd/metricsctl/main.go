@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/Oresst/goMetrics/internal/services"
+	"github.com/Oresst/goMetrics/internal/store"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+const usage = `usage:
+  metricsctl export (-a address | -f file) [-format json|csv|file] [-file-format json|binary] [-o path]
+  metricsctl import (-a address | -f file) [-format json|csv|file] [-mode merge|overwrite] [-file-format json|binary] [-i path]`
+
+var client = &http.Client{Timeout: 30 * time.Second}
+
+func initLogger() {
+	log.SetFormatter(&log.JSONFormatter{})
+	log.SetOutput(os.Stderr)
+	log.SetLevel(log.InfoLevel)
+}
+
+func main() {
+	initLogger()
+
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "export":
+		err = runExport(os.Args[2:])
+	case "import":
+		err = runImport(os.Args[2:])
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"place":   "[metricsctl]",
+			"command": os.Args[1],
+			"error":   err.Error(),
+		}).Fatal("command failed")
+	}
+}
+
+// runExport выгружает состояние работающего сервера или файла журнала.
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	address := flags.String("a", "", "address of a running server")
+	filePath := flags.String("f", "", "persistence file of the server")
+	format := flags.String("format", services.DumpJSON, "dump format: json, csv or file")
+	fileFormat := flags.String("file-format", services.FormatJSON, "record format for -format file: json or binary")
+	recovery := flags.String("restore-mode", services.RecoverySkip, "corrupted records in -f: strict or skip")
+	output := flags.String("o", "", "output path, stdout by default; required for -format file")
+	flags.Parse(args)
+
+	snapshot, err := loadSource(*address, *filePath, *recovery)
+	if err != nil {
+		return err
+	}
+
+	if *format == services.DumpFile {
+		if *output == "" {
+			return errors.New("-format file requires -o")
+		}
+		return services.WriteFileDump(*output, *fileFormat, snapshot)
+	}
+
+	var buf bytes.Buffer
+	if err = services.WriteDump(&buf, *format, snapshot); err != nil {
+		return err
+	}
+
+	if *output == "" {
+		_, err = os.Stdout.Write(buf.Bytes())
+		return err
+	}
+
+	return os.WriteFile(*output, buf.Bytes(), 0666)
+}
+
+// runImport загружает выгрузку в работающий сервер или в файл журнала.
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	address := flags.String("a", "", "address of a running server")
+	filePath := flags.String("f", "", "persistence file of the server, must not be in use")
+	format := flags.String("format", services.DumpJSON, "dump format: json, csv or file")
+	fileFormat := flags.String("file-format", services.FormatJSON, "record format written to -f: json or binary")
+	mode := flags.String("mode", services.ImportMerge, "merge adds to the current state, overwrite replaces it")
+	recovery := flags.String("restore-mode", services.RecoverySkip, "corrupted records in persistence files: strict or skip")
+	input := flags.String("i", "", "input path, stdin by default; required for -format file")
+	flags.Parse(args)
+
+	if *mode != services.ImportMerge && *mode != services.ImportOverwrite {
+		return fmt.Errorf("unknown import mode %q", *mode)
+	}
+
+	snapshot, err := readInput(*format, *input, *recovery)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case *address != "" && *filePath == "":
+		return postSnapshot(*address, *mode, snapshot)
+	case *filePath != "" && *address == "":
+		return importToFile(*filePath, *fileFormat, *mode, *recovery, snapshot)
+	default:
+		return errors.New("exactly one of -a and -f is required")
+	}
+}
+
+func loadSource(address string, filePath string, recovery string) (store.Snapshot, error) {
+	switch {
+	case address != "" && filePath == "":
+		return fetchSnapshot(address)
+	case filePath != "" && address == "":
+		if _, err := os.Stat(filePath); err != nil {
+			return store.Snapshot{}, err
+		}
+
+		snapshot, report, err := services.ReadFileDump(filePath, recovery)
+		logReport(filePath, report)
+		return snapshot, err
+	default:
+		return store.Snapshot{}, errors.New("exactly one of -a and -f is required")
+	}
+}
+
+func readInput(format string, input string, recovery string) (store.Snapshot, error) {
+	if format == services.DumpFile {
+		if input == "" {
+			return store.Snapshot{}, errors.New("-format file requires -i")
+		}
+
+		return loadSource("", input, recovery)
+	}
+
+	var r io.Reader = os.Stdin
+	if input != "" {
+		file, err := os.Open(input)
+		if err != nil {
+			return store.Snapshot{}, err
+		}
+		defer file.Close()
+
+		r = file
+	}
+
+	return services.ReadDump(r, format)
+}
+
+func fetchSnapshot(address string) (store.Snapshot, error) {
+	resp, err := client.Get(fmt.Sprintf("http://%s/snapshot", address))
+	if err != nil {
+		return store.Snapshot{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return store.Snapshot{}, fmt.Errorf("server responded %s: %s", resp.Status, body)
+	}
+
+	var snapshot store.Snapshot
+	err = json.NewDecoder(resp.Body).Decode(&snapshot)
+	return snapshot, err
+}
+
+func postSnapshot(address string, mode string, snapshot store.Snapshot) error {
+	body, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("http://%s/snapshot?mode=%s", address, mode)
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("server responded %s: %s", resp.Status, message)
+	}
+
+	log.WithFields(log.Fields{
+		"address": address,
+		"mode":    mode,
+		"count":   len(snapshot.Metrics),
+	}).Info("snapshot imported")
+	return nil
+}
+
+// importToFile применяет выгрузку к файлу журнала остановленного сервера.
+// При merge текущее содержимое файла читается в память, выгрузка добавляется к нему,
+// и результат целиком перезаписывает файл.
+func importToFile(filePath string, fileFormat string, mode string, recovery string, snapshot store.Snapshot) error {
+	storage := store.NewMemStorage()
+
+	if mode == services.ImportMerge {
+		if _, err := os.Stat(filePath); err == nil {
+			current, report, err := services.ReadFileDump(filePath, recovery)
+			logReport(filePath, report)
+			if err != nil {
+				return err
+			}
+
+			if err = storage.Restore(current); err != nil {
+				return err
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if err := services.ApplyDump(storage, snapshot, mode); err != nil {
+		return err
+	}
+
+	result, err := storage.Snapshot()
+	if err != nil {
+		return err
+	}
+
+	if err = services.WriteFileDump(filePath, fileFormat, result); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"file":  filePath,
+		"mode":  mode,
+		"count": len(result.Metrics),
+	}).Info("snapshot imported")
+	return nil
+}
+
+func logReport(filePath string, report services.LoadReport) {
+	if report.Skipped == 0 && report.Repaired == 0 {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"file":     filePath,
+		"loaded":   report.Loaded,
+		"skipped":  report.Skipped,
+		"repaired": report.Repaired,
+	}).Warn("corrupted records skipped")
+}
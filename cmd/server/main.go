@@ -8,9 +8,10 @@ import (
 	"github.com/Oresst/goMetrics/internal/services"
 	"github.com/Oresst/goMetrics/internal/store"
 	"github.com/Oresst/goMetrics/internal/utils"
-	"github.com/Oresst/goMetrics/models"
 	"github.com/go-chi/chi/v5"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -27,15 +28,44 @@ func initLogger() {
 
 func main() {
 	address := flag.String("a", ":8080", "server port")
+	// gRPC-сервис принимает метрики без аутентификации и TLS, поэтому включается только явно: -grpc-address=:3200 или GRPC_ADDRESS.
+	grpcAddress := flag.String("grpc-address", "", "grpc server address, e.g. :3200, empty disables grpc")
 	interval := flag.Int("i", 300, "save interval in seconds")
 	filePath := flag.String("f", "metrics.txt", "file path")
 	restore := flag.Bool("r", false, "restore metrics")
+	restoreUntil := flag.String("restore-until", "", "restore only records persisted up to this RFC3339 instant, implies -r")
+	restoreMode := flag.String("restore-mode", services.RecoverySkip, "corrupt records handling: strict, skip or quarantine")
+	fileFormat := flag.String("file-format", services.FormatJSON, "file record format: json or binary, existing files are converted on start")
+	fileMode := flag.String("file-mode", "log", "file storage mode: log appends every update, snapshot atomically rewrites the full state")
+	queueSize := flag.Int("file-queue-size", 10000, "max records buffered between file flushes")
+	queuePolicy := flag.String("file-queue-policy", services.QueueBlock, "full file queue policy: block, drop-oldest or reject")
+	fileMaxSize := flag.Int("file-max-size", 0, "rotate the file into a segment after this many bytes, 0 disables")
+	fileMaxAge := flag.Duration("file-max-age", 0, "rotate the file into a segment after this age, 0 disables")
+	fileCompress := flag.Bool("file-compress", false, "gzip rotated segments")
+	fileCompactSegments := flag.Int("file-compact-segments", 0, "compact segments into a snapshot once this many accumulate, 0 disables")
+	databaseDSN := flag.String("d", "", "database dsn")
+	persistence := flag.String("persistence", services.PersistenceFile, "persistence backend: file, sql, dir or none")
+	persistenceDSN := flag.String("persistence-dsn", "", "database dsn of the sql persistence journal")
+	persistenceDir := flag.String("persistence-dir", "snapshots", "directory of the dir persistence snapshots")
+	persistenceKeep := flag.Int("persistence-keep", 3, "snapshots kept by the dir persistence")
+	historyMaxAge := flag.Duration("history-max-age", time.Hour, "max age of metric history samples")
+	historyMaxSamples := flag.Int("history-max-samples", 1000, "max history samples per metric, 0 disables history")
+	historyMaxSeries := flag.Int("history-max-series", 10000, "max metrics with history, new metrics beyond it get no history")
+	metricTTL := flag.Duration("metric-ttl", 0, "evict metrics not updated within ttl, 0 disables eviction")
+	metricTTLInterval := flag.Duration("metric-ttl-interval", time.Minute, "stale metrics sweep interval")
+	metricTTLKeepCounters := flag.Bool("metric-ttl-keep-counters", false, "never evict counters")
+	histogramBuckets := flag.String("histogram-buckets", "", "comma separated default histogram bucket bounds")
+	streamBuffer := flag.Int("stream-buffer", services.DefaultStreamBuffer, "events buffered per /stream client before it is dropped")
 	flag.Parse()
 
 	if envAddress := os.Getenv("ADDRESS"); envAddress != "" {
 		*address = envAddress
 	}
 
+	if envGRPCAddress := os.Getenv("GRPC_ADDRESS"); envGRPCAddress != "" {
+		*grpcAddress = envGRPCAddress
+	}
+
 	if envInterval := os.Getenv("STORE_INTERVAL"); envInterval != "" {
 		*interval = utils.StrToInt(envInterval, *interval)
 	}
@@ -48,6 +78,106 @@ func main() {
 		*restore = envRestore == "true"
 	}
 
+	if envRestoreUntil := os.Getenv("RESTORE_UNTIL"); envRestoreUntil != "" {
+		*restoreUntil = envRestoreUntil
+	}
+
+	if envFileFormat := os.Getenv("FILE_STORAGE_FORMAT"); envFileFormat != "" {
+		*fileFormat = envFileFormat
+	}
+
+	if envFileMode := os.Getenv("FILE_STORAGE_MODE"); envFileMode != "" {
+		*fileMode = envFileMode
+	}
+
+	if envQueueSize := os.Getenv("FILE_QUEUE_SIZE"); envQueueSize != "" {
+		*queueSize = utils.StrToInt(envQueueSize, *queueSize)
+	}
+
+	if envQueuePolicy := os.Getenv("FILE_QUEUE_POLICY"); envQueuePolicy != "" {
+		*queuePolicy = envQueuePolicy
+	}
+
+	if envFileMaxSize := os.Getenv("FILE_MAX_SIZE"); envFileMaxSize != "" {
+		*fileMaxSize = utils.StrToInt(envFileMaxSize, *fileMaxSize)
+	}
+
+	if envFileMaxAge := os.Getenv("FILE_MAX_AGE"); envFileMaxAge != "" {
+		if maxAge, err := time.ParseDuration(envFileMaxAge); err == nil {
+			*fileMaxAge = maxAge
+		}
+	}
+
+	if envFileCompress := os.Getenv("FILE_COMPRESS"); envFileCompress != "" {
+		*fileCompress = envFileCompress == "true"
+	}
+
+	if envCompactSegments := os.Getenv("FILE_COMPACT_SEGMENTS"); envCompactSegments != "" {
+		*fileCompactSegments = utils.StrToInt(envCompactSegments, *fileCompactSegments)
+	}
+
+	if envRestoreMode := os.Getenv("RESTORE_MODE"); envRestoreMode != "" {
+		*restoreMode = envRestoreMode
+	}
+
+	if envDatabaseDSN := os.Getenv("DATABASE_DSN"); envDatabaseDSN != "" {
+		*databaseDSN = envDatabaseDSN
+	}
+
+	if envPersistence := os.Getenv("PERSISTENCE"); envPersistence != "" {
+		*persistence = envPersistence
+	}
+
+	if envPersistenceDSN := os.Getenv("PERSISTENCE_DSN"); envPersistenceDSN != "" {
+		*persistenceDSN = envPersistenceDSN
+	}
+
+	if envPersistenceDir := os.Getenv("PERSISTENCE_DIR"); envPersistenceDir != "" {
+		*persistenceDir = envPersistenceDir
+	}
+
+	if envPersistenceKeep := os.Getenv("PERSISTENCE_KEEP"); envPersistenceKeep != "" {
+		*persistenceKeep = utils.StrToInt(envPersistenceKeep, *persistenceKeep)
+	}
+
+	if envStreamBuffer := os.Getenv("STREAM_BUFFER"); envStreamBuffer != "" {
+		*streamBuffer = utils.StrToInt(envStreamBuffer, *streamBuffer)
+	}
+
+	if envHistoryMaxAge := os.Getenv("HISTORY_MAX_AGE"); envHistoryMaxAge != "" {
+		if maxAge, err := time.ParseDuration(envHistoryMaxAge); err == nil {
+			*historyMaxAge = maxAge
+		}
+	}
+
+	if envHistoryMaxSamples := os.Getenv("HISTORY_MAX_SAMPLES"); envHistoryMaxSamples != "" {
+		*historyMaxSamples = utils.StrToInt(envHistoryMaxSamples, *historyMaxSamples)
+	}
+
+	if envHistoryMaxSeries := os.Getenv("HISTORY_MAX_SERIES"); envHistoryMaxSeries != "" {
+		*historyMaxSeries = utils.StrToInt(envHistoryMaxSeries, *historyMaxSeries)
+	}
+
+	if envMetricTTL := os.Getenv("METRIC_TTL"); envMetricTTL != "" {
+		if ttl, err := time.ParseDuration(envMetricTTL); err == nil {
+			*metricTTL = ttl
+		}
+	}
+
+	if envMetricTTLInterval := os.Getenv("METRIC_TTL_INTERVAL"); envMetricTTLInterval != "" {
+		if interval, err := time.ParseDuration(envMetricTTLInterval); err == nil {
+			*metricTTLInterval = interval
+		}
+	}
+
+	if envKeepCounters := os.Getenv("METRIC_TTL_KEEP_COUNTERS"); envKeepCounters != "" {
+		*metricTTLKeepCounters = envKeepCounters == "true"
+	}
+
+	if envHistogramBuckets := os.Getenv("HISTOGRAM_BUCKETS"); envHistogramBuckets != "" {
+		*histogramBuckets = envHistogramBuckets
+	}
+
 	initLogger()
 
 	addressArray := strings.Split(*address, ":")
@@ -62,7 +192,163 @@ func main() {
 		"address": *address,
 	}).Info("Run with args")
 
-	fileService, err := services.NewFileService(*filePath, time.Second*time.Duration(*interval))
+	var until time.Time
+	if *restoreUntil != "" {
+		var err error
+		if until, err = time.Parse(time.RFC3339, *restoreUntil); err != nil {
+			log.WithFields(log.Fields{
+				"error": err.Error(),
+				"until": *restoreUntil,
+			}).Fatal("Неверный момент восстановления")
+		}
+
+		if *persistence != services.PersistenceFile && *persistence != services.PersistenceSQL {
+			log.WithFields(log.Fields{
+				"persistence": *persistence,
+			}).Fatal("Восстановление на момент времени поддерживается только для file и sql")
+		}
+
+		// В режиме снимков файл хранит только последнее состояние, а с базой данных журнал не ведётся.
+		if *persistence == services.PersistenceFile && *fileMode == "snapshot" {
+			log.WithFields(log.Fields{
+				"until":     *restoreUntil,
+				"file_mode": *fileMode,
+			}).Fatal("Восстановление на момент времени не поддерживается в режиме снимков")
+		}
+		if *databaseDSN != "" {
+			log.WithFields(log.Fields{
+				"until": *restoreUntil,
+			}).Fatal("Восстановление на момент времени не поддерживается при хранении метрик в базе данных")
+		}
+		*restore = true
+	}
+
+	storage := getStorage()
+	var recorder services.Persistence
+	var restoreTarget store.Store
+
+	if *databaseDSN != "" {
+		sqlStorage, err := store.NewSQLStorage(*databaseDSN)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err.Error(),
+			}).Fatal("Ошибка подключения к базе данных")
+		}
+		defer sqlStorage.Close()
+
+		storage = sqlStorage
+	} else {
+		switch *persistence {
+		case services.PersistenceFile:
+			recorder = initFileService(storage, *filePath, *interval, *fileMode, fileConfig{
+				queueSize:       *queueSize,
+				queuePolicy:     *queuePolicy,
+				maxSize:         *fileMaxSize,
+				maxAge:          *fileMaxAge,
+				compress:        *fileCompress,
+				compactSegments: *fileCompactSegments,
+				recovery:        *restoreMode,
+				format:          *fileFormat,
+			})
+		case services.PersistenceSQL:
+			recorder = initSQLPersistence(*persistenceDSN, *interval, *restoreMode)
+		case services.PersistenceDir:
+			recorder = initSnapshotDir(storage, *persistenceDir, *interval, *persistenceKeep, *restoreMode)
+		case services.PersistenceNone:
+			recorder = services.NopPersistence{}
+		default:
+			log.WithFields(log.Fields{
+				"persistence": *persistence,
+			}).Fatal("Неизвестный способ сохранения метрик")
+		}
+
+		if *restore {
+			restoreTarget = storage
+		}
+		defer recorder.Close()
+
+		storage = store.NewPersistentStorage(storage, recorder)
+	}
+
+	streamHub := services.NewStreamHub(*streamBuffer)
+	storage = store.NewPersistentStorage(storage, streamHub)
+
+	if *historyMaxSamples > 0 {
+		storage = store.NewHistoryStorage(storage, store.HistoryConfig{
+			MaxAge:     *historyMaxAge,
+			MaxSamples: *historyMaxSamples,
+			MaxSeries:  *historyMaxSeries,
+		})
+	}
+
+	if *metricTTL > 0 {
+		expiryService := services.NewExpiryService(storage, *metricTTL, *metricTTLInterval, *metricTTLKeepCounters)
+		expiryService.Run()
+		defer expiryService.Stop()
+	}
+
+	service := services.NewMetricsService(storage)
+	service.SetStreamHub(streamHub)
+	if *histogramBuckets != "" {
+		buckets, err := utils.ParseFloats(*histogramBuckets)
+		if err == nil {
+			err = service.SetHistogramBuckets(buckets)
+		}
+
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":   err.Error(),
+				"buckets": *histogramBuckets,
+			}).Fatal("Неверные границы корзин гистограммы")
+		}
+	}
+
+	r := getRouter(service)
+
+	// Восстановление идёт в фоне, чтобы сервер сразу отвечал на /healthz и /readyz.
+	if restoreTarget != nil {
+		service.Readiness().StartRestore()
+		go restoreFromPersistence(restoreTarget, recorder, service.Readiness(), until)
+	}
+
+	if *grpcAddress != "" {
+		grpcServer := services.NewGRPCServer(service)
+		go runGRPCServer(*grpcAddress, grpcServer)
+		defer grpcServer.GracefulStop()
+	}
+
+	if err := runServer(*address, r, streamHub.Close); err != nil {
+		log.WithFields(log.Fields{
+			"address": *address,
+		}).Fatal(err)
+	}
+}
+
+// fileConfig — настройки FileService из флагов и переменных окружения.
+type fileConfig struct {
+	queueSize       int
+	queuePolicy     string
+	maxSize         int
+	maxAge          time.Duration
+	compress        bool
+	compactSegments int
+	recovery        string
+	format          string
+}
+
+func initFileService(storage store.Store, filePath string, interval int, mode string, config fileConfig) *services.FileService {
+	var fileService *services.FileService
+	var err error
+
+	switch mode {
+	case "log":
+		fileService, err = services.NewFileService(filePath, time.Second*time.Duration(interval))
+	case "snapshot":
+		fileService, err = services.NewSnapshotFileService(filePath, time.Second*time.Duration(interval), storage)
+	default:
+		err = fmt.Errorf("неизвестный режим хранения %q", mode)
+	}
+
 	if err != nil {
 		log.WithFields(log.Fields{
 			"error": err.Error(),
@@ -70,52 +356,136 @@ func main() {
 	}
 	fileService.Run()
 
-	storage := getStorage()
-
-	if *restore {
-		data, err := fileService.ReadAllData(*filePath)
+	if err = fileService.SetQueue(config.queueSize, config.queuePolicy); err != nil {
+		log.WithFields(log.Fields{
+			"error":  err.Error(),
+			"size":   config.queueSize,
+			"policy": config.queuePolicy,
+		}).Fatal("Неверные параметры очереди записи в файл")
+	}
 
+	if mode == "log" && (config.maxSize > 0 || config.maxAge > 0) {
+		err = fileService.SetRotation(services.RotationConfig{
+			MaxSize:         int64(config.maxSize),
+			MaxAge:          config.maxAge,
+			Compress:        config.compress,
+			CompactSegments: config.compactSegments,
+		})
 		if err != nil {
 			log.WithFields(log.Fields{
 				"error": err.Error(),
-			}).Fatal("Ошибка считывания файла")
-		}
-
-		for _, metric := range data {
-			if metric.MType == models.Gauge {
-				err := storage.AddMetric(metric.MType, metric.ID, *metric.Value)
-
-				if err != nil {
-					log.WithFields(log.Fields{
-						"error": err.Error(),
-					}).Fatal("Ошибка загрузки метрик")
-				}
-			} else if metric.MType == models.Counter {
-				err := storage.AddMetric(metric.MType, metric.ID, float64(*metric.Delta))
-				if err != nil {
-					log.WithFields(log.Fields{
-						"error": err.Error(),
-					}).Fatal("Ошибка загрузки метрик")
-				}
-			} else {
-				log.WithFields(log.Fields{
-					"type": metric.MType,
-					"id":   metric.ID,
-				}).Info("Неверный тип метрики")
-			}
-		}
-	}
-
-	service := services.NewMetricsService(storage, fileService)
-	r := getRouter(service)
+			}).Fatal("Ошибка настройки ротации файла")
+		}
+	}
 
-	if err := runServer(*address, r); err != nil {
+	if err = fileService.SetRecovery(config.recovery); err != nil {
 		log.WithFields(log.Fields{
-			"address": *address,
-		}).Fatal(err)
+			"error": err.Error(),
+			"mode":  config.recovery,
+		}).Fatal("Неверный режим восстановления")
+	}
+
+	if err = fileService.SetFormat(config.format); err != nil {
+		log.WithFields(log.Fields{
+			"error":  err.Error(),
+			"format": config.format,
+		}).Fatal("Ошибка перехода на формат файла")
+	}
+
+	return fileService
+}
+
+func initSQLPersistence(dsn string, interval int, recovery string) *services.SQLPersistence {
+	persistence, err := services.NewSQLPersistence(dsn, time.Second*time.Duration(interval))
+	if err == nil {
+		err = persistence.SetRecovery(recovery)
 	}
 
-	fileService.Stop()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err.Error(),
+		}).Fatal("Ошибка подключения к журналу в базе данных")
+	}
+	persistence.Run()
+
+	return persistence
+}
+
+func initSnapshotDir(storage store.Store, dir string, interval int, keep int, recovery string) *services.SnapshotDirService {
+	persistence, err := services.NewSnapshotDirService(dir, time.Second*time.Duration(interval), keep, storage)
+	if err == nil {
+		err = persistence.SetRecovery(recovery)
+	}
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err.Error(),
+			"dir":   dir,
+		}).Fatal("Ошибка настройки каталога снимков")
+	}
+	persistence.Run()
+
+	return persistence
+}
+
+// restoreFromPersistence загружает сохранённые записи и заменяет ими содержимое хранилища,
+// сообщая о ходе восстановления в readiness. Если задан until, воспроизводятся только записи
+// не позже него; журнал при этом не перезаписывается.
+func restoreFromPersistence(storage store.Store, persistence services.Persistence, readiness *services.Readiness, until time.Time) {
+	data, report, err := persistence.Load()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err.Error(),
+		}).Fatal("Ошибка считывания сохранённых метрик")
+	}
+
+	loaded := len(data)
+	if !until.IsZero() {
+		if data, err = services.RecordsUntil(data, until); err != nil {
+			log.WithFields(log.Fields{
+				"error": err.Error(),
+				"until": until,
+			}).Fatal("Ошибка восстановления на момент времени")
+		}
+	}
+
+	readiness.SetProgress(services.RestoreReplaying, 0, len(data))
+	snapshot, err := store.ReplayLogProgress(data, func(done int) {
+		readiness.SetProgress(services.RestoreReplaying, done, len(data))
+		log.WithFields(log.Fields{
+			"done":  done,
+			"total": len(data),
+		}).Info("Воспроизведение сохранённых метрик")
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err.Error(),
+		}).Fatal("Ошибка разбора сохранённых метрик")
+	}
+
+	readiness.SetProgress(services.RestoreApplying, 0, len(snapshot.Metrics))
+	if err = storage.Restore(snapshot); err != nil {
+		log.WithFields(log.Fields{
+			"error": err.Error(),
+		}).Fatal("Ошибка загрузки метрик")
+	}
+
+	// Журнал не перезаписывается: более поздние записи остаются в нём и воспроизведутся
+	// при восстановлении без until.
+	if !until.IsZero() {
+		log.WithFields(log.Fields{
+			"until":   until,
+			"skipped": loaded - len(data),
+		}).Warn("Метрики восстановлены на момент времени, более поздние записи пропущены")
+	}
+	readiness.FinishRestore()
+
+	log.WithFields(log.Fields{
+		"metrics":  len(snapshot.Metrics),
+		"loaded":   report.Loaded,
+		"skipped":  report.Skipped,
+		"repaired": report.Repaired,
+	}).Info("Метрики восстановлены")
 }
 
 func getStorage() store.Store {
@@ -128,18 +498,38 @@ func getRouter(service *services.MetricsService) *chi.Mux {
 	r.Use(service.LoggerMiddleware)
 	r.Use(service.GzipMiddleware)
 
-	r.Route("/update/{type}/{name}/{value}", func(r chi.Router) {
-		r.Post("/", service.AddMetricHandler)
-	})
-	r.Route("/update", func(r chi.Router) {
-		r.Post("/", service.AddMetricJSONHandler)
+	r.Get("/healthz", service.HealthzHandler)
+	r.Get("/readyz", service.ReadyzHandler)
+
+	r.Group(func(r chi.Router) {
+		r.Use(service.ReadyMiddleware)
+
+		r.Route("/update/{type}/{name}/{value}", func(r chi.Router) {
+			r.Post("/", service.AddMetricHandler)
+		})
+		r.Route("/update", func(r chi.Router) {
+			r.Post("/", service.AddMetricJSONHandler)
+		})
+		r.Route("/updates", func(r chi.Router) {
+			r.Post("/", service.AddMetricsJSONHandler)
+		})
+		r.Post("/snapshot", service.RestoreSnapshotHandler)
+		r.Delete("/value/{type}", service.DeleteByPrefixHandler)
+		r.Post("/reset/{type}", service.ResetByPrefixHandler)
+		r.Post("/reset/{type}/{name}", service.ResetMetricHandler)
 	})
 	r.Route("/value", func(r chi.Router) {
 		r.Post("/", service.GetMetricJSONHandler)
 	})
 	r.Route("/value/{type}/{name}", func(r chi.Router) {
 		r.Get("/", service.GetMetricHandler)
+		r.With(service.ReadyMiddleware).Delete("/", service.DeleteMetricHandler)
 	})
+	r.Get("/history/{type}/{name}", service.GetHistoryHandler)
+	r.Get("/snapshot", service.GetSnapshotHandler)
+	r.Get("/metrics", service.PrometheusHandler)
+	r.Get("/api/metrics", service.ListMetricsHandler)
+	r.Get("/stream", service.StreamHandler)
 	r.Get("/", service.GetAllMetricsHandler)
 
 	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
@@ -150,11 +540,16 @@ func getRouter(service *services.MetricsService) *chi.Mux {
 	return r
 }
 
-func runServer(port string, r *chi.Mux) error {
+// runServer обслуживает запросы до SIGINT или SIGTERM. onShutdown вызываются в начале остановки,
+// чтобы завершить долгие запросы вроде /stream, которых Shutdown иначе ждал бы.
+func runServer(port string, r *chi.Mux, onShutdown ...func()) error {
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%s", port),
 		Handler: r,
 	}
+	for _, f := range onShutdown {
+		server.RegisterOnShutdown(f)
+	}
 
 	go func() {
 		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
@@ -170,3 +565,18 @@ func runServer(port string, r *chi.Mux) error {
 
 	return server.Shutdown(ctx)
 }
+
+func runGRPCServer(address string, server *grpc.Server) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"grpcAddress": address,
+		}).Fatalf("Ошибка при запуске gRPC-сервера: %s", err)
+	}
+
+	if err = server.Serve(listener); err != nil {
+		log.WithFields(log.Fields{
+			"grpcAddress": address,
+		}).Fatalf("Ошибка при запуске gRPC-сервера: %s", err)
+	}
+}
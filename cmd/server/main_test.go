@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/Oresst/goMetrics/internal/services"
+	"github.com/Oresst/goMetrics/internal/store"
 	"github.com/Oresst/goMetrics/internal/utils"
 	"github.com/Oresst/goMetrics/models"
 	"github.com/stretchr/testify/assert"
@@ -13,6 +14,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strconv"
 	"testing"
 )
@@ -74,6 +76,15 @@ func TestAddMetricHandler(t *testing.T) {
 				contentType: "text/plain",
 			},
 		},
+		{
+			testName: "float value for counter",
+			url:      "/update/counter/someMetric/1.5",
+			method:   http.MethodPost,
+			waiting: waiting{
+				code:        400,
+				contentType: "text/plain",
+			},
+		},
 		{
 			testName: "wrong value",
 			url:      "/update/gauge/someMetric/asdasd",
@@ -86,7 +97,7 @@ func TestAddMetricHandler(t *testing.T) {
 	}
 
 	storage := getStorage()
-	service := services.NewMetricsService(storage, nil)
+	service := services.NewMetricsService(storage)
 	r := getRouter(service)
 
 	for _, tc := range testCases {
@@ -252,11 +263,16 @@ func TestGetMetricHandler(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.testName, func(t *testing.T) {
 			storage := getStorage()
-			service := services.NewMetricsService(storage, nil)
+			service := services.NewMetricsService(storage)
 			r := getRouter(service)
 
 			for _, data := range tc.testData {
-				err := storage.AddMetric(data.metricType, data.metricName, data.metricValue)
+				var err error
+				if data.metricType == models.Counter {
+					err = storage.AddCounter(data.metricName, nil, int64(data.metricValue))
+				} else {
+					err = storage.SetGauge(data.metricName, nil, data.metricValue)
+				}
 
 				require.NoError(t, err)
 			}
@@ -419,7 +435,7 @@ func TestAddMetricJSONHandler(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.testName, func(t *testing.T) {
 			storage := getStorage()
-			service := services.NewMetricsService(storage, nil)
+			service := services.NewMetricsService(storage)
 			r := getRouter(service)
 
 			rawData, _ := json.Marshal(tc.testData)
@@ -440,7 +456,7 @@ func TestAddMetricJSONHandler(t *testing.T) {
 
 func TestGzipCompression(t *testing.T) {
 	storage := getStorage()
-	service := services.NewMetricsService(storage, nil)
+	service := services.NewMetricsService(storage)
 	r := getRouter(service)
 
 	t.Run("send gziped request", func(t *testing.T) {
@@ -508,7 +524,7 @@ func TestGzipCompression(t *testing.T) {
 			Delta int64  `json:"delta"`
 		}{}
 
-		err := storage.AddMetric(requestData.Type, requestData.Id, float64(waitingData.value))
+		err := storage.AddCounter(requestData.Id, nil, waitingData.value)
 		require.NoError(t, err)
 
 		rawData, err := json.Marshal(requestData)
@@ -542,3 +558,724 @@ func TestGzipCompression(t *testing.T) {
 		assert.Equal(t, requestData.Type, responseData.Type)
 	})
 }
+
+func TestCounterAndGaugeNamespaces(t *testing.T) {
+	type waiting struct {
+		code  int
+		value string
+	}
+
+	testCases := []struct {
+		testName string
+		updates  []string
+		url      string
+		waiting  waiting
+	}{
+		{
+			testName: "counter and gauge with same name",
+			updates:  []string{"/update/counter/shared/5", "/update/gauge/shared/1.5"},
+			url:      "/value/counter/shared",
+			waiting: waiting{
+				code:  200,
+				value: "5",
+			},
+		},
+		{
+			testName: "gauge does not overwrite counter",
+			updates:  []string{"/update/counter/shared/5", "/update/gauge/shared/1.5"},
+			url:      "/value/gauge/shared",
+			waiting: waiting{
+				code:  200,
+				value: "1.5",
+			},
+		},
+		{
+			testName: "counter above 2^53 keeps precision",
+			updates:  []string{"/update/counter/big/9007199254740993", "/update/counter/big/2"},
+			url:      "/value/counter/big",
+			waiting: waiting{
+				code:  200,
+				value: "9007199254740995",
+			},
+		},
+		{
+			testName: "lookup with another type",
+			updates:  []string{"/update/counter/onlyCounter/1"},
+			url:      "/value/gauge/onlyCounter",
+			waiting: waiting{
+				code:  400,
+				value: "metric registered with another type: onlyCounter is counter",
+			},
+		},
+		{
+			testName: "lookup of missing metric",
+			updates:  []string{"/update/counter/onlyCounter/1"},
+			url:      "/value/gauge/missing",
+			waiting: waiting{
+				code: 404,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			storage := getStorage()
+			service := services.NewMetricsService(storage)
+			r := getRouter(service)
+
+			for _, url := range tc.updates {
+				response := httptest.NewRecorder()
+				r.ServeHTTP(response, httptest.NewRequest(http.MethodPost, url, nil))
+				require.Equal(t, http.StatusOK, response.Code)
+			}
+
+			response := httptest.NewRecorder()
+			r.ServeHTTP(response, httptest.NewRequest(http.MethodGet, tc.url, nil))
+
+			require.Equal(t, tc.waiting.code, response.Code)
+			assert.Equal(t, tc.waiting.value, response.Body.String())
+		})
+	}
+}
+
+func TestAddMetricsJSONHandler(t *testing.T) {
+	type waiting struct {
+		code    int
+		counter *int64
+		gauge   *float64
+	}
+
+	testCases := []struct {
+		testName    string
+		url         string
+		contentType string
+		testData    []models.Metrics
+		waiting     waiting
+	}{
+		{
+			testName:    "valid batch",
+			url:         "/updates/",
+			contentType: "application/json",
+			testData: []models.Metrics{
+				{ID: "batchCounter", MType: models.Counter, Delta: utils.PointInt64(2)},
+				{ID: "batchCounter", MType: models.Counter, Delta: utils.PointInt64(3)},
+				{ID: "batchGauge", MType: models.Gauge, Value: utils.PointFloat64(1.25)},
+			},
+			waiting: waiting{
+				code:    200,
+				counter: utils.PointInt64(5),
+				gauge:   utils.PointFloat64(1.25),
+			},
+		},
+		{
+			testName:    "url without trailing slash",
+			url:         "/updates",
+			contentType: "application/json",
+			testData: []models.Metrics{
+				{ID: "batchCounter", MType: models.Counter, Delta: utils.PointInt64(1)},
+			},
+			waiting: waiting{
+				code:    200,
+				counter: utils.PointInt64(1),
+			},
+		},
+		{
+			testName:    "invalid element rejects whole batch",
+			url:         "/updates/",
+			contentType: "application/json",
+			testData: []models.Metrics{
+				{ID: "batchCounter", MType: models.Counter, Delta: utils.PointInt64(2)},
+				{ID: "batchGauge", MType: models.Gauge},
+			},
+			waiting: waiting{
+				code: 400,
+			},
+		},
+		{
+			testName:    "wrong content type",
+			url:         "/updates/",
+			contentType: "",
+			testData: []models.Metrics{
+				{ID: "batchCounter", MType: models.Counter, Delta: utils.PointInt64(2)},
+			},
+			waiting: waiting{
+				code: 400,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			storage := getStorage()
+			service := services.NewMetricsService(storage)
+			r := getRouter(service)
+
+			rawData, err := json.Marshal(tc.testData)
+			require.NoError(t, err)
+
+			request := httptest.NewRequest(http.MethodPost, tc.url, bytes.NewBuffer(rawData))
+			request.Header.Set("Content-Type", tc.contentType)
+			response := httptest.NewRecorder()
+			r.ServeHTTP(response, request)
+
+			require.Equal(t, tc.waiting.code, response.Code)
+
+			metrics, err := storage.GetAllMetrics()
+			require.NoError(t, err)
+
+			if tc.waiting.code != http.StatusOK {
+				assert.Empty(t, metrics)
+				return
+			}
+
+			if tc.waiting.counter != nil {
+				delta, err := storage.GetCounter("batchCounter", nil)
+				require.NoError(t, err)
+				assert.Equal(t, *tc.waiting.counter, delta)
+			}
+
+			if tc.waiting.gauge != nil {
+				value, err := storage.GetGauge("batchGauge", nil)
+				require.NoError(t, err)
+				assert.Equal(t, *tc.waiting.gauge, value)
+			}
+		})
+	}
+}
+
+func TestGetHistoryHandler(t *testing.T) {
+	storage := store.NewHistoryStorage(getStorage(), store.HistoryConfig{MaxSamples: 2})
+	service := services.NewMetricsService(storage)
+	r := getRouter(service)
+
+	for _, url := range []string{"/update/gauge/Alloc/1", "/update/gauge/Alloc/2", "/update/gauge/Alloc/3"} {
+		response := httptest.NewRecorder()
+		r.ServeHTTP(response, httptest.NewRequest(http.MethodPost, url, nil))
+		require.Equal(t, http.StatusOK, response.Code)
+	}
+
+	testCases := []struct {
+		testName string
+		url      string
+		code     int
+		values   []float64
+	}{
+		{
+			testName: "keeps last samples",
+			url:      "/history/gauge/Alloc",
+			code:     200,
+			values:   []float64{2, 3},
+		},
+		{
+			testName: "range in the past",
+			url:      "/history/gauge/Alloc?to=2000-01-01T00:00:00Z",
+			code:     200,
+			values:   []float64{},
+		},
+		{
+			testName: "wrong time format",
+			url:      "/history/gauge/Alloc?from=yesterday",
+			code:     400,
+		},
+		{
+			testName: "unknown metric",
+			url:      "/history/counter/Alloc",
+			code:     404,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			response := httptest.NewRecorder()
+			r.ServeHTTP(response, httptest.NewRequest(http.MethodGet, tc.url, nil))
+
+			require.Equal(t, tc.code, response.Code)
+			if tc.code != http.StatusOK {
+				return
+			}
+
+			var samples []models.Sample
+			require.NoError(t, json.Unmarshal(response.Body.Bytes(), &samples))
+
+			values := make([]float64, 0, len(samples))
+			for _, sample := range samples {
+				values = append(values, *sample.Value)
+			}
+			assert.Equal(t, tc.values, values)
+		})
+	}
+}
+
+func TestMetricLabels(t *testing.T) {
+	storage := getStorage()
+	service := services.NewMetricsService(storage)
+	r := getRouter(service)
+
+	for _, metric := range []models.Metrics{
+		{ID: "requests", MType: models.Counter, Delta: utils.PointInt64(1), Labels: models.Labels{"host": "web-1"}},
+		{ID: "requests", MType: models.Counter, Delta: utils.PointInt64(4), Labels: models.Labels{"host": "web-2"}},
+		{ID: "requests", MType: models.Counter, Delta: utils.PointInt64(10)},
+	} {
+		rawData, err := json.Marshal(metric)
+		require.NoError(t, err)
+
+		request := httptest.NewRequest(http.MethodPost, "/update", bytes.NewBuffer(rawData))
+		request.Header.Set("Content-Type", "application/json")
+		response := httptest.NewRecorder()
+		r.ServeHTTP(response, request)
+		require.Equal(t, http.StatusOK, response.Code)
+	}
+
+	response := httptest.NewRecorder()
+	r.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/update/counter/requests/2?host=web-1", nil))
+	require.Equal(t, http.StatusOK, response.Code)
+
+	t.Run("value by url", func(t *testing.T) {
+		testCases := []struct {
+			url   string
+			code  int
+			value string
+		}{
+			{url: "/value/counter/requests?host=web-1", code: 200, value: "3"},
+			{url: "/value/counter/requests?host=web-2", code: 200, value: "4"},
+			{url: "/value/counter/requests", code: 200, value: "10"},
+			{url: "/value/counter/requests?host=web-3", code: 404},
+			{url: "/value/counter/requests?bad-name=1", code: 400},
+		}
+
+		for _, tc := range testCases {
+			response := httptest.NewRecorder()
+			r.ServeHTTP(response, httptest.NewRequest(http.MethodGet, tc.url, nil))
+
+			require.Equal(t, tc.code, response.Code, tc.url)
+			if tc.code == http.StatusOK {
+				assert.Equal(t, tc.value, response.Body.String(), tc.url)
+			}
+		}
+	})
+
+	t.Run("value by json", func(t *testing.T) {
+		rawData, err := json.Marshal(models.Metrics{ID: "requests", MType: models.Counter, Labels: models.Labels{"host": "web-2"}})
+		require.NoError(t, err)
+
+		request := httptest.NewRequest(http.MethodPost, "/value", bytes.NewBuffer(rawData))
+		request.Header.Set("Content-Type", "application/json")
+		response := httptest.NewRecorder()
+		r.ServeHTTP(response, request)
+		require.Equal(t, http.StatusOK, response.Code)
+
+		var metric models.Metrics
+		require.NoError(t, json.Unmarshal(response.Body.Bytes(), &metric))
+		assert.Equal(t, int64(4), *metric.Delta)
+		assert.Equal(t, models.Labels{"host": "web-2"}, metric.Labels)
+	})
+
+	t.Run("list with matchers", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		r.ServeHTTP(response, httptest.NewRequest(http.MethodGet, `/?match=host%3D~%22web-.*%22`, nil))
+		require.Equal(t, http.StatusOK, response.Code)
+
+		body := response.Body.String()
+		assert.Contains(t, body, "/value/counter/requests?host=web-1")
+		assert.Contains(t, body, "/value/counter/requests?host=web-2")
+		assert.NotContains(t, body, `"/value/counter/requests"`)
+	})
+
+	t.Run("invalid label name", func(t *testing.T) {
+		rawData, err := json.Marshal(models.Metrics{
+			ID:     "requests",
+			MType:  models.Counter,
+			Delta:  utils.PointInt64(1),
+			Labels: models.Labels{"0host": "web-1"},
+		})
+		require.NoError(t, err)
+
+		request := httptest.NewRequest(http.MethodPost, "/update", bytes.NewBuffer(rawData))
+		request.Header.Set("Content-Type", "application/json")
+		response := httptest.NewRecorder()
+		r.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
+
+func TestHistogramMetrics(t *testing.T) {
+	storage := getStorage()
+	service := services.NewMetricsService(storage)
+	r := getRouter(service)
+
+	postJSON := func(metric models.Metrics) *httptest.ResponseRecorder {
+		rawData, err := json.Marshal(metric)
+		require.NoError(t, err)
+
+		request := httptest.NewRequest(http.MethodPost, "/update", bytes.NewBuffer(rawData))
+		request.Header.Set("Content-Type", "application/json")
+		response := httptest.NewRecorder()
+		r.ServeHTTP(response, request)
+		return response
+	}
+
+	testCases := []struct {
+		name   string
+		metric models.Metrics
+		code   int
+	}{
+		{
+			name: "observations",
+			metric: models.Metrics{
+				ID:           "latency",
+				MType:        models.Histogram,
+				Observations: []float64{0.1, 0.3, 0.3, 20},
+				Histogram:    &models.HistogramData{Buckets: []float64{0.1, 0.5, 1}},
+			},
+			code: http.StatusOK,
+		},
+		{
+			name: "pre-bucketed",
+			metric: models.Metrics{
+				ID:    "latency",
+				MType: models.Histogram,
+				Histogram: &models.HistogramData{
+					Buckets: []float64{0.1, 0.5, 1},
+					Counts:  []uint64{0, 0, 2, 0},
+					Count:   2,
+					Sum:     1.5,
+				},
+			},
+			code: http.StatusOK,
+		},
+		{
+			name: "buckets mismatch",
+			metric: models.Metrics{
+				ID:    "latency",
+				MType: models.Histogram,
+				Histogram: &models.HistogramData{
+					Buckets: []float64{1, 2},
+					Counts:  []uint64{1, 0, 0},
+					Count:   1,
+					Sum:     0.5,
+				},
+			},
+			code: http.StatusBadRequest,
+		},
+		{
+			name: "inconsistent counts",
+			metric: models.Metrics{
+				ID:    "latency",
+				MType: models.Histogram,
+				Histogram: &models.HistogramData{
+					Buckets: []float64{0.1, 0.5, 1},
+					Counts:  []uint64{1, 0, 0, 0},
+					Count:   3,
+				},
+			},
+			code: http.StatusBadRequest,
+		},
+		{
+			name:   "no data",
+			metric: models.Metrics{ID: "latency", MType: models.Histogram},
+			code:   http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.code, postJSON(tc.metric).Code)
+		})
+	}
+
+	response := httptest.NewRecorder()
+	r.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/update/histogram/latency/0.05", nil))
+	require.Equal(t, http.StatusOK, response.Code)
+
+	expected := models.HistogramData{
+		Buckets: []float64{0.1, 0.5, 1},
+		Counts:  []uint64{2, 2, 2, 1},
+		Count:   7,
+		Sum:     22.25,
+	}
+
+	t.Run("value by json", func(t *testing.T) {
+		rawData, err := json.Marshal(models.Metrics{ID: "latency", MType: models.Histogram})
+		require.NoError(t, err)
+
+		request := httptest.NewRequest(http.MethodPost, "/value", bytes.NewBuffer(rawData))
+		request.Header.Set("Content-Type", "application/json")
+		response := httptest.NewRecorder()
+		r.ServeHTTP(response, request)
+		require.Equal(t, http.StatusOK, response.Code)
+
+		var metric models.Metrics
+		require.NoError(t, json.Unmarshal(response.Body.Bytes(), &metric))
+		require.NotNil(t, metric.Histogram)
+		assert.Equal(t, expected.Counts, metric.Histogram.Counts)
+		assert.Equal(t, expected.Count, metric.Histogram.Count)
+		assert.InDelta(t, expected.Sum, metric.Histogram.Sum, 1e-9)
+	})
+
+	t.Run("value by url", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		r.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/value/histogram/latency", nil))
+		require.Equal(t, http.StatusOK, response.Code)
+
+		var histogram models.HistogramData
+		require.NoError(t, json.Unmarshal(response.Body.Bytes(), &histogram))
+		assert.Equal(t, expected.Buckets, histogram.Buckets)
+		assert.Equal(t, expected.Counts, histogram.Counts)
+	})
+}
+
+func TestPersistEveryMutationPath(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "metrics.txt")
+	fileService, err := services.NewFileService(filePath, 0)
+	require.NoError(t, err)
+	defer fileService.Close()
+
+	storage := store.NewPersistentStorage(getStorage(), fileService)
+	r := getRouter(services.NewMetricsService(storage))
+
+	testCases := []struct {
+		name string
+		url  string
+		body string
+	}{
+		{name: "url counter", url: "/update/counter/PollCount/2"},
+		{name: "url gauge", url: "/update/gauge/Alloc/1.5?host=web-1"},
+		{name: "json", url: "/update", body: `{"id":"PollCount","type":"counter","delta":3}`},
+		{name: "batch", url: "/updates", body: `[{"id":"Alloc","type":"gauge","value":2.5},{"id":"PollCount","type":"counter","delta":5}]`},
+		{name: "histogram", url: "/update/histogram/latency/0.2"},
+	}
+
+	for _, tc := range testCases {
+		request := httptest.NewRequest(http.MethodPost, tc.url, bytes.NewBufferString(tc.body))
+		request.Header.Set("Content-Type", "application/json")
+		response := httptest.NewRecorder()
+		r.ServeHTTP(response, request)
+		require.Equal(t, http.StatusOK, response.Code, tc.name)
+	}
+
+	data, err := fileService.ReadAllData(filePath)
+	require.NoError(t, err)
+	require.Len(t, data, 6)
+
+	snapshot, err := store.ReplayLog(data)
+	require.NoError(t, err)
+
+	restored := getStorage()
+	require.NoError(t, restored.Restore(snapshot))
+
+	expected, err := storage.GetAllMetrics()
+	require.NoError(t, err)
+	actual, err := restored.GetAllMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestDeleteAndResetMetrics(t *testing.T) {
+	for _, format := range []string{services.FormatJSON, services.FormatBinary} {
+		t.Run(format, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "metrics.txt")
+			fileService, err := services.NewFileService(filePath, 0)
+			require.NoError(t, err)
+			require.NoError(t, fileService.SetFormat(format))
+			defer fileService.Close()
+
+			storage := store.NewPersistentStorage(getStorage(), fileService)
+			r := getRouter(services.NewMetricsService(storage))
+
+			for _, path := range []string{
+				"/update/counter/PollCount/5",
+				"/update/counter/PollCount/2?host=web-1",
+				"/update/gauge/HeapAlloc/1.5",
+				"/update/gauge/HeapInuse/2.5",
+				"/update/gauge/Alloc/3",
+				"/update/histogram/latency/0.2",
+			} {
+				request := httptest.NewRequest(http.MethodPost, path, nil)
+				response := httptest.NewRecorder()
+				r.ServeHTTP(response, request)
+				require.Equal(t, http.StatusOK, response.Code, path)
+			}
+
+			testCases := []struct {
+				name         string
+				method       string
+				url          string
+				expectedCode int
+				expectedBody string
+			}{
+				{name: "delete", method: http.MethodDelete, url: "/value/counter/PollCount?host=web-1", expectedCode: http.StatusOK},
+				{name: "delete again", method: http.MethodDelete, url: "/value/counter/PollCount?host=web-1", expectedCode: http.StatusNotFound},
+				{name: "delete wrong type", method: http.MethodDelete, url: "/value/gauge/PollCount", expectedCode: http.StatusNotFound},
+				{name: "delete unknown type", method: http.MethodDelete, url: "/value/summary/PollCount", expectedCode: http.StatusBadRequest},
+				{name: "reset counter", method: http.MethodPost, url: "/reset/counter/PollCount", expectedCode: http.StatusOK},
+				{name: "reset histogram", method: http.MethodPost, url: "/reset/histogram/latency", expectedCode: http.StatusOK},
+				{name: "reset missing", method: http.MethodPost, url: "/reset/gauge/Missing", expectedCode: http.StatusNotFound},
+				{name: "reset bad labels", method: http.MethodPost, url: "/reset/gauge/Alloc?host=a&host=b", expectedCode: http.StatusBadRequest},
+				{
+					name:         "delete by prefix",
+					method:       http.MethodDelete,
+					url:          "/value/gauge?prefix=Heap",
+					expectedCode: http.StatusOK,
+					expectedBody: `[{"id":"HeapAlloc","type":"gauge","value":1.5},{"id":"HeapInuse","type":"gauge","value":2.5}]`,
+				},
+				{name: "delete by prefix nothing", method: http.MethodDelete, url: "/value/gauge?prefix=Heap", expectedCode: http.StatusOK, expectedBody: `[]`},
+				{name: "delete by prefix without prefix", method: http.MethodDelete, url: "/value/gauge", expectedCode: http.StatusBadRequest},
+				{
+					name:         "reset by prefix",
+					method:       http.MethodPost,
+					url:          "/reset/gauge?prefix=All",
+					expectedCode: http.StatusOK,
+					expectedBody: `[{"id":"Alloc","type":"gauge","value":3}]`,
+				},
+			}
+
+			for _, tc := range testCases {
+				request := httptest.NewRequest(tc.method, tc.url, nil)
+				response := httptest.NewRecorder()
+				r.ServeHTTP(response, request)
+
+				assert.Equal(t, tc.expectedCode, response.Code, tc.name)
+				if tc.expectedBody != "" {
+					assert.JSONEq(t, tc.expectedBody, response.Body.String(), tc.name)
+				}
+			}
+
+			metrics, err := storage.GetAllMetrics()
+			require.NoError(t, err)
+			require.Len(t, metrics, 3)
+
+			_, err = storage.GetCounter("PollCount", models.Labels{"host": "web-1"})
+			assert.ErrorIs(t, err, store.ErrMetricNotFound)
+			counter, err := storage.GetCounter("PollCount", nil)
+			require.NoError(t, err)
+			assert.Equal(t, int64(0), counter)
+			gauge, err := storage.GetGauge("Alloc", nil)
+			require.NoError(t, err)
+			assert.Equal(t, 0.0, gauge)
+			histogram, err := storage.GetHistogram("latency", nil)
+			require.NoError(t, err)
+			assert.Equal(t, uint64(0), histogram.Count)
+			assert.NotEmpty(t, histogram.Buckets)
+
+			data, err := fileService.ReadAllData(filePath)
+			require.NoError(t, err)
+
+			snapshot, err := store.ReplayLog(data)
+			require.NoError(t, err)
+
+			restored := getStorage()
+			require.NoError(t, restored.Restore(snapshot))
+
+			actual, err := restored.GetAllMetrics()
+			require.NoError(t, err)
+			assert.Equal(t, metrics, actual)
+		})
+	}
+}
+
+func TestSnapshotHandlers(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "metrics.txt")
+	fileService, err := services.NewFileService(filePath, 0)
+	require.NoError(t, err)
+	defer fileService.Close()
+
+	storage := store.NewPersistentStorage(getStorage(), fileService)
+	require.NoError(t, storage.AddCounter("PollCount", nil, 10))
+	require.NoError(t, storage.SetGauge("Alloc", nil, 1))
+	r := getRouter(services.NewMetricsService(storage))
+
+	request := httptest.NewRequest(http.MethodGet, "/snapshot", nil)
+	response := httptest.NewRecorder()
+	r.ServeHTTP(response, request)
+	require.Equal(t, http.StatusOK, response.Code)
+
+	var exported store.Snapshot
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &exported))
+	assert.Len(t, exported.Metrics, 2)
+
+	testCases := []struct {
+		name     string
+		query    string
+		body     string
+		code     int
+		expected int64
+	}{
+		{name: "merge by default", body: `{"version":1,"metrics":[{"id":"PollCount","type":"counter","delta":5}]}`, code: http.StatusOK, expected: 15},
+		{name: "overwrite", query: "?mode=overwrite", body: `{"version":1,"metrics":[{"id":"PollCount","type":"counter","delta":5}]}`, code: http.StatusOK, expected: 5},
+		{name: "unknown mode", query: "?mode=append", body: `{"version":1,"metrics":[]}`, code: http.StatusBadRequest, expected: 5},
+		{name: "bad version", body: `{"version":9,"metrics":[]}`, code: http.StatusBadRequest, expected: 5},
+		{name: "invalid metric", body: `{"version":1,"metrics":[{"id":"PollCount","type":"counter"}]}`, code: http.StatusBadRequest, expected: 5},
+		{name: "bad json", body: `{`, code: http.StatusBadRequest, expected: 5},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/snapshot"+tc.query, bytes.NewBufferString(tc.body))
+			request.Header.Set("Content-Type", "application/json")
+			response := httptest.NewRecorder()
+			r.ServeHTTP(response, request)
+			require.Equal(t, tc.code, response.Code, response.Body.String())
+
+			value, err := storage.GetCounter("PollCount", nil)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, value)
+		})
+	}
+
+	// Загруженный срез попадает в журнал и переживает перезапуск.
+	data, err := fileService.ReadAllData(filePath)
+	require.NoError(t, err)
+	snapshot, err := store.ReplayLog(data)
+	require.NoError(t, err)
+	restored := getStorage()
+	require.NoError(t, restored.Restore(snapshot))
+
+	value, err := restored.GetCounter("PollCount", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), value)
+	_, err = restored.GetGauge("Alloc", nil)
+	assert.ErrorIs(t, err, store.ErrMetricNotFound)
+}
+
+func TestReadiness(t *testing.T) {
+	service := services.NewMetricsService(getStorage())
+	r := getRouter(service)
+
+	send := func(method string, url string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, url, nil)
+		response := httptest.NewRecorder()
+		r.ServeHTTP(response, request)
+		return response
+	}
+
+	service.Readiness().StartRestore()
+	service.Readiness().SetProgress(services.RestoreReplaying, 10, 40)
+
+	assert.Equal(t, http.StatusOK, send(http.MethodGet, "/healthz").Code)
+
+	response := send(http.MethodGet, "/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, response.Code)
+
+	var status struct {
+		Status  string                   `json:"status"`
+		Restore services.RestoreProgress `json:"restore"`
+	}
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &status))
+	assert.Equal(t, "restoring", status.Status)
+	assert.Equal(t, services.RestoreReplaying, status.Restore.Phase)
+	assert.Equal(t, 10, status.Restore.Done)
+	assert.Equal(t, 40, status.Restore.Total)
+
+	response = send(http.MethodPost, "/update/counter/PollCount/1")
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+	assert.Equal(t, "1", response.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusServiceUnavailable, send(http.MethodPost, "/updates").Code)
+	assert.Equal(t, http.StatusNotFound, send(http.MethodGet, "/value/counter/PollCount").Code)
+
+	service.Readiness().FinishRestore()
+
+	response = send(http.MethodGet, "/readyz")
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"status":"ready"}`, response.Body.String())
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, "/update/counter/PollCount/1").Code)
+}
@@ -0,0 +1,129 @@
+package agent
+
+import (
+	"context"
+	pb "github.com/Oresst/goMetrics/internal/proto"
+	"github.com/Oresst/goMetrics/models"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"strconv"
+	"time"
+)
+
+// GRPCMetricsSender отправляет метрики в gRPC-сервис сервера вместо HTTP.
+type GRPCMetricsSender struct {
+	conn    *grpc.ClientConn
+	client  pb.MetricsClient
+	timeout time.Duration
+}
+
+func NewGRPCMetricsSender(address string) (*GRPCMetricsSender, error) {
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+
+	return &GRPCMetricsSender{
+		conn:    conn,
+		client:  pb.NewMetricsClient(conn),
+		timeout: 1 * time.Second,
+	}, nil
+}
+
+func (g *GRPCMetricsSender) Close() error {
+	return g.conn.Close()
+}
+
+func (g *GRPCMetricsSender) SendGaugeMetric(metricName string, metricValue string) {
+	g.SendMetricJSON(metricName, models.Gauge, metricValue)
+}
+
+func (g *GRPCMetricsSender) SendCountMetric(metricName string, metricValue int) {
+	delta := int64(metricValue)
+	g.send("[GRPCMetricsSender.SendCountMetric]", &pb.Metric{Id: metricName, Type: models.Counter, Delta: &delta})
+}
+
+func (g *GRPCMetricsSender) SendMetricJSON(metricName string, metricType string, value string) {
+	place := "[GRPCMetricsSender.SendMetricJSON]"
+
+	metricValue, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"metric":     metricName,
+			"value":      value,
+			"place":      place,
+			"metricType": metricType,
+			"error":      err.Error(),
+		}).Error("Ошибка парсинга str -> float64")
+		return
+	}
+
+	metric := &pb.Metric{Id: metricName, Type: metricType}
+	if metricType == models.Counter {
+		delta := int64(metricValue)
+		metric.Delta = &delta
+	} else {
+		metric.Value = &metricValue
+	}
+
+	g.send(place, metric)
+}
+
+func (g *GRPCMetricsSender) SendHistogramJSON(metricName string, observations []float64) error {
+	err := g.send("[GRPCMetricsSender.SendHistogramJSON]", &pb.Metric{
+		Id:           metricName,
+		Type:         models.Histogram,
+		Observations: observations,
+	})
+
+	// Как у HTTP-отправителя: повторять стоит только то, что сервер не смог принять.
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal:
+		return err
+	default:
+		return nil
+	}
+}
+
+// send вызывает UpdateMetric и повторяет вызов, пока сервер недоступен, как retryHTTP.
+func (g *GRPCMetricsSender) send(place string, metric *pb.Metric) error {
+	var err error
+	for attempt := 1; attempt <= 3; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), g.timeout)
+		_, err = g.client.UpdateMetric(ctx, &pb.UpdateMetricRequest{Metric: metric})
+		cancel()
+
+		if status.Code(err) != codes.Unavailable {
+			break
+		}
+
+		log.WithFields(log.Fields{
+			"metricName": metric.GetId(),
+			"attempt":    attempt,
+			"place":      place,
+			"error":      err.Error(),
+		}).Info("retry to send request")
+
+		time.Sleep(300 * time.Microsecond)
+	}
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"metricName": metric.GetId(),
+			"metricType": metric.GetType(),
+			"place":      place,
+			"error":      err.Error(),
+		}).Error("Failed to send metric")
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"metricName": metric.GetId(),
+		"metricType": metric.GetType(),
+		"place":      place,
+	}).Info("Sent metric")
+	return nil
+}
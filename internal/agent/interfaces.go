@@ -5,10 +5,15 @@ type StatsStore interface {
 	GetCountMetrics() map[string]int
 	UpdateGaugeMetrics(metrics map[string]string)
 	IncreaseCountMetric(metricName string, by int)
+	ObserveHistogram(metricName string, values ...float64)
+	// TakeHistograms возвращает накопленные наблюдения и очищает их.
+	TakeHistograms() map[string][]float64
 }
 
 type StatsSender interface {
 	SendGaugeMetric(metricName string, metricValue string)
 	SendCountMetric(metricName string, metricValue int)
 	SendMetricJSON(metricName string, metricType string, value string)
+	// SendHistogramJSON возвращает ошибку, если сервер недоступен и наблюдения нужно отправить повторно.
+	SendHistogramJSON(metricName string, observations []float64) error
 }
@@ -14,16 +14,18 @@ import (
 )
 
 type InMemoryMetricsStore struct {
-	gaugeMetrics map[string]string
-	countMetrics map[string]int
+	gaugeMetrics     map[string]string
+	countMetrics     map[string]int
+	histogramMetrics map[string][]float64
 
 	sync.RWMutex
 }
 
 func NewInMemoryMetricsStore() *InMemoryMetricsStore {
 	return &InMemoryMetricsStore{
-		gaugeMetrics: make(map[string]string),
-		countMetrics: make(map[string]int),
+		gaugeMetrics:     make(map[string]string),
+		countMetrics:     make(map[string]int),
+		histogramMetrics: make(map[string][]float64),
 	}
 }
 
@@ -54,6 +56,23 @@ func (i *InMemoryMetricsStore) IncreaseCountMetric(metricName string, by int) {
 	}
 }
 
+func (i *InMemoryMetricsStore) ObserveHistogram(metricName string, values ...float64) {
+	i.Lock()
+	defer i.Unlock()
+
+	i.histogramMetrics[metricName] = append(i.histogramMetrics[metricName], values...)
+}
+
+func (i *InMemoryMetricsStore) TakeHistograms() map[string][]float64 {
+	i.Lock()
+	defer i.Unlock()
+
+	taken := i.histogramMetrics
+	i.histogramMetrics = make(map[string][]float64)
+
+	return taken
+}
+
 func (i *InMemoryMetricsStore) GetGaugeMetrics() map[string]string {
 	i.Lock()
 	defer i.Unlock()
@@ -114,43 +133,8 @@ func (h *HTTPMetricsSender) SendMetricJSON(metricName string, metricType string,
 		requestBody.Value = metricValue
 	}
 
-	var rawRequestBody []byte
-	rawRequestBody, err = json.Marshal(requestBody)
-	if err != nil {
-		log.WithFields(log.Fields{
-			"place": place,
-			"error": err.Error(),
-		}).Error("Ошибка сериализации JSON")
-	}
-
-	buffered := bytes.NewBuffer(nil)
-	zb := gzip.NewWriter(buffered)
-	_, err = zb.Write(rawRequestBody)
-	if err != nil {
-		log.WithFields(log.Fields{
-			"place": place,
-			"error": err.Error(),
-		}).Error("Ошибка компрессии данных")
-		return
-	}
-	zb.Close()
-
-	var request *http.Request
-	request, err = http.NewRequest(http.MethodPost, url, buffered)
-	if err != nil {
-		log.WithFields(log.Fields{
-			"place": place,
-			"error": err.Error(),
-		}).Error("Ошибка создания Request")
-		return
-	}
-
-	request.Header.Set("Content-Encoding", "gzip")
-	request.Header.Set("Content-Type", "application/json")
-	request.Header.Set("Accept-Encoding", "")
-
 	var response *http.Response
-	response, err = h.retryHTTP(request, 3, 300*time.Microsecond)()
+	response, err = h.postJSON(url, requestBody)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"metricName":  metricName,
@@ -174,6 +158,78 @@ func (h *HTTPMetricsSender) SendMetricJSON(metricName string, metricType string,
 	}).Info("Sent metric")
 }
 
+func (h *HTTPMetricsSender) SendHistogramJSON(metricName string, observations []float64) error {
+	place := "[HTTPMetricsSender.SendHistogramJSON]"
+	url := fmt.Sprintf("%s/update", h.url)
+
+	requestBody := models.Metrics{
+		ID:           metricName,
+		MType:        models.Histogram,
+		Observations: observations,
+	}
+
+	response, err := h.postJSON(url, requestBody)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"metricName":   metricName,
+			"observations": len(observations),
+			"url":          url,
+			"error":        err.Error(),
+			"place":        place,
+		}).Error("Failed to send metric")
+		return err
+	}
+	defer response.Body.Close()
+
+	// 5xx — сервер не принял наблюдения (например, идёт восстановление), их стоит отправить позже.
+	// Отклонённые с 4xx наблюдения повторять бессмысленно.
+	if response.StatusCode >= http.StatusInternalServerError {
+		log.WithFields(log.Fields{
+			"metricName":   metricName,
+			"observations": len(observations),
+			"url":          url,
+			"place":        place,
+			"statusCode":   response.StatusCode,
+		}).Error("Failed to send metric")
+		return fmt.Errorf("server responded with status %d", response.StatusCode)
+	}
+
+	log.WithFields(log.Fields{
+		"metricName":   metricName,
+		"observations": len(observations),
+		"url":          url,
+		"place":        place,
+		"statusCode":   response.StatusCode,
+	}).Info("Sent metric")
+	return nil
+}
+
+// postJSON отправляет тело в JSON, сжатое gzip, с повторами.
+func (h *HTTPMetricsSender) postJSON(url string, body any) (*http.Response, error) {
+	rawRequestBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка сериализации JSON: %w", err)
+	}
+
+	buffered := bytes.NewBuffer(nil)
+	zb := gzip.NewWriter(buffered)
+	if _, err = zb.Write(rawRequestBody); err != nil {
+		return nil, fmt.Errorf("ошибка компрессии данных: %w", err)
+	}
+	zb.Close()
+
+	request, err := http.NewRequest(http.MethodPost, url, buffered)
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Content-Encoding", "gzip")
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept-Encoding", "")
+
+	return h.retryHTTP(request, 3, 300*time.Microsecond)()
+}
+
 func (h *HTTPMetricsSender) SendGaugeMetric(metricName string, metricValue string) {
 	place := "[HTTPMetricsSender.SendGaugeMetric]"
 	url := fmt.Sprintf("%s/update/gauge/%s/%s", h.url, metricName, metricValue)
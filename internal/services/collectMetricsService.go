@@ -76,6 +76,7 @@ func (s *CollectMetricsService) CollectStats() {
 
 	gaugeMetrics := make(map[string]string)
 	var memStats runtime.MemStats
+	var lastNumGC uint32
 
 	for {
 		runtime.ReadMemStats(&memStats)
@@ -112,6 +113,11 @@ func (s *CollectMetricsService) CollectStats() {
 		s.store.UpdateGaugeMetrics(gaugeMetrics)
 		s.store.IncreaseCountMetric("PollCount", 1)
 
+		if pauses := gcPauses(&memStats, lastNumGC); len(pauses) > 0 {
+			s.store.ObserveHistogram("GCPauseSeconds", pauses...)
+		}
+		lastNumGC = memStats.NumGC
+
 		select {
 		case <-s.WaitCollectStats:
 			log.Info("stop collect metrics")
@@ -148,6 +154,18 @@ func (s *CollectMetricsService) SendStats() {
 			}(key, value)
 		}
 
+		// Наблюдения, которые не удалось отправить, возвращаются в хранилище до следующей отправки.
+		for key, observations := range s.store.TakeHistograms() {
+			wg.Add(1)
+
+			go func(metricName string, observations []float64) {
+				defer wg.Done()
+				if err := s.sender.SendHistogramJSON(metricName, observations); err != nil {
+					s.store.ObserveHistogram(metricName, observations...)
+				}
+			}(key, observations)
+		}
+
 		wg.Wait()
 
 		select {
@@ -159,3 +177,20 @@ func (s *CollectMetricsService) SendStats() {
 		}
 	}
 }
+
+// gcPauses возвращает длительности пауз GC (в секундах), случившихся после lastNumGC.
+// PauseNs — кольцевой буфер на 256 записей, более старые паузы теряются.
+func gcPauses(memStats *runtime.MemStats, lastNumGC uint32) []float64 {
+	count := memStats.NumGC - lastNumGC
+	if count > uint32(len(memStats.PauseNs)) {
+		count = uint32(len(memStats.PauseNs))
+	}
+
+	pauses := make([]float64, 0, count)
+	for i := memStats.NumGC - count; i < memStats.NumGC; i++ {
+		pause := memStats.PauseNs[i%uint32(len(memStats.PauseNs))]
+		pauses = append(pauses, time.Duration(pause).Seconds())
+	}
+
+	return pauses
+}
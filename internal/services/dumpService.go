@@ -0,0 +1,224 @@
+package services
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Oresst/goMetrics/internal/store"
+	"github.com/Oresst/goMetrics/models"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Форматы выгрузки состояния хранилища.
+// json — store.Snapshot целиком, csv — строка на ряд, file — файл в формате FileService.
+const (
+	DumpJSON = "json"
+	DumpCSV  = "csv"
+	DumpFile = "file"
+)
+
+// Режимы загрузки выгрузки: merge добавляет её к текущим данным
+// (счётчики суммируются, гауджи заменяются), overwrite заменяет данные целиком.
+const (
+	ImportMerge     = "merge"
+	ImportOverwrite = "overwrite"
+)
+
+var ErrUnsupportedDump = errors.New("unsupported dump format")
+
+var csvHeader = []string{"type", "id", "labels", "delta", "value", "histogram"}
+
+// WriteDump выгружает срез в формате json или csv.
+func WriteDump(w io.Writer, format string, snapshot store.Snapshot) error {
+	switch format {
+	case DumpJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(snapshot)
+	case DumpCSV:
+		return writeCSVDump(w, snapshot.Metrics)
+	default:
+		return fmt.Errorf("%w: %q", ErrUnsupportedDump, format)
+	}
+}
+
+// ReadDump читает выгрузку в формате json или csv.
+func ReadDump(r io.Reader, format string) (store.Snapshot, error) {
+	switch format {
+	case DumpJSON:
+		var snapshot store.Snapshot
+		err := json.NewDecoder(r).Decode(&snapshot)
+		return snapshot, err
+	case DumpCSV:
+		metrics, err := readCSVDump(r)
+		if err != nil {
+			return store.Snapshot{}, err
+		}
+
+		return store.Snapshot{Version: store.SnapshotVersion, CreatedAt: time.Now().UTC(), Metrics: metrics}, nil
+	default:
+		return store.Snapshot{}, fmt.Errorf("%w: %q", ErrUnsupportedDump, format)
+	}
+}
+
+// ApplyDump загружает срез в хранилище в режиме merge или overwrite.
+func ApplyDump(storage store.Store, snapshot store.Snapshot, mode string) error {
+	switch mode {
+	case ImportMerge:
+		return store.MergeSnapshot(storage, snapshot)
+	case ImportOverwrite:
+		return storage.Restore(snapshot)
+	default:
+		return fmt.Errorf("неизвестный режим загрузки %q", mode)
+	}
+}
+
+// Метки и гистограмма записываются в csv как JSON, пустые значения — пустой строкой.
+func writeCSVDump(w io.Writer, metrics []models.Metrics) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+
+	for _, metric := range metrics {
+		record := []string{metric.MType, metric.ID, "", "", "", ""}
+
+		if len(metric.Labels) > 0 {
+			labels, err := json.Marshal(metric.Labels)
+			if err != nil {
+				return err
+			}
+			record[2] = string(labels)
+		}
+
+		if metric.Delta != nil {
+			record[3] = strconv.FormatInt(*metric.Delta, 10)
+		}
+
+		if metric.Value != nil {
+			record[4] = strconv.FormatFloat(*metric.Value, 'g', -1, 64)
+		}
+
+		if metric.Histogram != nil {
+			histogram, err := json.Marshal(metric.Histogram)
+			if err != nil {
+				return err
+			}
+			record[5] = string(histogram)
+		}
+
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func readCSVDump(r io.Reader) ([]models.Metrics, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = len(csvHeader)
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, errors.New("пустой csv без заголовка")
+	}
+
+	for i, column := range csvHeader {
+		if records[0][i] != column {
+			return nil, fmt.Errorf("неверный заголовок csv: %v", records[0])
+		}
+	}
+
+	metrics := make([]models.Metrics, 0, len(records)-1)
+	for i, record := range records[1:] {
+		metric := models.Metrics{MType: record[0], ID: record[1]}
+
+		if record[2] != "" {
+			if err = json.Unmarshal([]byte(record[2]), &metric.Labels); err != nil {
+				return nil, fmt.Errorf("строка %d: метки: %w", i+2, err)
+			}
+		}
+
+		if record[3] != "" {
+			delta, err := strconv.ParseInt(record[3], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("строка %d: delta: %w", i+2, err)
+			}
+			metric.Delta = &delta
+		}
+
+		if record[4] != "" {
+			value, err := strconv.ParseFloat(record[4], 64)
+			if err != nil {
+				return nil, fmt.Errorf("строка %d: value: %w", i+2, err)
+			}
+			if !isFinite(value) {
+				return nil, fmt.Errorf("строка %d: value должно быть конечным числом", i+2)
+			}
+			metric.Value = &value
+		}
+
+		if record[5] != "" {
+			metric.Histogram = &models.HistogramData{}
+			if err = json.Unmarshal([]byte(record[5]), metric.Histogram); err != nil {
+				return nil, fmt.Errorf("строка %d: histogram: %w", i+2, err)
+			}
+		}
+
+		metrics = append(metrics, metric)
+	}
+
+	return metrics, nil
+}
+
+// ReadFileDump восстанавливает срез из файла журнала или выгрузки в формате FileService.
+// Файлы только читаются: повреждённые записи пропускаются или прерывают чтение в режиме strict.
+func ReadFileDump(fileName string, recovery string) (store.Snapshot, LoadReport, error) {
+	if recovery == RecoveryQuarantine {
+		recovery = RecoverySkip
+	}
+
+	records, report, err := (&FileService{}).loadPersisted(fileName, recovery, false)
+	if err != nil {
+		return store.Snapshot{}, report, err
+	}
+
+	snapshot, err := store.ReplayLog(records)
+	return snapshot, report, err
+}
+
+// WriteFileDump атомарно записывает срез в файл в формате FileService.
+// Если у файла уже есть сегменты, срез пишется снимком поверх них, как делает сервер с ротацией.
+func WriteFileDump(fileName string, format string, snapshot store.Snapshot) error {
+	last, err := lastSegmentNumber(fileName)
+	if err != nil {
+		return err
+	}
+
+	fileService, err := NewFileService(fileName, 0)
+	if err != nil {
+		return err
+	}
+	defer fileService.Close()
+
+	if err = fileService.SetFormat(format); err != nil {
+		return err
+	}
+
+	if last > 0 {
+		if err = fileService.SetRotation(RotationConfig{}); err != nil {
+			return err
+		}
+	}
+
+	return fileService.Rewrite(snapshot.Metrics)
+}
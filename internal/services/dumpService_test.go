@@ -0,0 +1,112 @@
+package services
+
+import (
+	"bytes"
+	"github.com/Oresst/goMetrics/internal/store"
+	"github.com/Oresst/goMetrics/internal/utils"
+	"github.com/Oresst/goMetrics/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+)
+
+func TestDumpRoundTrip(t *testing.T) {
+	source := store.NewMemStorage()
+	require.NoError(t, source.AddCounter("PollCount", nil, 5))
+	require.NoError(t, source.AddCounter("PollCount", models.Labels{"host": "web-1"}, 7))
+	require.NoError(t, source.SetGauge("Alloc", nil, 1.25))
+	require.NoError(t, source.AddHistogram("latency", nil, models.HistogramData{
+		Buckets: []float64{0.1, 1},
+		Counts:  []uint64{1, 2, 0},
+		Count:   3,
+		Sum:     1.3,
+	}))
+
+	snapshot, err := source.Snapshot()
+	require.NoError(t, err)
+
+	for _, format := range []string{DumpJSON, DumpCSV} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, WriteDump(&buf, format, snapshot))
+
+			dump, err := ReadDump(&buf, format)
+			require.NoError(t, err)
+
+			expected := snapshot.Metrics
+			if format == DumpCSV {
+				// CSV не хранит время обновления рядов.
+				expected = make([]models.Metrics, len(snapshot.Metrics))
+				for i, metric := range snapshot.Metrics {
+					metric.Timestamp = 0
+					expected[i] = metric
+				}
+			}
+			assert.Equal(t, expected, dump.Metrics)
+		})
+	}
+
+	t.Run(DumpFile, func(t *testing.T) {
+		filePath := filepath.Join(t.TempDir(), "dump.bin")
+		require.NoError(t, WriteFileDump(filePath, FormatBinary, snapshot))
+
+		dump, _, err := ReadFileDump(filePath, RecoveryStrict)
+		require.NoError(t, err)
+		assert.Equal(t, snapshot.Metrics, dump.Metrics)
+	})
+
+	_, err = ReadDump(bytes.NewBufferString(""), "xml")
+	assert.ErrorIs(t, err, ErrUnsupportedDump)
+}
+
+func TestApplyDump(t *testing.T) {
+	dump := store.Snapshot{
+		Version: store.SnapshotVersion,
+		Metrics: []models.Metrics{
+			{ID: "Alloc", MType: models.Gauge, Value: utils.PointFloat64(2.5)},
+			{ID: "PollCount", MType: models.Counter, Delta: utils.PointInt64(3)},
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		mode     string
+		expected []models.Metrics
+	}{
+		{
+			name: "merge",
+			mode: ImportMerge,
+			expected: []models.Metrics{
+				{ID: "Alloc", MType: models.Gauge, Value: utils.PointFloat64(2.5)},
+				{ID: "HeapAlloc", MType: models.Gauge, Value: utils.PointFloat64(7)},
+				{ID: "PollCount", MType: models.Counter, Delta: utils.PointInt64(13)},
+			},
+		},
+		{
+			name: "overwrite",
+			mode: ImportOverwrite,
+			expected: []models.Metrics{
+				{ID: "Alloc", MType: models.Gauge, Value: utils.PointFloat64(2.5)},
+				{ID: "PollCount", MType: models.Counter, Delta: utils.PointInt64(3)},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			storage := store.NewMemStorage()
+			require.NoError(t, storage.AddCounter("PollCount", nil, 10))
+			require.NoError(t, storage.SetGauge("Alloc", nil, 1))
+			require.NoError(t, storage.SetGauge("HeapAlloc", nil, 7))
+
+			require.NoError(t, ApplyDump(storage, dump, tc.mode))
+
+			actual, err := storage.GetAllMetrics()
+			require.NoError(t, err)
+			assert.ElementsMatch(t, tc.expected, actual)
+		})
+	}
+
+	assert.Error(t, ApplyDump(store.NewMemStorage(), dump, "append"))
+}
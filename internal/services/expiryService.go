@@ -0,0 +1,71 @@
+package services
+
+import (
+	"github.com/Oresst/goMetrics/internal/store"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// ExpiryService периодически удаляет метрики, которые не обновлялись дольше ttl.
+type ExpiryService struct {
+	storage      store.Store
+	ttl          time.Duration
+	interval     time.Duration
+	keepCounters bool
+	stopChan     chan bool
+}
+
+func NewExpiryService(
+	storage store.Store,
+	ttl time.Duration,
+	interval time.Duration,
+	keepCounters bool,
+) *ExpiryService {
+	return &ExpiryService{
+		storage:      storage,
+		ttl:          ttl,
+		interval:     interval,
+		keepCounters: keepCounters,
+		stopChan:     make(chan bool),
+	}
+}
+
+func (e *ExpiryService) Run() {
+	go func() {
+		for {
+			select {
+			case <-e.stopChan:
+				return
+			case <-time.After(e.interval):
+				e.Sweep()
+			}
+		}
+	}()
+}
+
+func (e *ExpiryService) Stop() {
+	e.stopChan <- true
+}
+
+// Sweep удаляет устаревшие метрики из хранилища. Удаление проходит через обёртки хранилища,
+// поэтому попадает в журнал и в поток событий так же, как DeleteMetric.
+func (e *ExpiryService) Sweep() int {
+	place := "[ExpiryService.Sweep]"
+
+	removed, err := e.storage.RemoveStale(time.Now().Add(-e.ttl), e.keepCounters)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"place": place,
+			"error": err.Error(),
+		}).Error("Ошибка удаления устаревших метрик")
+		return 0
+	}
+
+	log.WithFields(log.Fields{
+		"place":   place,
+		"evicted": len(removed),
+		"ttl":     e.ttl,
+	}).Info("Устаревшие метрики удалены")
+
+	return len(removed)
+}
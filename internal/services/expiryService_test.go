@@ -0,0 +1,119 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/Oresst/goMetrics/internal/store"
+	"github.com/Oresst/goMetrics/internal/utils"
+	"github.com/Oresst/goMetrics/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExpiryServiceSweep(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "metrics.txt")
+	fileService, err := NewFileService(filePath, 0)
+	require.NoError(t, err)
+	defer fileService.Close()
+
+	storage := store.NewPersistentStorage(store.NewMemStorage(), fileService)
+	service := NewMetricsService(storage)
+	r := newTestRouter(service)
+
+	for _, metric := range []models.Metrics{
+		{ID: "stale", MType: models.Gauge, Value: utils.PointFloat64(1)},
+		{ID: "PollCount", MType: models.Counter, Delta: utils.PointInt64(3)},
+	} {
+		rawData, err := json.Marshal(metric)
+		require.NoError(t, err)
+
+		request := httptest.NewRequest(http.MethodPost, "/update", bytes.NewBuffer(rawData))
+		request.Header.Set("Content-Type", "application/json")
+		response := httptest.NewRecorder()
+		r.ServeHTTP(response, request)
+		require.Equal(t, http.StatusOK, response.Code)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	expiryService := NewExpiryService(storage, time.Millisecond, time.Minute, true)
+	assert.Equal(t, 1, expiryService.Sweep())
+
+	response := httptest.NewRecorder()
+	r.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/value/gauge/stale", nil))
+	assert.Equal(t, http.StatusNotFound, response.Code)
+
+	data, err := fileService.ReadAllData(filePath)
+	require.NoError(t, err)
+	require.Len(t, data, 3)
+	assert.Equal(t, models.OpDelete, data[2].Op)
+	assert.Equal(t, "stale", data[2].ID)
+
+	snapshot, err := store.ReplayLog(data)
+	require.NoError(t, err)
+	require.Len(t, snapshot.Metrics, 1)
+	assert.Equal(t, "PollCount", snapshot.Metrics[0].ID)
+	assert.Equal(t, int64(3), *snapshot.Metrics[0].Delta)
+}
+
+// Записи, принятые во время удаления устаревших метрик, не теряются из журнала.
+func TestExpiryServiceSweepConcurrentWrites(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "metrics.txt")
+	fileService, err := NewFileService(filePath, time.Millisecond)
+	require.NoError(t, err)
+	fileService.Run()
+
+	storage := store.NewPersistentStorage(store.NewMemStorage(), fileService)
+	expiryService := NewExpiryService(storage, time.Millisecond, time.Minute, false)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; ; j++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				assert.NoError(t, storage.SetGauge("gauge"+strconv.Itoa(j%50), nil, float64(i*1000+j)))
+				assert.NoError(t, storage.AddCounter("PollCount", models.Labels{"worker": strconv.Itoa(i)}, 1))
+			}
+		}(i)
+	}
+
+	for j := 0; j < 10; j++ {
+		expiryService.Sweep()
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	wg.Wait()
+
+	require.NoError(t, fileService.Close())
+
+	data, err := fileService.ReadAllData(filePath)
+	require.NoError(t, err)
+
+	snapshot, err := store.ReplayLog(data)
+	require.NoError(t, err)
+
+	replayed := store.NewMemStorage()
+	require.NoError(t, replayed.Restore(snapshot))
+
+	expected, err := storage.GetAllMetrics()
+	require.NoError(t, err)
+	actual, err := replayed.GetAllMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
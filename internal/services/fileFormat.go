@@ -0,0 +1,589 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Oresst/goMetrics/models"
+	log "github.com/sirupsen/logrus"
+	"hash/crc32"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// Форматы файла журнала.
+const (
+	FormatJSON   = "json"
+	FormatBinary = "binary"
+)
+
+// Бинарный файл начинается с binaryMagic и байта версии, дальше идут записи:
+// длина uvarint, CRC-32C тела (4 байта, little-endian) и тело записи (см. appendRecord у binaryCodec).
+// В версии 1 контрольной суммы нет, в версиях 1 и 2 нет времени приёма, до версии 4 нет записей удаления
+// и сброса, до версии 5 записи состояния не отмечены; такие файлы по-прежнему читаются.
+// Строка JSON-файла — запись, табуляция и CRC-32C записи в hex; строки без суммы тоже читаются.
+const (
+	binaryVersion            = 5
+	binaryVersionNoTimestamp = 2
+	binaryVersionNoChecksum  = 1
+	maxRecordLength          = 16 << 20
+)
+
+var binaryMagic = []byte("GMTR")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var ErrUnsupportedFormat = errors.New("unsupported file format")
+
+// recordCodec кодирует записи журнала в одном из форматов файла.
+type recordCodec interface {
+	format() string
+	header() []byte
+	appendRecord(dst []byte, metric models.Metrics) ([]byte, error)
+}
+
+func newRecordCodec(format string) (recordCodec, error) {
+	switch format {
+	case FormatJSON:
+		return jsonCodec{}, nil
+	case FormatBinary:
+		return binaryCodec{}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedFormat, format)
+	}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) format() string {
+	return FormatJSON
+}
+
+func (jsonCodec) header() []byte {
+	return nil
+}
+
+func (jsonCodec) appendRecord(dst []byte, metric models.Metrics) ([]byte, error) {
+	data, err := json.Marshal(metric)
+	if err != nil {
+		return nil, err
+	}
+
+	dst = append(dst, data...)
+	dst = append(dst, '\t')
+	dst = fmt.Appendf(dst, "%08x", crc32.Checksum(data, crcTable))
+	return append(dst, '\n'), nil
+}
+
+const (
+	binaryCounter byte = iota + 1
+	binaryGauge
+	binaryHistogram
+	binaryDelete
+	binaryReset
+)
+
+// binaryState в байте типа отмечает запись состояния (models.OpState).
+const binaryState byte = 0x80
+
+type binaryCodec struct{}
+
+func (binaryCodec) format() string {
+	return FormatBinary
+}
+
+func (binaryCodec) header() []byte {
+	return append(append([]byte{}, binaryMagic...), binaryVersion)
+}
+
+// appendRecord пишет тип, имя, метки (отсортированные по имени), значение и время приёма varint:
+// delta — varint, value — 8 байт IEEE 754, гистограмма — корзины, счётчики корзин, count и sum.
+// У записи удаления или сброса вместо значения один байт с типом ряда.
+func (binaryCodec) appendRecord(dst []byte, metric models.Metrics) ([]byte, error) {
+	body := make([]byte, 0, 64)
+
+	switch {
+	case metric.Op == models.OpDelete:
+		body = append(body, binaryDelete)
+	case metric.Op == models.OpReset:
+		body = append(body, binaryReset)
+	case metric.MType == models.Counter && metric.Delta != nil:
+		body = append(body, binaryCounter)
+	case metric.MType == models.Gauge && metric.Value != nil:
+		body = append(body, binaryGauge)
+	case metric.MType == models.Histogram && metric.Histogram != nil:
+		body = append(body, binaryHistogram)
+	default:
+		return nil, fmt.Errorf("нельзя закодировать метрику %s типа %s", metric.ID, metric.MType)
+	}
+	kind := body[0]
+	if metric.Op == models.OpState {
+		body[0] |= binaryState
+	}
+
+	body = appendString(body, metric.ID)
+
+	names := make([]string, 0, len(metric.Labels))
+	for name := range metric.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	body = binary.AppendUvarint(body, uint64(len(names)))
+	for _, name := range names {
+		body = appendString(body, name)
+		body = appendString(body, metric.Labels[name])
+	}
+
+	switch kind {
+	case binaryCounter:
+		body = binary.AppendVarint(body, *metric.Delta)
+	case binaryGauge:
+		body = binary.LittleEndian.AppendUint64(body, math.Float64bits(*metric.Value))
+	case binaryHistogram:
+		h := metric.Histogram
+		body = binary.AppendUvarint(body, uint64(len(h.Buckets)))
+		for _, bound := range h.Buckets {
+			body = binary.LittleEndian.AppendUint64(body, math.Float64bits(bound))
+		}
+		body = binary.AppendUvarint(body, uint64(len(h.Counts)))
+		for _, count := range h.Counts {
+			body = binary.AppendUvarint(body, count)
+		}
+		body = binary.AppendUvarint(body, h.Count)
+		body = binary.LittleEndian.AppendUint64(body, math.Float64bits(h.Sum))
+	case binaryDelete, binaryReset:
+		kind, ok := binaryKinds[metric.MType]
+		if !ok {
+			return nil, fmt.Errorf("нельзя закодировать метрику %s типа %s", metric.ID, metric.MType)
+		}
+		body = append(body, kind)
+	}
+	body = binary.AppendVarint(body, metric.Timestamp)
+
+	dst = binary.AppendUvarint(dst, uint64(len(body)))
+	dst = binary.LittleEndian.AppendUint32(dst, crc32.Checksum(body, crcTable))
+	return append(dst, body...), nil
+}
+
+var binaryKinds = map[string]byte{
+	models.Counter:   binaryCounter,
+	models.Gauge:     binaryGauge,
+	models.Histogram: binaryHistogram,
+}
+
+func appendString(dst []byte, value string) []byte {
+	dst = binary.AppendUvarint(dst, uint64(len(value)))
+	return append(dst, value...)
+}
+
+// migrate перекодирует в текущий формат активный файл, сегменты и снимки. Вызывается под fileMu.
+func (f *FileService) migrate() error {
+	files, err := scanSegments(f.fileName)
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(files)+1)
+	for _, file := range files {
+		paths = append(paths, file.path)
+	}
+	paths = append(paths, f.fileName)
+
+	for _, path := range paths {
+		decoded, err := readFileRecords(path)
+		if err != nil {
+			return err
+		}
+
+		records := decoded.records
+		if (decoded.format == f.codec.format() && !decoded.outdated) || len(records) == 0 {
+			continue
+		}
+
+		// Перекодированный файл теряет повреждённые участки, поэтому режим восстановления применяется здесь.
+		var report LoadReport
+		if err = f.recover(path, false, f.recovery, decoded, &report); err != nil {
+			return err
+		}
+
+		err = writeFileAtomic(path, func(w io.Writer) error {
+			if filepath.Ext(path) != gzipSuffix {
+				return writeMetrics(w, f.codec, records)
+			}
+
+			gz := gzip.NewWriter(w)
+			if err := writeMetrics(gz, f.codec, records); err != nil {
+				return err
+			}
+			return gz.Close()
+		})
+		if err != nil {
+			return err
+		}
+
+		log.WithFields(log.Fields{
+			"place":   "[FileService.migrate]",
+			"file":    path,
+			"from":    decoded.format,
+			"to":      f.codec.format(),
+			"records": len(records),
+		}).Info("File converted")
+	}
+
+	f.file.Close()
+	return f.reopen()
+}
+
+// corruptChunk — участок файла, который не удалось прочитать.
+// tail отмечает обрезанный конец файла, за которым целых записей уже нет.
+type corruptChunk struct {
+	offset int
+	data   []byte
+	tail   bool
+}
+
+// decodedFile — целые записи файла и повреждённые участки между ними.
+// outdated отмечает файл в прошлой версии формата: дописывать в него нельзя, только перекодировать.
+type decodedFile struct {
+	records  []models.Metrics
+	format   string
+	outdated bool
+	corrupt  []corruptChunk
+}
+
+var errChecksum = errors.New("контрольная сумма не совпадает")
+
+// decodeFile определяет формат по заголовку и разбирает файл, пропуская повреждённые участки.
+func decodeFile(data []byte) (decodedFile, error) {
+	if !bytes.HasPrefix(data, binaryMagic) {
+		return decodeJSONFile(data), nil
+	}
+
+	if len(data) == len(binaryMagic) {
+		return decodedFile{
+			format:  FormatBinary,
+			corrupt: []corruptChunk{{offset: 0, data: data, tail: true}},
+		}, nil
+	}
+
+	version := data[len(binaryMagic)]
+	if version < binaryVersionNoChecksum || version > binaryVersion {
+		return decodedFile{}, fmt.Errorf("%w: binary version %d", ErrUnsupportedFormat, version)
+	}
+
+	decoded := decodeBinaryFile(data, len(binaryMagic)+1, version)
+	decoded.outdated = version != binaryVersion
+	return decoded, nil
+}
+
+// decodeJSONFile разбирает файл построчно. Запись считается целой, только если строка завершена переводом строки.
+func decodeJSONFile(data []byte) decodedFile {
+	result := decodedFile{format: FormatJSON}
+
+	for offset := 0; offset < len(data); {
+		end := bytes.IndexByte(data[offset:], '\n')
+		complete := end >= 0
+		next := offset + end + 1
+		if !complete {
+			end = len(data) - offset
+			next = len(data)
+		}
+
+		line := data[offset : offset+end]
+		if len(bytes.TrimSpace(line)) > 0 || !complete {
+			metric, err := decodeJSONRecord(line)
+			if err != nil || !complete {
+				result.corrupt = append(result.corrupt, corruptChunk{offset: offset, data: data[offset:next], tail: !complete})
+			} else {
+				result.records = append(result.records, metric)
+			}
+		}
+
+		offset = next
+	}
+
+	return result
+}
+
+func decodeJSONRecord(line []byte) (models.Metrics, error) {
+	payload := line
+	if i := bytes.LastIndexByte(line, '\t'); i >= 0 {
+		payload = line[:i]
+
+		sum, err := strconv.ParseUint(string(bytes.TrimSpace(line[i+1:])), 16, 32)
+		if err != nil {
+			return models.Metrics{}, err
+		}
+		if crc32.Checksum(payload, crcTable) != uint32(sum) {
+			return models.Metrics{}, errChecksum
+		}
+	}
+
+	metric := models.Metrics{}
+	if err := json.Unmarshal(payload, &metric); err != nil {
+		return models.Metrics{}, err
+	}
+
+	if err := checkRecord(metric); err != nil {
+		return models.Metrics{}, err
+	}
+
+	return metric, nil
+}
+
+// checkRecord проверяет, что у записи известный тип и заполнено значение этого типа.
+// У записи удаления или сброса значения нет, проверяются только тип и операция.
+func checkRecord(metric models.Metrics) error {
+	if metric.Op != "" && metric.Op != models.OpState {
+		if (metric.Op != models.OpDelete && metric.Op != models.OpReset) || !isKnownType(metric.MType) {
+			return fmt.Errorf("неизвестная операция %q над %s типа %s", metric.Op, metric.ID, metric.MType)
+		}
+		return nil
+	}
+
+	switch {
+	case metric.MType == models.Counter && metric.Delta != nil:
+	case metric.MType == models.Gauge && metric.Value != nil:
+	case metric.MType == models.Histogram && metric.Histogram != nil:
+	default:
+		return fmt.Errorf("неполная запись %s типа %s", metric.ID, metric.MType)
+	}
+
+	return nil
+}
+
+// decodeBinaryFile читает записи с offset. После повреждения ищет ближайшую позицию,
+// с которой читается целая запись, а всё между ними считает одним повреждённым участком.
+func decodeBinaryFile(data []byte, offset int, version byte) decodedFile {
+	result := decodedFile{format: FormatBinary}
+
+	for offset < len(data) {
+		metric, next, ok := decodeBinaryAt(data, offset, version)
+		if ok {
+			result.records = append(result.records, metric)
+			offset = next
+			continue
+		}
+
+		bad := offset
+		for offset++; offset < len(data); offset++ {
+			if _, _, ok = decodeBinaryAt(data, offset, version); ok {
+				break
+			}
+		}
+
+		result.corrupt = append(result.corrupt, corruptChunk{offset: bad, data: data[bad:offset], tail: offset == len(data)})
+	}
+
+	return result
+}
+
+func decodeBinaryAt(data []byte, offset int, version byte) (models.Metrics, int, bool) {
+	checksum := version != binaryVersionNoChecksum
+
+	length, n := binary.Uvarint(data[offset:])
+	if n <= 0 || length > maxRecordLength {
+		return models.Metrics{}, 0, false
+	}
+	offset += n
+
+	var sum uint32
+	if checksum {
+		if len(data)-offset < 4 {
+			return models.Metrics{}, 0, false
+		}
+		sum = binary.LittleEndian.Uint32(data[offset:])
+		offset += 4
+	}
+
+	if uint64(len(data)-offset) < length {
+		return models.Metrics{}, 0, false
+	}
+
+	body := data[offset : offset+int(length)]
+	if checksum && crc32.Checksum(body, crcTable) != sum {
+		return models.Metrics{}, 0, false
+	}
+
+	metric, err := decodeBinaryRecord(body, version > binaryVersionNoTimestamp)
+	if err != nil {
+		return models.Metrics{}, 0, false
+	}
+
+	return metric, offset + int(length), true
+}
+
+// binaryDecoder читает поля тела записи и запоминает первую ошибку.
+type binaryDecoder struct {
+	data []byte
+	err  error
+}
+
+var errShortRecord = errors.New("запись обрезана")
+
+func (d *binaryDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	value, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = errShortRecord
+		return 0
+	}
+
+	d.data = d.data[n:]
+	return value
+}
+
+func (d *binaryDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+
+	value, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.err = errShortRecord
+		return 0
+	}
+
+	d.data = d.data[n:]
+	return value
+}
+
+func (d *binaryDecoder) float() float64 {
+	if d.err != nil || len(d.data) < 8 {
+		d.err = errShortRecord
+		return 0
+	}
+
+	value := math.Float64frombits(binary.LittleEndian.Uint64(d.data))
+	d.data = d.data[8:]
+	return value
+}
+
+func (d *binaryDecoder) string() string {
+	length := d.uvarint()
+	if d.err != nil || uint64(len(d.data)) < length {
+		d.err = errShortRecord
+		return ""
+	}
+
+	value := string(d.data[:length])
+	d.data = d.data[length:]
+	return value
+}
+
+// count читает длину списка и проверяет, что в теле хватит хотя бы по байту на элемент.
+func (d *binaryDecoder) count() int {
+	length := d.uvarint()
+	if d.err == nil && uint64(len(d.data)) < length {
+		d.err = errShortRecord
+	}
+
+	return int(length)
+}
+
+// kind читает байт с типом ряда записи удаления или сброса.
+func (d *binaryDecoder) kind() string {
+	if d.err != nil {
+		return ""
+	}
+	if len(d.data) == 0 {
+		d.err = errShortRecord
+		return ""
+	}
+
+	kind := d.data[0]
+	d.data = d.data[1:]
+	for metricType, value := range binaryKinds {
+		if value == kind {
+			return metricType
+		}
+	}
+
+	d.err = fmt.Errorf("неизвестный тип ряда %d", kind)
+	return ""
+}
+
+func decodeBinaryRecord(body []byte, timestamp bool) (models.Metrics, error) {
+	if len(body) == 0 {
+		return models.Metrics{}, errShortRecord
+	}
+
+	d := &binaryDecoder{data: body[1:]}
+	metric := models.Metrics{ID: d.string()}
+
+	if labels := d.count(); labels > 0 && d.err == nil {
+		metric.Labels = make(models.Labels, labels)
+		for i := 0; i < labels && d.err == nil; i++ {
+			name := d.string()
+			metric.Labels[name] = d.string()
+		}
+	}
+
+	kind := body[0] &^ binaryState
+	if body[0]&binaryState != 0 {
+		metric.Op = models.OpState
+	}
+
+	switch kind {
+	case binaryCounter:
+		metric.MType = models.Counter
+		delta := d.varint()
+		metric.Delta = &delta
+	case binaryGauge:
+		metric.MType = models.Gauge
+		value := d.float()
+		metric.Value = &value
+	case binaryHistogram:
+		metric.MType = models.Histogram
+		h := &models.HistogramData{}
+		if buckets := d.count(); d.err == nil {
+			h.Buckets = make([]float64, buckets)
+			for i := range h.Buckets {
+				h.Buckets[i] = d.float()
+			}
+		}
+		if counts := d.count(); d.err == nil {
+			h.Counts = make([]uint64, counts)
+			for i := range h.Counts {
+				h.Counts[i] = d.uvarint()
+			}
+		}
+		h.Count = d.uvarint()
+		h.Sum = d.float()
+		metric.Histogram = h
+	case binaryDelete, binaryReset:
+		if metric.Op == models.OpState {
+			return models.Metrics{}, fmt.Errorf("запись состояния без значения %s", metric.ID)
+		}
+		metric.Op = models.OpDelete
+		if kind == binaryReset {
+			metric.Op = models.OpReset
+		}
+		metric.MType = d.kind()
+	default:
+		return models.Metrics{}, fmt.Errorf("неизвестный тип записи %d", body[0])
+	}
+
+	if timestamp {
+		metric.Timestamp = d.varint()
+	}
+
+	if d.err != nil {
+		return models.Metrics{}, d.err
+	}
+	if len(d.data) != 0 {
+		return models.Metrics{}, fmt.Errorf("лишние %d байт в записи", len(d.data))
+	}
+
+	return metric, nil
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/Oresst/goMetrics/internal/store"
+	"github.com/Oresst/goMetrics/internal/utils"
+	"github.com/Oresst/goMetrics/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileServiceBinaryFormat(t *testing.T) {
+	histogram := models.NewHistogramData([]float64{0.1, 1})
+	histogram.Observe(0.5)
+
+	records := []models.Metrics{
+		{ID: "PollCount", MType: models.Counter, Delta: utils.PointInt64(-3)},
+		{ID: "Alloc", MType: models.Gauge, Value: utils.PointFloat64(1.25), Labels: models.Labels{"host": "web-1", "dc": "eu"}},
+		{ID: "latency", MType: models.Histogram, Histogram: &histogram},
+		{ID: "Alloc", MType: models.Gauge, Labels: models.Labels{"host": "web-1"}, Op: models.OpReset},
+		{ID: "PollCount", MType: models.Counter, Op: models.OpDelete},
+	}
+
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "metrics.json")
+	binaryPath := filepath.Join(dir, "metrics.bin")
+
+	for _, tc := range []struct {
+		path   string
+		format string
+	}{
+		{path: jsonPath, format: FormatJSON},
+		{path: binaryPath, format: FormatBinary},
+	} {
+		fileService, err := NewFileService(tc.path, 0)
+		require.NoError(t, err)
+		require.NoError(t, fileService.SetFormat(tc.format))
+
+		for _, record := range records {
+			fileService.Write(record)
+		}
+		require.NoError(t, fileService.Close())
+
+		data, err := fileService.ReadAllData(tc.path)
+		require.NoError(t, err)
+		for i := range data {
+			assert.NotZero(t, data[i].Timestamp, tc.format)
+			data[i].Timestamp = 0
+		}
+		assert.Equal(t, records, data, tc.format)
+	}
+
+	jsonInfo, err := os.Stat(jsonPath)
+	require.NoError(t, err)
+	binaryInfo, err := os.Stat(binaryPath)
+	require.NoError(t, err)
+	assert.Less(t, binaryInfo.Size(), jsonInfo.Size())
+
+	raw, err := os.ReadFile(binaryPath)
+	require.NoError(t, err)
+	raw[4] = 99
+	require.NoError(t, os.WriteFile(binaryPath, raw, 0666))
+
+	_, err = (&FileService{}).ReadAllData(binaryPath)
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+}
+
+func TestFileServiceFormatMigration(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "metrics.txt")
+
+	// Существующая установка: журнал в JSON с ротацией и сжатием сегментов.
+	fileService, err := NewFileService(filePath, 0)
+	require.NoError(t, err)
+	require.NoError(t, fileService.SetRotation(RotationConfig{MaxSize: 1, Compress: true}))
+
+	storage := store.NewPersistentStorage(store.NewMemStorage(), fileService)
+	require.NoError(t, storage.AddCounter("PollCount", nil, 2))
+	require.NoError(t, storage.SetGauge("Alloc", nil, 1.5))
+	require.NoError(t, fileService.Close())
+
+	// Перезапуск с бинарным форматом.
+	fileService, err = NewFileService(filePath, 0)
+	require.NoError(t, err)
+	require.NoError(t, fileService.SetRotation(RotationConfig{MaxSize: 1 << 20}))
+	require.NoError(t, fileService.SetFormat(FormatBinary))
+
+	storage = store.NewPersistentStorage(store.NewMemStorage(), fileService)
+	require.NoError(t, storage.AddCounter("PollCount", nil, 3))
+	require.NoError(t, fileService.Close())
+
+	raw, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(raw, []byte("GMTR")))
+
+	segments, err := filepath.Glob(filePath + ".*.gz")
+	require.NoError(t, err)
+	require.Len(t, segments, 2)
+	for _, segment := range segments {
+		file, err := os.Open(segment)
+		require.NoError(t, err)
+
+		reader, err := gzip.NewReader(file)
+		require.NoError(t, err)
+		raw, err = io.ReadAll(reader)
+		file.Close()
+
+		require.NoError(t, err)
+		assert.True(t, bytes.HasPrefix(raw, []byte("GMTR")), segment)
+	}
+
+	data, err := fileService.ReadAllData(filePath)
+	require.NoError(t, err)
+
+	snapshot, err := store.ReplayLog(data)
+	require.NoError(t, err)
+
+	restored := store.NewMemStorage()
+	require.NoError(t, restored.Restore(snapshot))
+
+	delta, err := restored.GetCounter("PollCount", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), delta)
+
+	value, err := restored.GetGauge("Alloc", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, value)
+}
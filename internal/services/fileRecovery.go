@@ -0,0 +1,184 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Oresst/goMetrics/models"
+	log "github.com/sirupsen/logrus"
+	"os"
+)
+
+// Режимы чтения повреждённых файлов журнала.
+// strict прерывает чтение на первой повреждённой записи, skip пропускает такие записи,
+// quarantine дополнительно сохраняет их в <файл>.quarantine для разбора.
+// Во всех режимах, кроме strict, обрезанный конец активного файла отрезается.
+const (
+	RecoveryStrict     = "strict"
+	RecoverySkip       = "skip"
+	RecoveryQuarantine = "quarantine"
+)
+
+const quarantineSuffix = ".quarantine"
+
+var ErrCorruptRecord = errors.New("corrupt record")
+
+// LoadReport — итог чтения журнала: загружено записей, пропущено повреждённых,
+// отрезано обрезанных концов файлов.
+type LoadReport struct {
+	Loaded   int `json:"loaded"`
+	Skipped  int `json:"skipped"`
+	Repaired int `json:"repaired"`
+}
+
+// SetRecovery задаёт режим чтения повреждённых записей.
+func (f *FileService) SetRecovery(mode string) error {
+	if err := checkRecovery(mode); err != nil {
+		return err
+	}
+
+	f.fileMu.Lock()
+	defer f.fileMu.Unlock()
+
+	f.recovery = mode
+	return nil
+}
+
+func checkRecovery(mode string) error {
+	switch mode {
+	case RecoveryStrict, RecoverySkip, RecoveryQuarantine:
+		return nil
+	default:
+		return fmt.Errorf("неизвестный режим восстановления %q", mode)
+	}
+}
+
+// Load читает последний снимок, сегменты новее него и активный файл по порядку
+// и сообщает, сколько записей загружено, пропущено и исправлено.
+func (f *FileService) Load() ([]models.Metrics, LoadReport, error) {
+	f.compactMu.Lock()
+	defer f.compactMu.Unlock()
+	f.fileMu.Lock()
+	defer f.fileMu.Unlock()
+
+	return f.loadPersisted(f.fileName, f.recovery, true)
+}
+
+// loadPersisted читает журнал fileName; repair разрешает отрезать обрезанный конец активного файла.
+func (f *FileService) loadPersisted(fileName string, mode string, repair bool) ([]models.Metrics, LoadReport, error) {
+	var report LoadReport
+
+	snapshot, segments, err := listSegments(fileName)
+	if err != nil {
+		return nil, report, err
+	}
+
+	paths := make([]string, 0, len(segments)+2)
+	if snapshot != nil {
+		paths = append(paths, snapshot.path)
+	}
+	for _, segment := range segments {
+		paths = append(paths, segment.path)
+	}
+	paths = append(paths, fileName)
+
+	var result []models.Metrics
+	for _, path := range paths {
+		if result, err = f.loadFile(path, repair && path == fileName, mode, result, &report); err != nil {
+			return nil, report, err
+		}
+	}
+
+	return result, report, nil
+}
+
+func (f *FileService) loadFile(
+	path string,
+	active bool,
+	mode string,
+	result []models.Metrics,
+	report *LoadReport,
+) ([]models.Metrics, error) {
+	decoded, err := readFileRecords(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = f.recover(path, active, mode, decoded, report); err != nil {
+		return nil, err
+	}
+
+	report.Loaded += len(decoded.records)
+	return append(result, decoded.records...), nil
+}
+
+// recover применяет режим восстановления к повреждённым участкам файла.
+// Обрезанный конец активного файла отрезается, чтобы новые записи не дописывались к мусору.
+func (f *FileService) recover(path string, active bool, mode string, decoded decodedFile, report *LoadReport) error {
+	if len(decoded.corrupt) == 0 {
+		return nil
+	}
+
+	if mode == RecoveryStrict {
+		return fmt.Errorf("%w: %s offset %d", ErrCorruptRecord, path, decoded.corrupt[0].offset)
+	}
+
+	if mode == RecoveryQuarantine {
+		if err := quarantine(path, decoded.corrupt); err != nil {
+			return err
+		}
+	}
+
+	skipped, repaired := 0, 0
+	for _, chunk := range decoded.corrupt {
+		if !chunk.tail {
+			skipped++
+			continue
+		}
+
+		repaired++
+		if !active {
+			continue
+		}
+
+		if err := os.Truncate(path, int64(chunk.offset)); err != nil {
+			return err
+		}
+		if path == f.fileName && chunk.offset == 0 {
+			f.headerPending = true
+		}
+	}
+
+	report.Skipped += skipped
+	report.Repaired += repaired
+
+	log.WithFields(log.Fields{
+		"place":    "[FileService.recover]",
+		"file":     path,
+		"skipped":  skipped,
+		"repaired": repaired,
+		"mode":     mode,
+	}).Warn("Corrupt records in file")
+
+	return nil
+}
+
+// quarantine дописывает повреждённые участки в <path>.quarantine, каждый после строки с их положением.
+func quarantine(path string, chunks []corruptChunk) error {
+	file, err := os.OpenFile(path+quarantineSuffix, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	for _, chunk := range chunks {
+		data := fmt.Appendf(nil, "# %s offset %d length %d\n", path, chunk.offset, len(chunk.data))
+		data = append(data, chunk.data...)
+		data = append(data, '\n')
+
+		if _, err = file.Write(data); err != nil {
+			return err
+		}
+	}
+
+	return file.Sync()
+}
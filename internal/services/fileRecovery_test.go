@@ -0,0 +1,90 @@
+package services
+
+import (
+	"github.com/Oresst/goMetrics/internal/utils"
+	"github.com/Oresst/goMetrics/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileServiceRecovery(t *testing.T) {
+	corruptions := []struct {
+		name    string
+		corrupt func(raw []byte) []byte
+		report  LoadReport
+	}{
+		{
+			name: "flipped byte",
+			corrupt: func(raw []byte) []byte {
+				raw[len(raw)/2] ^= 0x01
+				return raw
+			},
+			report: LoadReport{Loaded: 2, Skipped: 1},
+		},
+		{
+			name: "truncated tail",
+			corrupt: func(raw []byte) []byte {
+				return raw[:len(raw)-3]
+			},
+			report: LoadReport{Loaded: 2, Repaired: 1},
+		},
+	}
+
+	for _, format := range []string{FormatJSON, FormatBinary} {
+		for _, tc := range corruptions {
+			t.Run(format+" "+tc.name, func(t *testing.T) {
+				filePath := filepath.Join(t.TempDir(), "metrics.txt")
+
+				fileService, err := NewFileService(filePath, 0)
+				require.NoError(t, err)
+				require.NoError(t, fileService.SetFormat(format))
+				for _, name := range []string{"c1", "c2", "c3"} {
+					fileService.Write(models.Metrics{ID: name, MType: models.Counter, Delta: utils.PointInt64(1)})
+				}
+				require.NoError(t, fileService.Close())
+
+				raw, err := os.ReadFile(filePath)
+				require.NoError(t, err)
+				require.NoError(t, os.WriteFile(filePath, tc.corrupt(raw), 0666))
+
+				t.Run("strict", func(t *testing.T) {
+					fileService, err := NewFileService(filePath, 0)
+					require.NoError(t, err)
+					defer fileService.Close()
+					require.NoError(t, fileService.SetRecovery(RecoveryStrict))
+
+					_, _, err = fileService.Load()
+					assert.ErrorIs(t, err, ErrCorruptRecord)
+				})
+
+				fileService, err = NewFileService(filePath, 0)
+				require.NoError(t, err)
+				require.NoError(t, fileService.SetFormat(format))
+				require.NoError(t, fileService.SetRecovery(RecoveryQuarantine))
+
+				data, report, err := fileService.Load()
+				require.NoError(t, err)
+				assert.Equal(t, tc.report, report)
+				assert.Len(t, data, 2)
+
+				quarantined, err := os.ReadFile(filePath + ".quarantine")
+				require.NoError(t, err)
+				assert.Contains(t, string(quarantined), filePath)
+
+				// После ремонта новые записи читаются, а повреждённый участок только пропускается.
+				fileService.Write(models.Metrics{ID: "c4", MType: models.Counter, Delta: utils.PointInt64(1)})
+				require.NoError(t, fileService.Close())
+
+				require.NoError(t, fileService.SetRecovery(RecoverySkip))
+				data, report, err = fileService.Load()
+				require.NoError(t, err)
+				assert.Equal(t, 3, report.Loaded)
+				assert.Zero(t, report.Repaired)
+				assert.Equal(t, "c4", data[len(data)-1].ID)
+			})
+		}
+	}
+}
@@ -0,0 +1,446 @@
+package services
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/Oresst/goMetrics/internal/store"
+	"github.com/Oresst/goMetrics/models"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RotationConfig задаёт ротацию файла журнала в нумерованные сегменты.
+// Активный файл закрывается в сегмент, когда превышает MaxSize байт или старше MaxAge.
+// Compress сжимает закрытые сегменты gzip. Если закрытых сегментов накопилось CompactSegments,
+// они вместе с прошлым снимком сворачиваются в фоне в снимок последних значений.
+type RotationConfig struct {
+	MaxSize         int64
+	MaxAge          time.Duration
+	Compress        bool
+	CompactSegments int
+}
+
+const (
+	gzipSuffix     = ".gz"
+	snapshotSuffix = ".snapshot"
+)
+
+// Сегмент называется <файл>.000001 (или .000001.gz), снимок — <файл>.000005.snapshot,
+// где номер снимка — последний свёрнутый в него сегмент.
+var segmentPattern = regexp.MustCompile(`^\.(\d+)(\.gz|\.snapshot)?$`)
+
+type segmentFile struct {
+	path     string
+	number   int
+	snapshot bool
+}
+
+func segmentName(fileName string, number int) string {
+	return fmt.Sprintf("%s.%06d", fileName, number)
+}
+
+func snapshotName(fileName string, number int) string {
+	return segmentName(fileName, number) + snapshotSuffix
+}
+
+// scanSegments находит все сегменты и снимки файла fileName.
+func scanSegments(fileName string) ([]segmentFile, error) {
+	entries, err := os.ReadDir(filepath.Dir(fileName))
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := filepath.Base(fileName)
+	result := make([]segmentFile, 0)
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		groups := segmentPattern.FindStringSubmatch(name[len(prefix):])
+		if groups == nil {
+			continue
+		}
+
+		number, err := strconv.Atoi(groups[1])
+		if err != nil {
+			continue
+		}
+
+		result = append(result, segmentFile{
+			path:     filepath.Join(filepath.Dir(fileName), name),
+			number:   number,
+			snapshot: groups[2] == snapshotSuffix,
+		})
+	}
+
+	return result, nil
+}
+
+// listSegments возвращает последний снимок (если есть) и сегменты новее него по возрастанию номера.
+func listSegments(fileName string) (*segmentFile, []segmentFile, error) {
+	files, err := scanSegments(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var snapshot *segmentFile
+	segments := make(map[int]segmentFile)
+
+	for _, file := range files {
+		if file.snapshot {
+			if snapshot == nil || snapshot.number < file.number {
+				snapshot = &file
+			}
+			continue
+		}
+
+		// Если сжатие прервалось, рядом с .gz остаётся полный несжатый сегмент — берём его.
+		if existing, ok := segments[file.number]; ok && filepath.Ext(existing.path) != gzipSuffix {
+			continue
+		}
+		segments[file.number] = file
+	}
+
+	result := make([]segmentFile, 0, len(segments))
+	for number, file := range segments {
+		if snapshot == nil || number > snapshot.number {
+			result = append(result, file)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].number < result[j].number
+	})
+
+	return snapshot, result, nil
+}
+
+// lastSegmentNumber возвращает наибольший номер среди сегментов и снимков.
+func lastSegmentNumber(fileName string) (int, error) {
+	files, err := scanSegments(fileName)
+	if err != nil {
+		return 0, err
+	}
+
+	last := 0
+	for _, file := range files {
+		if file.number > last {
+			last = file.number
+		}
+	}
+
+	return last, nil
+}
+
+// readFileRecords читает и разбирает файл в любом из форматов, при необходимости распаковывая gzip.
+// Если gzip-поток оборван, прочитанное до обрыва сохраняется, а остаток считается обрезанным концом.
+func readFileRecords(path string) (decodedFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return decodedFile{}, err
+	}
+	defer file.Close()
+
+	if filepath.Ext(path) != gzipSuffix {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return decodedFile{}, err
+		}
+
+		decoded, err := decodeFile(data)
+		if err != nil {
+			return decodedFile{}, fmt.Errorf("%s: %w", path, err)
+		}
+
+		return decoded, nil
+	}
+
+	var data []byte
+	gz, err := gzip.NewReader(file)
+	if err == nil {
+		data, err = io.ReadAll(gz)
+		gz.Close()
+	}
+
+	decoded, decodeErr := decodeFile(data)
+	if decodeErr != nil {
+		return decodedFile{}, fmt.Errorf("%s: %w", path, decodeErr)
+	}
+
+	if err != nil {
+		decoded.corrupt = append(decoded.corrupt, corruptChunk{offset: len(data), tail: true})
+	}
+
+	return decoded, nil
+}
+
+// writeFileAtomic пишет файл через временный, fsync и переименование.
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	tmpName := path + ".tmp"
+
+	tmp, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(tmp)
+	if err = write(writer); err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	if err = os.Rename(tmpName, path); err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+func writeMetrics(w io.Writer, codec recordCodec, metrics []models.Metrics) error {
+	data := codec.header()
+	for _, metric := range metrics {
+		var err error
+		if data, err = codec.appendRecord(data, metric); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write(data)
+	return err
+}
+
+// compressSegment заменяет сегмент его gzip-копией.
+func compressSegment(path string) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	err = writeFileAtomic(path+gzipSuffix, func(w io.Writer) error {
+		gz := gzip.NewWriter(w)
+		if _, err := io.Copy(gz, source); err != nil {
+			return err
+		}
+		return gz.Close()
+	})
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// rotate закрывает активный файл в следующий сегмент и открывает новый. Вызывается под fileMu.
+func (f *FileService) rotate() (string, error) {
+	if err := f.file.Sync(); err != nil {
+		return "", err
+	}
+	if err := f.file.Close(); err != nil {
+		return "", err
+	}
+
+	segment := segmentName(f.fileName, f.segment+1)
+	if err := os.Rename(f.fileName, segment); err != nil {
+		return "", errors.Join(err, f.reopen())
+	}
+	f.segment++
+
+	if err := syncDir(filepath.Dir(f.fileName)); err != nil {
+		return "", errors.Join(err, f.reopen())
+	}
+
+	return segment, f.reopen()
+}
+
+func (f *FileService) reopen() error {
+	file, err := os.OpenFile(f.fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.openedAt = time.Now()
+	f.headerPending = info.Size() == 0
+	return nil
+}
+
+// rotateIfNeeded проверяет размер и возраст активного файла после записи. Вызывается под fileMu.
+func (f *FileService) rotateIfNeeded() error {
+	if f.rotation == nil {
+		return nil
+	}
+
+	info, err := f.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() == 0 {
+		return nil
+	}
+
+	bySize := f.rotation.MaxSize > 0 && info.Size() >= f.rotation.MaxSize
+	byAge := f.rotation.MaxAge > 0 && time.Since(f.openedAt) >= f.rotation.MaxAge
+	if !bySize && !byAge {
+		return nil
+	}
+
+	segment, err := f.rotate()
+	if err != nil {
+		return err
+	}
+
+	if f.rotation.Compress {
+		if err = compressSegment(segment); err != nil {
+			return err
+		}
+	}
+
+	if f.rotation.CompactSegments > 0 {
+		_, segments, err := listSegments(f.fileName)
+		if err != nil {
+			return err
+		}
+
+		if len(segments) >= f.rotation.CompactSegments {
+			f.startCompaction()
+		}
+	}
+
+	return nil
+}
+
+// startCompaction запускает сжатие закрытых сегментов в фоне, если оно ещё не идёт,
+// чтобы запись не ждала чтения и перезаписи всех сегментов. Вызывается под fileMu.
+func (f *FileService) startCompaction() {
+	if !f.compacting.CompareAndSwap(false, true) {
+		return
+	}
+
+	f.compactions.Add(1)
+	go func() {
+		defer f.compactions.Done()
+		defer f.compacting.Store(false)
+
+		f.compactMu.Lock()
+		defer f.compactMu.Unlock()
+
+		// Сворачиваются все сегменты, закрытые к началу сжатия, включая накопившиеся, пока оно ждало.
+		f.fileMu.Lock()
+		upTo, codec := f.segment, f.codec
+		mode := RecoverySkip
+		if f.recovery == RecoveryStrict {
+			mode = RecoveryStrict
+		}
+		f.fileMu.Unlock()
+
+		if err := f.compact(upTo, codec, mode); err != nil {
+			log.WithFields(log.Fields{
+				"place": "[FileService.compact]",
+				"err":   err.Error(),
+			}).Error("Error compacting segments")
+		}
+	}()
+}
+
+// compact сворачивает прошлый снимок и закрытые сегменты с номерами до upTo в новый снимок последних значений.
+// Записи снимка сохраняют время приёма последней записи своего ряда.
+// Повреждённые записи пропускаются, если не включён строгий режим. Вызывается под compactMu.
+func (f *FileService) compact(upTo int, codec recordCodec, mode string) error {
+	snapshot, segments, err := listSegments(f.fileName)
+	if err != nil {
+		return err
+	}
+
+	// Пока сжатие ждало, Rewrite мог записать более новый снимок.
+	if snapshot != nil && snapshot.number >= upTo {
+		return nil
+	}
+
+	paths := make([]string, 0, len(segments)+1)
+	if snapshot != nil {
+		paths = append(paths, snapshot.path)
+	}
+	for _, segment := range segments {
+		if segment.number <= upTo {
+			paths = append(paths, segment.path)
+		}
+	}
+
+	var records []models.Metrics
+	var report LoadReport
+	for _, path := range paths {
+		if records, err = f.loadFile(path, false, mode, records, &report); err != nil {
+			return err
+		}
+	}
+
+	state, err := store.ReplayLog(records)
+	if err != nil {
+		return err
+	}
+
+	var last int64
+	for _, record := range records {
+		last = max(last, record.Timestamp)
+	}
+
+	return f.writeSegmentSnapshot(upTo, codec, stampRecords(state.Metrics, last))
+}
+
+// writeSegmentSnapshot записывает снимок с номером последнего покрытого сегмента и удаляет покрытые им файлы.
+// Пока старые файлы не удалены, их отсекает номер снимка, так что сбой посередине безопасен.
+func (f *FileService) writeSegmentSnapshot(number int, codec recordCodec, metrics []models.Metrics) error {
+	name := snapshotName(f.fileName, number)
+
+	err := writeFileAtomic(name, func(w io.Writer) error {
+		return writeMetrics(w, codec, metrics)
+	})
+	if err != nil {
+		return err
+	}
+
+	files, err := scanSegments(f.fileName)
+	if err != nil {
+		return err
+	}
+
+	var removeErr error
+	for _, file := range files {
+		if file.number <= number && file.path != name {
+			removeErr = errors.Join(removeErr, os.Remove(file.path))
+		}
+	}
+
+	return removeErr
+}
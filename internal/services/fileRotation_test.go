@@ -0,0 +1,226 @@
+package services
+
+import (
+	"github.com/Oresst/goMetrics/internal/store"
+	"github.com/Oresst/goMetrics/internal/utils"
+	"github.com/Oresst/goMetrics/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileServiceRotation(t *testing.T) {
+	testCases := []struct {
+		name     string
+		rotation RotationConfig
+		segments string
+		files    int
+	}{
+		{
+			name:     "segments",
+			rotation: RotationConfig{MaxSize: 1},
+			segments: "metrics.txt.0000[0-9][0-9]",
+			files:    18,
+		},
+		{
+			name:     "compressed",
+			rotation: RotationConfig{MaxSize: 1, Compress: true},
+			segments: "metrics.txt.*.gz",
+			files:    18,
+		},
+		{
+			name:     "compacted",
+			rotation: RotationConfig{MaxSize: 1, Compress: true, CompactSegments: 4},
+			segments: "metrics.txt.*.snapshot",
+			files:    1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			filePath := filepath.Join(dir, "metrics.txt")
+			fileService, err := NewFileService(filePath, 0)
+			require.NoError(t, err)
+			require.NoError(t, fileService.SetRotation(tc.rotation))
+
+			storage := store.NewPersistentStorage(store.NewMemStorage(), fileService)
+			for i := 1; i <= 6; i++ {
+				require.NoError(t, storage.AddCounter("PollCount", nil, 1))
+				require.NoError(t, storage.SetGauge("Alloc", nil, float64(i)))
+				require.NoError(t, storage.AddCounter("PollCount", nil, 1))
+			}
+			require.NoError(t, fileService.Close())
+
+			matches, err := filepath.Glob(filepath.Join(dir, tc.segments))
+			require.NoError(t, err)
+			assert.Len(t, matches, tc.files)
+
+			data, err := fileService.ReadAllData(filePath)
+			require.NoError(t, err)
+
+			snapshot, err := store.ReplayLog(data)
+			require.NoError(t, err)
+
+			restored := store.NewMemStorage()
+			require.NoError(t, restored.Restore(snapshot))
+
+			delta, err := restored.GetCounter("PollCount", nil)
+			require.NoError(t, err)
+			assert.Equal(t, int64(12), delta)
+
+			value, err := restored.GetGauge("Alloc", nil)
+			require.NoError(t, err)
+			assert.Equal(t, 6.0, value)
+		})
+	}
+}
+
+func TestFileServiceRotationRewrite(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "metrics.txt")
+	fileService, err := NewFileService(filePath, 0)
+	require.NoError(t, err)
+	require.NoError(t, fileService.SetRotation(RotationConfig{MaxSize: 1 << 20}))
+	defer fileService.Close()
+
+	storage := store.NewPersistentStorage(store.NewMemStorage(), fileService)
+	require.NoError(t, storage.AddCounter("PollCount", nil, 3))
+	require.NoError(t, storage.SetGauge("stale", nil, 1))
+
+	require.NoError(t, fileService.Rewrite([]models.Metrics{
+		{ID: "PollCount", MType: models.Counter, Delta: utils.PointInt64(3)},
+	}))
+
+	// Сегмент, который снимок уже покрывает, но не успел удалить до сбоя.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "metrics.txt.000001"), []byte(`{"id":"PollCount","type":"counter","delta":100}`+"\n"), 0666))
+
+	require.NoError(t, storage.AddCounter("PollCount", nil, 2))
+
+	data, err := fileService.ReadAllData(filePath)
+	require.NoError(t, err)
+
+	snapshot, err := store.ReplayLog(data)
+	require.NoError(t, err)
+	require.Len(t, snapshot.Metrics, 1)
+	assert.Equal(t, int64(5), *snapshot.Metrics[0].Delta)
+}
+
+// Запись не ждёт сжатия сегментов: пока оно занято, сегменты копятся и сворачиваются после.
+func TestFileServiceBackgroundCompaction(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "metrics.txt")
+	fileService, err := NewFileService(filePath, 0)
+	require.NoError(t, err)
+	require.NoError(t, fileService.SetRotation(RotationConfig{MaxSize: 1, CompactSegments: 2}))
+
+	storage := store.NewPersistentStorage(store.NewMemStorage(), fileService)
+
+	fileService.compactMu.Lock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i <= 6; i++ {
+			assert.NoError(t, storage.AddCounter("PollCount", nil, 1))
+			assert.NoError(t, storage.SetGauge("Alloc", nil, float64(i)))
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("writes blocked by compaction")
+	}
+
+	segments, err := filepath.Glob(filepath.Join(dir, "metrics.txt.0000[0-9][0-9]"))
+	require.NoError(t, err)
+	assert.Len(t, segments, 12)
+
+	fileService.compactMu.Unlock()
+	require.NoError(t, fileService.Close())
+
+	snapshots, err := filepath.Glob(filepath.Join(dir, "metrics.txt.*.snapshot"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "metrics.txt.000012.snapshot")}, snapshots)
+
+	segments, err = filepath.Glob(filepath.Join(dir, "metrics.txt.0000[0-9][0-9]"))
+	require.NoError(t, err)
+	assert.Empty(t, segments)
+
+	data, err := fileService.ReadAllData(filePath)
+	require.NoError(t, err)
+
+	snapshot, err := store.ReplayLog(data)
+	require.NoError(t, err)
+
+	restored := store.NewMemStorage()
+	require.NoError(t, restored.Restore(snapshot))
+
+	delta, err := restored.GetCounter("PollCount", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), delta)
+
+	value, err := restored.GetGauge("Alloc", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 6.0, value)
+}
+
+// Сжатые сегменты хранят только накопленное состояние рядов, поэтому момент до их последних обновлений
+// восстановить нельзя, а не восстановить молча без этих рядов.
+func TestFileServicePointInTimeCompacted(t *testing.T) {
+	for _, format := range []string{FormatJSON, FormatBinary} {
+		t.Run(format, func(t *testing.T) {
+			dir := t.TempDir()
+			filePath := filepath.Join(dir, "metrics.txt")
+			fileService, err := NewFileService(filePath, 0)
+			require.NoError(t, err)
+			require.NoError(t, fileService.SetFormat(format))
+			require.NoError(t, fileService.SetRotation(RotationConfig{MaxSize: 1, CompactSegments: 2}))
+
+			storage := store.NewPersistentStorage(store.NewMemStorage(), fileService)
+			require.NoError(t, storage.SetGauge("Stable", nil, 5))
+			require.NoError(t, storage.AddCounter("PollCount", nil, 1))
+			require.NoError(t, storage.SetGauge("Alloc", nil, 1))
+
+			time.Sleep(time.Millisecond)
+			cut := time.Now()
+			time.Sleep(time.Millisecond)
+
+			for i := 0; i < 3; i++ {
+				require.NoError(t, storage.AddCounter("PollCount", nil, 1000))
+				require.NoError(t, storage.SetGauge("Alloc", nil, -1))
+			}
+			require.NoError(t, fileService.Close())
+
+			snapshots, err := filepath.Glob(filepath.Join(dir, "metrics.txt.*.snapshot"))
+			require.NoError(t, err)
+			require.NotEmpty(t, snapshots)
+
+			data, err := fileService.ReadAllData(filePath)
+			require.NoError(t, err)
+
+			_, err = RecordsUntil(data, cut)
+			assert.ErrorIs(t, err, ErrRestorePoint)
+
+			data, err = RecordsUntil(data, time.Now())
+			require.NoError(t, err)
+			snapshot, err := store.ReplayLog(data)
+			require.NoError(t, err)
+
+			restored := store.NewMemStorage()
+			require.NoError(t, restored.Restore(snapshot))
+			delta, err := restored.GetCounter("PollCount", nil)
+			require.NoError(t, err)
+			assert.Equal(t, int64(3001), delta)
+			value, err := restored.GetGauge("Alloc", nil)
+			require.NoError(t, err)
+			assert.Equal(t, -1.0, value)
+			value, err = restored.GetGauge("Stable", nil)
+			require.NoError(t, err)
+			assert.Equal(t, 5.0, value)
+		})
+	}
+}
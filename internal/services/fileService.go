@@ -1,20 +1,80 @@
 package services
 
 import (
-	"bufio"
-	"encoding/json"
+	"fmt"
+	"github.com/Oresst/goMetrics/internal/store"
 	"github.com/Oresst/goMetrics/models"
 	log "github.com/sirupsen/logrus"
+	"io"
 	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// SnapshotSource отдаёт текущее состояние хранилища для режима снимков.
+type SnapshotSource interface {
+	Snapshot() (store.Snapshot, error)
+}
+
+// Поведение асинхронной очереди при заполнении.
+const (
+	QueueBlock      = "block"
+	QueueDropOldest = "drop-oldest"
+	QueueReject     = "reject"
+)
+
+const defaultQueueCapacity = 10000
+
+// FileServiceStats — счётчики записей журнала с момента запуска.
+type FileServiceStats struct {
+	Queued   uint64 `json:"queued"`
+	Written  uint64 `json:"written"`
+	Dropped  uint64 `json:"dropped"`
+	Rejected uint64 `json:"rejected"`
+	Pending  int    `json:"pending"`
+}
+
 type FileService struct {
+	fileName string
 	file     *os.File
 	interval time.Duration
-	buffer   []models.Metrics
 	mode     string
 	stopChan chan bool
+
+	// Очередь асинхронного режима ограничена capacity, при заполнении действует policy.
+	// flushNow будит писателя раньше интервала, когда ждущие в режиме block упёрлись в очередь.
+	mu       sync.Mutex
+	notFull  *sync.Cond
+	buffer   []models.Metrics
+	capacity int
+	policy   string
+	stopped  bool
+	flushNow chan struct{}
+	stats    FileServiceStats
+
+	// В режиме снимков файл целиком перезаписывается состоянием source,
+	// dirty отмечает изменения с последнего снимка.
+	source SnapshotSource
+	dirty  atomic.Bool
+
+	// fileMu защищает file от одновременной записи, ротации и подмены в Rewrite.
+	fileMu   sync.Mutex
+	openedAt time.Time
+	rotation *RotationConfig
+	segment  int
+
+	// compactMu не даёт фоновому сжатию сегментов пересечься с чтением и подменой файлов
+	// и берётся раньше fileMu; compacting отмечает, что сжатие уже запущено.
+	compactMu   sync.Mutex
+	compacting  atomic.Bool
+	compactions sync.WaitGroup
+
+	// codec задаёт формат записей, headerPending — что пустой файл ещё ждёт заголовка формата.
+	codec         recordCodec
+	headerPending bool
+	recovery      string
 }
 
 func NewFileService(filename string, duration time.Duration) (*FileService, error) {
@@ -30,13 +90,107 @@ func NewFileService(filename string, duration time.Duration) (*FileService, erro
 		mode = "async"
 	}
 
-	return &FileService{
+	f := &FileService{
+		fileName: filename,
 		file:     file,
 		interval: duration,
 		mode:     mode,
-		buffer:   make([]models.Metrics, 0),
 		stopChan: make(chan bool),
-	}, nil
+		buffer:   make([]models.Metrics, 0),
+		capacity: defaultQueueCapacity,
+		policy:   QueueBlock,
+		flushNow: make(chan struct{}, 1),
+		openedAt: time.Now(),
+		codec:    jsonCodec{},
+		recovery: RecoverySkip,
+	}
+	f.notFull = sync.NewCond(&f.mu)
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	f.headerPending = info.Size() == 0
+
+	return f, nil
+}
+
+// NewSnapshotFileService создаёт FileService, который раз в duration (или после каждого изменения,
+// если duration равен нулю) записывает полный снимок source во временный файл и атомарно подменяет им filename.
+func NewSnapshotFileService(filename string, duration time.Duration, source SnapshotSource) (*FileService, error) {
+	f, err := NewFileService(filename, duration)
+	if err != nil {
+		return nil, err
+	}
+
+	f.source = source
+	return f, nil
+}
+
+// SetQueue задаёт ёмкость очереди асинхронного режима и поведение при её заполнении.
+func (f *FileService) SetQueue(capacity int, policy string) error {
+	if capacity <= 0 {
+		return fmt.Errorf("ёмкость очереди должна быть положительной: %d", capacity)
+	}
+
+	switch policy {
+	case QueueBlock, QueueDropOldest, QueueReject:
+	default:
+		return fmt.Errorf("неизвестная политика очереди %q", policy)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.capacity = capacity
+	f.policy = policy
+	return nil
+}
+
+// SetRotation включает ротацию журнала в сегменты, продолжая нумерацию уже существующих.
+func (f *FileService) SetRotation(config RotationConfig) error {
+	if config.MaxSize < 0 || config.MaxAge < 0 || config.CompactSegments < 0 {
+		return fmt.Errorf("параметры ротации не могут быть отрицательными: %+v", config)
+	}
+
+	last, err := lastSegmentNumber(f.fileName)
+	if err != nil {
+		return err
+	}
+
+	f.fileMu.Lock()
+	defer f.fileMu.Unlock()
+
+	f.rotation = &config
+	f.segment = last
+	return nil
+}
+
+// SetFormat переключает формат записей. Файлы журнала в другом формате, включая сегменты и снимки,
+// однократно перекодируются, поэтому формат можно сменить без потери данных.
+func (f *FileService) SetFormat(format string) error {
+	codec, err := newRecordCodec(format)
+	if err != nil {
+		return err
+	}
+
+	f.compactMu.Lock()
+	defer f.compactMu.Unlock()
+	f.fileMu.Lock()
+	defer f.fileMu.Unlock()
+
+	f.codec = codec
+	return f.migrate()
+}
+
+func (f *FileService) Stats() FileServiceStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	stats := f.stats
+	stats.Pending = len(f.buffer)
+	return stats
 }
 
 func (f *FileService) Run() {
@@ -45,89 +199,297 @@ func (f *FileService) Run() {
 	}
 }
 
-func (f *FileService) writeToFile(metric models.Metrics) {
+func (f *FileService) writeToFile(metric models.Metrics) bool {
 	place := "[FileService.writeToFile]"
 
-	data, err := json.Marshal(metric)
+	var data []byte
+	if f.headerPending {
+		data = f.codec.header()
+	}
+
+	data, err := f.codec.appendRecord(data, metric)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"place": place,
 			"err":   err.Error(),
 		}).Error("Error marshalling metric")
-		return
+		return false
 	}
 
-	data = append(data, '\n')
 	_, err = f.file.Write(data)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"place": place,
 			"err":   err.Error(),
 		}).Error("Error writing to file")
-		return
+		return false
 	}
+
+	f.headerPending = false
+	return true
 }
 
 func (f *FileService) writeAsync() {
 	for {
-		f.flush()
-
 		select {
 		case <-f.stopChan:
 			return
+		case <-f.flushNow:
 		case <-time.After(f.interval):
-			continue
 		}
+
+		f.flush()
 	}
 }
 
 func (f *FileService) flush() {
-	copied := make([]models.Metrics, len(f.buffer))
-	copy(copied, f.buffer)
-	f.buffer = make([]models.Metrics, 0)
+	if f.source != nil {
+		if f.dirty.Swap(false) {
+			f.writeSnapshot()
+		}
+		return
+	}
+
+	f.mu.Lock()
+	pending := f.buffer
+	f.buffer = make([]models.Metrics, 0, len(pending))
+	f.notFull.Broadcast()
+	f.mu.Unlock()
+
+	f.writeRecords(pending)
+}
+
+func (f *FileService) writeRecords(records []models.Metrics) {
+	f.fileMu.Lock()
+	var written uint64
+	for _, metric := range records {
+		if f.writeToFile(metric) {
+			written++
+		}
+	}
+
+	if err := f.rotateIfNeeded(); err != nil {
+		log.WithFields(log.Fields{
+			"place": "[FileService.writeRecords]",
+			"err":   err.Error(),
+		}).Error("Error rotating file")
+	}
+	f.fileMu.Unlock()
+
+	f.mu.Lock()
+	f.stats.Written += written
+	f.stats.Dropped += uint64(len(records)) - written
+	f.mu.Unlock()
+}
+
+// Admit отказывает с store.ErrRecorderFull, если очередь в режиме reject заполнена.
+// Проверка не резервирует место, поэтому одновременные записи могут немного превысить capacity.
+func (f *FileService) Admit(records int) error {
+	if f.mode != "async" || f.source != nil {
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-	for _, metric := range copied {
-		f.writeToFile(metric)
+	if f.policy == QueueReject && len(f.buffer)+records > f.capacity {
+		f.stats.Rejected += uint64(records)
+		return fmt.Errorf("%w: %d records pending", store.ErrRecorderFull, len(f.buffer))
 	}
+
+	return nil
 }
 
 func (f *FileService) Write(metric models.Metrics) {
+	if f.source != nil {
+		f.dirty.Store(true)
+		if f.mode == "sync" {
+			f.flush()
+		}
+		return
+	}
+
+	metric.Timestamp = time.Now().UnixNano()
 	if f.mode == "sync" {
-		f.writeToFile(metric)
+		f.writeRecords([]models.Metrics{metric})
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for f.policy == QueueBlock && len(f.buffer) >= f.capacity && !f.stopped {
+		select {
+		case f.flushNow <- struct{}{}:
+		default:
+		}
+		f.notFull.Wait()
+	}
+
+	if f.policy == QueueDropOldest && len(f.buffer) >= f.capacity {
+		dropped := len(f.buffer) - f.capacity + 1
+		f.buffer = append(f.buffer[:0], f.buffer[dropped:]...)
+		f.stats.Dropped += uint64(dropped)
+	}
+
+	f.buffer = append(f.buffer, metric)
+	f.stats.Queued++
+}
+
+func (f *FileService) writeSnapshot() {
+	place := "[FileService.writeSnapshot]"
+
+	snapshot, err := f.source.Snapshot()
+	if err == nil {
+		err = f.Rewrite(snapshot.Metrics)
+	}
+
+	if err != nil {
+		// Снимок не записан, повторим при следующем сбросе.
+		f.dirty.Store(true)
+		log.WithFields(log.Fields{
+			"place": place,
+			"err":   err.Error(),
+		}).Error("Error writing snapshot")
+	}
+}
+
+// Rewrite заменяет содержимое файла текущим состоянием хранилища.
+// Файл пишется во временный, синхронизируется на диск и подменяется переименованием,
+// поэтому после сбоя на месте остаётся либо старый, либо новый файл целиком.
+// При ротации состояние записывается снимком, покрывающим все сегменты.
+// Недописанные записи из буфера отбрасываются.
+func (f *FileService) Rewrite(metrics []models.Metrics) error {
+	metrics = stampRecords(metrics, time.Now().UnixNano())
+
+	f.compactMu.Lock()
+	defer f.compactMu.Unlock()
+	f.fileMu.Lock()
+	defer f.fileMu.Unlock()
+
+	var err error
+	if f.rotation != nil {
+		err = f.rewriteSegments(metrics)
 	} else {
-		f.buffer = append(f.buffer, metric)
+		err = writeFileAtomic(f.fileName, func(w io.Writer) error {
+			return writeMetrics(w, f.codec, metrics)
+		})
+		if err == nil {
+			f.file.Close()
+			err = f.reopen()
+		}
 	}
+
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.buffer = make([]models.Metrics, 0)
+	f.notFull.Broadcast()
+	f.mu.Unlock()
+
+	return nil
+}
+
+// rewriteSegments закрывает активный файл в сегмент и записывает состояние снимком поверх всех сегментов.
+func (f *FileService) rewriteSegments(metrics []models.Metrics) error {
+	info, err := f.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() > 0 {
+		if _, err = f.rotate(); err != nil {
+			return err
+		}
+	}
+
+	return f.writeSegmentSnapshot(f.segment, f.codec, metrics)
+}
+
+// stampRecords возвращает копию состояния записями models.OpState, упорядоченными по времени приёма.
+// Записи сохраняют время последнего обновления своего ряда, а записям без него ставится ts.
+// По отметке RecordsUntil узнаёт записи, ранние значения которых уже не восстановить.
+func stampRecords(metrics []models.Metrics, ts int64) []models.Metrics {
+	stamped := make([]models.Metrics, len(metrics))
+	for i, metric := range metrics {
+		if metric.Timestamp == 0 {
+			metric.Timestamp = ts
+		}
+		metric.Op = models.OpState
+		stamped[i] = metric
+	}
+
+	sort.SliceStable(stamped, func(i, j int) bool {
+		return stamped[i].Timestamp < stamped[j].Timestamp
+	})
+	return stamped
+}
+
+// syncDir сохраняет на диск запись каталога, чтобы переименование пережило сбой питания.
+func syncDir(name string) error {
+	dir, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
+
+// Flush записывает накопленные записи и синхронизирует файл на диск.
+func (f *FileService) Flush() error {
+	f.flush()
+
+	f.fileMu.Lock()
+	defer f.fileMu.Unlock()
+
+	return f.file.Sync()
 }
 
-func (f *FileService) Stop() error {
+func (f *FileService) Close() error {
 	if f.mode == "async" {
 		f.stopChan <- true
+
+		f.mu.Lock()
+		f.stopped = true
+		f.notFull.Broadcast()
+		f.mu.Unlock()
+
 		f.flush()
 	}
+	f.compactions.Wait()
+
+	stats := f.Stats()
+	log.WithFields(log.Fields{
+		"place":    "[FileService.Close]",
+		"queued":   stats.Queued,
+		"written":  stats.Written,
+		"dropped":  stats.Dropped,
+		"rejected": stats.Rejected,
+	}).Info("File service stopped")
+
+	f.fileMu.Lock()
+	defer f.fileMu.Unlock()
 
 	return f.file.Close()
 }
 
+// ReadAllData читает последний снимок, сегменты новее него и активный файл по порядку.
+// Повреждённые записи обрабатываются согласно режиму восстановления, см. Load.
 func (f *FileService) ReadAllData(fileName string) ([]models.Metrics, error) {
 	file, err := os.OpenFile(fileName, os.O_RDONLY|os.O_CREATE, 0666)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
+	file.Close()
 
-	scanner := bufio.NewScanner(file)
-	var result []models.Metrics
-
-	for scanner.Scan() {
-		data := scanner.Bytes()
-		metric := models.Metrics{}
-		err = json.Unmarshal(data, &metric)
-		if err != nil {
-			return nil, err
-		}
-
-		result = append(result, metric)
-	}
+	f.compactMu.Lock()
+	defer f.compactMu.Unlock()
+	f.fileMu.Lock()
+	defer f.fileMu.Unlock()
 
-	return result, nil
+	result, _, err := f.loadPersisted(fileName, f.recovery, true)
+	return result, err
 }
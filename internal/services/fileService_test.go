@@ -0,0 +1,228 @@
+package services
+
+import (
+	"github.com/Oresst/goMetrics/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnapshotFileService(t *testing.T) {
+	testCases := []struct {
+		name     string
+		interval time.Duration
+	}{
+		{name: "sync", interval: 0},
+		{name: "periodic", interval: time.Hour},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "metrics.txt")
+			memStorage := store.NewMemStorage()
+
+			fileService, err := NewSnapshotFileService(filePath, tc.interval, memStorage)
+			require.NoError(t, err)
+			fileService.Run()
+
+			storage := store.NewPersistentStorage(memStorage, fileService)
+			r := newTestRouter(NewMetricsService(storage))
+
+			for _, url := range []string{
+				"/update/counter/PollCount/2",
+				"/update/counter/PollCount/3",
+				"/update/gauge/Alloc/1.5",
+				"/update/gauge/Alloc/2.5",
+			} {
+				response := httptest.NewRecorder()
+				r.ServeHTTP(response, httptest.NewRequest(http.MethodPost, url, nil))
+				require.Equal(t, http.StatusOK, response.Code)
+			}
+
+			require.NoError(t, fileService.Close())
+
+			_, err = os.Stat(filePath + ".tmp")
+			assert.True(t, os.IsNotExist(err))
+
+			data, err := fileService.ReadAllData(filePath)
+			require.NoError(t, err)
+			require.Len(t, data, 2)
+
+			snapshot, err := store.ReplayLog(data)
+			require.NoError(t, err)
+
+			restored := store.NewMemStorage()
+			require.NoError(t, restored.Restore(snapshot))
+
+			delta, err := restored.GetCounter("PollCount", nil)
+			require.NoError(t, err)
+			assert.Equal(t, int64(5), delta)
+
+			value, err := restored.GetGauge("Alloc", nil)
+			require.NoError(t, err)
+			assert.Equal(t, 2.5, value)
+		})
+	}
+}
+
+func TestFileServiceQueue(t *testing.T) {
+	testCases := []struct {
+		name     string
+		policy   string
+		capacity int
+		codes    []int
+		stats    FileServiceStats
+		records  int
+	}{
+		{
+			name:     "block",
+			policy:   QueueBlock,
+			capacity: 2,
+			codes:    []int{200, 200, 200, 200},
+			stats:    FileServiceStats{Queued: 4, Written: 4},
+			records:  4,
+		},
+		{
+			name:     "drop oldest",
+			policy:   QueueDropOldest,
+			capacity: 2,
+			codes:    []int{200, 200, 200, 200},
+			stats:    FileServiceStats{Queued: 4, Written: 2, Dropped: 2},
+			records:  2,
+		},
+		{
+			name:     "reject",
+			policy:   QueueReject,
+			capacity: 2,
+			codes:    []int{200, 200, 503, 503},
+			stats:    FileServiceStats{Queued: 2, Written: 2, Rejected: 2},
+			records:  2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "metrics.txt")
+			fileService, err := NewFileService(filePath, time.Hour)
+			require.NoError(t, err)
+			require.NoError(t, fileService.SetQueue(tc.capacity, tc.policy))
+
+			// За час интервала писатель сбрасывает очередь только по её заполнению в режиме block и при Stop.
+			fileService.Run()
+
+			storage := store.NewPersistentStorage(store.NewMemStorage(), fileService)
+			r := newTestRouter(NewMetricsService(storage))
+
+			for i, code := range tc.codes {
+				response := httptest.NewRecorder()
+				r.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/update/counter/PollCount/1", nil))
+				require.Equal(t, code, response.Code, i)
+			}
+
+			require.NoError(t, fileService.Close())
+			assert.Equal(t, tc.stats, fileService.Stats())
+
+			data, err := fileService.ReadAllData(filePath)
+			require.NoError(t, err)
+			assert.Len(t, data, tc.records)
+		})
+	}
+}
+
+func TestFileServiceConcurrentWrites(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "metrics.txt")
+	fileService, err := NewFileService(filePath, time.Millisecond)
+	require.NoError(t, err)
+	require.NoError(t, fileService.SetQueue(16, QueueBlock))
+	fileService.Run()
+
+	storage := store.NewPersistentStorage(store.NewMemStorage(), fileService)
+
+	const writers, updates = 8, 100
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < updates; j++ {
+				assert.NoError(t, storage.AddCounter("PollCount", nil, 1))
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.NoError(t, fileService.Close())
+	assert.Equal(t, uint64(writers*updates), fileService.Stats().Written)
+
+	data, err := fileService.ReadAllData(filePath)
+	require.NoError(t, err)
+
+	snapshot, err := store.ReplayLog(data)
+	require.NoError(t, err)
+	require.Len(t, snapshot.Metrics, 1)
+	assert.Equal(t, int64(writers*updates), *snapshot.Metrics[0].Delta)
+}
+
+func TestFileServicePointInTime(t *testing.T) {
+	for _, format := range []string{FormatJSON, FormatBinary} {
+		t.Run(format, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "metrics.txt")
+			fileService, err := NewFileService(filePath, 0)
+			require.NoError(t, err)
+			defer fileService.Close()
+			require.NoError(t, fileService.SetFormat(format))
+
+			storage := store.NewPersistentStorage(store.NewMemStorage(), fileService)
+			require.NoError(t, storage.AddCounter("PollCount", nil, 1))
+			require.NoError(t, storage.SetGauge("Alloc", nil, 1))
+
+			time.Sleep(time.Millisecond)
+			cut := time.Now()
+			time.Sleep(time.Millisecond)
+
+			require.NoError(t, storage.AddCounter("PollCount", nil, 1000))
+			require.NoError(t, storage.SetGauge("Alloc", nil, -1))
+
+			data, _, err := fileService.Load()
+			require.NoError(t, err)
+			require.Len(t, data, 4)
+
+			data, err = RecordsUntil(data, cut)
+			require.NoError(t, err)
+			snapshot, err := store.ReplayLog(data)
+			require.NoError(t, err)
+
+			restored := store.NewMemStorage()
+			require.NoError(t, restored.Restore(snapshot))
+			delta, err := restored.GetCounter("PollCount", nil)
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), delta)
+			value, err := restored.GetGauge("Alloc", nil)
+			require.NoError(t, err)
+			assert.Equal(t, 1.0, value)
+
+			// Перезапись сохраняет время последнего обновления рядов, поэтому момент cut остаётся доступен,
+			// а более ранний, до первых записей, — нет.
+			require.NoError(t, fileService.Rewrite(snapshot.Metrics))
+			require.NoError(t, storage.SetGauge("Alloc", nil, 2))
+			data, _, err = fileService.Load()
+			require.NoError(t, err)
+			require.Len(t, data, 3)
+
+			data, err = RecordsUntil(data, cut)
+			require.NoError(t, err)
+			replayed, err := store.ReplayLog(data)
+			require.NoError(t, err)
+			assert.Equal(t, snapshot.Metrics, replayed.Metrics)
+
+			_, err = RecordsUntil(data, time.Unix(0, replayed.Metrics[0].Timestamp-1))
+			assert.ErrorIs(t, err, ErrRestorePoint)
+		})
+	}
+}
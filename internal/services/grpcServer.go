@@ -0,0 +1,254 @@
+package services
+
+import (
+	"context"
+	"errors"
+	pb "github.com/Oresst/goMetrics/internal/proto"
+	"github.com/Oresst/goMetrics/internal/store"
+	"github.com/Oresst/goMetrics/models"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// MetricsGRPCServer — gRPC-сервис метрик поверх MetricsService: та же проверка метрик,
+// то же хранилище и та же готовность, что у HTTP-обработчиков.
+type MetricsGRPCServer struct {
+	pb.UnimplementedMetricsServer
+
+	service *MetricsService
+}
+
+func NewMetricsGRPCServer(service *MetricsService) *MetricsGRPCServer {
+	return &MetricsGRPCServer{service: service}
+}
+
+// NewGRPCServer создаёт grpc.Server с зарегистрированным сервисом метрик.
+func NewGRPCServer(service *MetricsService) *grpc.Server {
+	server := grpc.NewServer()
+	pb.RegisterMetricsServer(server, NewMetricsGRPCServer(service))
+	return server
+}
+
+func (s *MetricsGRPCServer) UpdateMetric(ctx context.Context, request *pb.UpdateMetricRequest) (*pb.UpdateMetricResponse, error) {
+	if err := s.checkReady(); err != nil {
+		return nil, err
+	}
+
+	if request.GetMetric() == nil {
+		return nil, status.Error(codes.InvalidArgument, "metric is required")
+	}
+
+	data := metricFromProto(request.GetMetric())
+	if err := s.service.prepareMetric(&data); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := s.service.storeMetric(data); err != nil {
+		log.WithFields(log.Fields{
+			"place": "[MetricsGRPCServer.UpdateMetric]",
+			"error": err.Error(),
+			"type":  data.MType,
+			"id":    data.ID,
+		}).Error("Ошибка при добавлении метрики")
+
+		return nil, grpcStatus(err, codes.InvalidArgument)
+	}
+
+	metric, err := s.service.getMetric(data.MType, data.ID, data.Labels)
+	if err != nil {
+		return nil, grpcStatus(err, codes.Internal)
+	}
+
+	return &pb.UpdateMetricResponse{Metric: metricToProto(metric)}, nil
+}
+
+// UpdateMetrics применяет каждое сообщение потока отдельной пачкой через AddMetrics.
+// При ошибке уже применённые пачки остаются в хранилище.
+func (s *MetricsGRPCServer) UpdateMetrics(stream pb.Metrics_UpdateMetricsServer) error {
+	if err := s.checkReady(); err != nil {
+		return err
+	}
+
+	var accepted uint64
+	for batch := 0; ; batch++ {
+		request, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&pb.UpdateMetricsResponse{Accepted: accepted})
+		}
+		if err != nil {
+			return err
+		}
+
+		data := make([]models.Metrics, 0, len(request.GetMetrics()))
+		for i, metric := range request.GetMetrics() {
+			item := metricFromProto(metric)
+			if err = s.service.prepareMetric(&item); err != nil {
+				return status.Errorf(codes.InvalidArgument, "пачка #%d, метрика #%d: %s", batch, i, err.Error())
+			}
+			data = append(data, item)
+		}
+
+		if err = s.service.storage.AddMetrics(data); err != nil {
+			log.WithFields(log.Fields{
+				"place": "[MetricsGRPCServer.UpdateMetrics]",
+				"error": err.Error(),
+				"batch": batch,
+				"count": len(data),
+			}).Error("Ошибка при добавлении метрик")
+
+			return grpcStatus(err, codes.Internal)
+		}
+
+		accepted += uint64(len(data))
+	}
+}
+
+func (s *MetricsGRPCServer) GetMetric(ctx context.Context, request *pb.GetMetricRequest) (*pb.GetMetricResponse, error) {
+	if !isKnownType(request.GetType()) {
+		return nil, status.Errorf(codes.InvalidArgument, "type должен быть равен %s, %s или %s", models.Counter, models.Gauge, models.Histogram)
+	}
+
+	if request.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id не может быть пустым")
+	}
+
+	labels := models.Labels(request.GetLabels())
+	if err := validateLabels(labels); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	metric, err := s.service.getMetric(request.GetType(), request.GetId(), labels)
+	if err != nil {
+		return nil, grpcStatus(err, codes.NotFound)
+	}
+
+	return &pb.GetMetricResponse{Metric: metricToProto(metric)}, nil
+}
+
+// ListMetrics принимает те же фильтры, сортировку и курсор, что GET /api/metrics.
+func (s *MetricsGRPCServer) ListMetrics(ctx context.Context, request *pb.ListMetricsRequest) (*pb.ListMetricsResponse, error) {
+	values := url.Values{}
+	if len(request.GetTypes()) > 0 {
+		values.Set("type", strings.Join(request.GetTypes(), ","))
+	}
+	if request.GetPrefix() != "" {
+		values.Set("prefix", request.GetPrefix())
+	}
+	if request.GetRegex() != "" {
+		values.Set("regex", request.GetRegex())
+	}
+	if request.GetMatch() != "" {
+		values.Set("match", request.GetMatch())
+	}
+	if request.GetSort() != "" {
+		values.Set("sort", request.GetSort())
+	}
+	if request.GetLimit() != 0 {
+		values.Set("limit", strconv.FormatUint(uint64(request.GetLimit()), 10))
+	}
+	if request.GetCursor() != "" {
+		values.Set("cursor", request.GetCursor())
+	}
+
+	query, err := ParseMetricsQuery(values)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	page, err := QueryMetrics(s.service.storage, query)
+	if errors.Is(err, ErrInvalidQuery) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, grpcStatus(err, codes.Internal)
+	}
+
+	response := &pb.ListMetricsResponse{
+		Metrics:    make([]*pb.Series, 0, len(page.Metrics)),
+		NextCursor: page.NextCursor,
+	}
+	for _, series := range page.Metrics {
+		response.Metrics = append(response.Metrics, &pb.Series{
+			Metric:    metricToProto(series.Metrics),
+			UpdatedAt: timestamppb.New(series.UpdatedAt),
+		})
+	}
+
+	return response, nil
+}
+
+// checkReady отклоняет запись, пока идёт восстановление, как ReadyMiddleware для HTTP.
+func (s *MetricsGRPCServer) checkReady() error {
+	if restoring, _ := s.service.readiness.Restoring(); restoring {
+		return status.Error(codes.Unavailable, "restore in progress")
+	}
+
+	return nil
+}
+
+// grpcStatus переводит ошибку хранилища в статус gRPC, fallback — для прочих ошибок.
+func grpcStatus(err error, fallback codes.Code) error {
+	switch {
+	case errors.Is(err, store.ErrRecorderFull):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, store.ErrMetricNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, store.ErrInvalidMetric), errors.Is(err, store.ErrTypeMismatch), errors.Is(err, models.ErrBucketsMismatch):
+		return status.Error(codes.InvalidArgument, err.Error())
+	default:
+		return status.Error(fallback, err.Error())
+	}
+}
+
+func metricFromProto(metric *pb.Metric) models.Metrics {
+	result := models.Metrics{
+		ID:           metric.GetId(),
+		MType:        metric.GetType(),
+		Delta:        metric.Delta,
+		Value:        metric.Value,
+		Observations: metric.GetObservations(),
+	}
+
+	if len(metric.GetLabels()) > 0 {
+		result.Labels = models.Labels(metric.GetLabels())
+	}
+
+	if histogram := metric.GetHistogram(); histogram != nil {
+		result.Histogram = &models.HistogramData{
+			Buckets: histogram.GetBuckets(),
+			Counts:  histogram.GetCounts(),
+			Count:   histogram.GetCount(),
+			Sum:     histogram.GetSum(),
+		}
+	}
+
+	return result
+}
+
+func metricToProto(metric models.Metrics) *pb.Metric {
+	result := &pb.Metric{
+		Id:     metric.ID,
+		Type:   metric.MType,
+		Labels: metric.Labels,
+		Delta:  metric.Delta,
+		Value:  metric.Value,
+	}
+
+	if metric.Histogram != nil {
+		result.Histogram = &pb.Histogram{
+			Buckets: metric.Histogram.Buckets,
+			Counts:  metric.Histogram.Counts,
+			Count:   metric.Histogram.Count,
+			Sum:     metric.Histogram.Sum,
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"context"
+	"github.com/Oresst/goMetrics/internal/agent"
+	pb "github.com/Oresst/goMetrics/internal/proto"
+	"github.com/Oresst/goMetrics/internal/store"
+	"github.com/Oresst/goMetrics/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGRPCServer(t *testing.T) {
+	storage := store.NewMemStorage()
+	service := NewMetricsService(storage)
+
+	listener := bufconn.Listen(1 << 20)
+	server := NewGRPCServer(service)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	client := pb.NewMetricsClient(conn)
+	ctx := context.Background()
+
+	delta := func(v int64) *int64 { return &v }
+	value := func(v float64) *float64 { return &v }
+
+	t.Run("update metric", func(t *testing.T) {
+		response, err := client.UpdateMetric(ctx, &pb.UpdateMetricRequest{Metric: &pb.Metric{Id: "PollCount", Type: models.Counter, Delta: delta(2)}})
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), response.GetMetric().GetDelta())
+
+		response, err = client.UpdateMetric(ctx, &pb.UpdateMetricRequest{Metric: &pb.Metric{Id: "PollCount", Type: models.Counter, Delta: delta(3)}})
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), response.GetMetric().GetDelta())
+
+		response, err = client.UpdateMetric(ctx, &pb.UpdateMetricRequest{Metric: &pb.Metric{
+			Id:           "Latency",
+			Type:         models.Histogram,
+			Labels:       map[string]string{"host": "a"},
+			Observations: []float64{0.02, 3},
+		}})
+		require.NoError(t, err)
+		assert.Equal(t, uint64(2), response.GetMetric().GetHistogram().GetCount())
+		assert.Equal(t, models.DefaultHistogramBuckets, response.GetMetric().GetHistogram().GetBuckets())
+		assert.Equal(t, map[string]string{"host": "a"}, response.GetMetric().GetLabels())
+	})
+
+	t.Run("invalid metric", func(t *testing.T) {
+		testCases := []*pb.Metric{
+			nil,
+			{Id: "", Type: models.Gauge, Value: value(1)},
+			{Id: "Alloc", Type: "summary", Value: value(1)},
+			{Id: "Alloc", Type: models.Gauge},
+			{Id: "Alloc", Type: models.Gauge, Value: value(1), Labels: map[string]string{"": "a"}},
+		}
+
+		for _, metric := range testCases {
+			_, err := client.UpdateMetric(ctx, &pb.UpdateMetricRequest{Metric: metric})
+			assert.Equal(t, codes.InvalidArgument, status.Code(err), metric.String())
+		}
+	})
+
+	t.Run("get metric", func(t *testing.T) {
+		response, err := client.GetMetric(ctx, &pb.GetMetricRequest{Id: "PollCount", Type: models.Counter})
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), response.GetMetric().GetDelta())
+		assert.Nil(t, response.GetMetric().Value)
+
+		_, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "PollCount", Type: models.Gauge})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		_, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "Missing", Type: models.Gauge})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+
+		_, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "PollCount", Type: "summary"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("update metrics stream", func(t *testing.T) {
+		stream, err := client.UpdateMetrics(ctx)
+		require.NoError(t, err)
+
+		require.NoError(t, stream.Send(&pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+			{Id: "Alloc", Type: models.Gauge, Value: value(1.5)},
+			{Id: "PollCount", Type: models.Counter, Delta: delta(1)},
+		}}))
+		require.NoError(t, stream.Send(&pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+			{Id: "HeapAlloc", Type: models.Gauge, Value: value(7)},
+		}}))
+
+		response, err := stream.CloseAndRecv()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(3), response.GetAccepted())
+
+		counter, err := storage.GetCounter("PollCount", nil)
+		require.NoError(t, err)
+		assert.Equal(t, int64(6), counter)
+
+		stream, err = client.UpdateMetrics(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+			{Id: "PollCount", Type: models.Counter, Delta: delta(100)},
+			{Id: "Alloc", Type: models.Gauge},
+		}}))
+
+		_, err = stream.CloseAndRecv()
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		counter, err = storage.GetCounter("PollCount", nil)
+		require.NoError(t, err)
+		assert.Equal(t, int64(6), counter)
+	})
+
+	t.Run("list metrics", func(t *testing.T) {
+		response, err := client.ListMetrics(ctx, &pb.ListMetricsRequest{Types: []string{models.Gauge}, Sort: "-value"})
+		require.NoError(t, err)
+		require.Len(t, response.GetMetrics(), 2)
+		assert.Equal(t, "HeapAlloc", response.GetMetrics()[0].GetMetric().GetId())
+		assert.Equal(t, "Alloc", response.GetMetrics()[1].GetMetric().GetId())
+		assert.WithinDuration(t, time.Now(), response.GetMetrics()[0].GetUpdatedAt().AsTime(), time.Minute)
+		assert.Empty(t, response.GetNextCursor())
+
+		var ids []string
+		request := &pb.ListMetricsRequest{Limit: 2}
+		for {
+			response, err = client.ListMetrics(ctx, request)
+			require.NoError(t, err)
+			for _, series := range response.GetMetrics() {
+				ids = append(ids, series.GetMetric().GetId())
+			}
+
+			if response.GetNextCursor() == "" {
+				break
+			}
+			request.Cursor = response.GetNextCursor()
+		}
+		assert.Equal(t, []string{"Alloc", "HeapAlloc", "Latency", "PollCount"}, ids)
+
+		_, err = client.ListMetrics(ctx, &pb.ListMetricsRequest{Sort: "size"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("restoring", func(t *testing.T) {
+		service.Readiness().StartRestore()
+		defer service.Readiness().FinishRestore()
+
+		_, err := client.UpdateMetric(ctx, &pb.UpdateMetricRequest{Metric: &pb.Metric{Id: "PollCount", Type: models.Counter, Delta: delta(1)}})
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+
+		_, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "PollCount", Type: models.Counter})
+		assert.NoError(t, err)
+	})
+}
+
+func TestGRPCMetricsSender(t *testing.T) {
+	storage := store.NewMemStorage()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := NewGRPCServer(NewMetricsService(storage))
+	go server.Serve(listener)
+	defer server.Stop()
+
+	sender, err := agent.NewGRPCMetricsSender(listener.Addr().String())
+	require.NoError(t, err)
+	defer sender.Close()
+
+	sender.SendGaugeMetric("Alloc", "12.5")
+	sender.SendCountMetric("PollCount", 2)
+	sender.SendMetricJSON("PollCount", models.Counter, "3")
+	sender.SendMetricJSON("HeapAlloc", models.Gauge, "7")
+	sender.SendHistogramJSON("GCPauseSeconds", []float64{0.001, 0.2})
+
+	gauge, err := storage.GetGauge("Alloc", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 12.5, gauge)
+
+	gauge, err = storage.GetGauge("HeapAlloc", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 7.0, gauge)
+
+	counter, err := storage.GetCounter("PollCount", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), counter)
+
+	histogram, err := storage.GetHistogram("GCPauseSeconds", nil)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), histogram.Count)
+}
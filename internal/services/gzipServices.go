@@ -33,3 +33,12 @@ func (gw *gzipWriter) WriteHeader(statusCode int) {
 func (gw *gzipWriter) Close() error {
 	return gw.zw.Close()
 }
+
+// FlushError отправляет клиенту сжатые данные, накопленные к этому моменту, например события /stream.
+func (gw *gzipWriter) FlushError() error {
+	if err := gw.zw.Flush(); err != nil {
+		return err
+	}
+
+	return http.NewResponseController(gw.w).Flush()
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"fmt"
+	"github.com/Oresst/goMetrics/models"
+	"net/url"
+)
+
+func validateLabels(labels models.Labels) error {
+	for name := range labels {
+		if !models.ValidLabelName(name) {
+			return fmt.Errorf("недопустимое имя метки %q", name)
+		}
+	}
+
+	return nil
+}
+
+// labelsFromQuery собирает метки из query-параметров URL, пропуская служебные параметры reserved.
+func labelsFromQuery(query url.Values, reserved ...string) (models.Labels, error) {
+	labels := make(models.Labels)
+
+	for name, values := range query {
+		if isReserved(name, reserved) {
+			continue
+		}
+
+		if len(values) != 1 {
+			return nil, fmt.Errorf("метка %q указана несколько раз", name)
+		}
+		labels[name] = values[0]
+	}
+
+	if err := validateLabels(labels); err != nil {
+		return nil, err
+	}
+
+	return labels.Copy(), nil
+}
+
+func isReserved(name string, reserved []string) bool {
+	for _, item := range reserved {
+		if name == item {
+			return true
+		}
+	}
+
+	return false
+}
+
+// labelsQuery кодирует метки обратно в query-строку для ссылок на ряд.
+func labelsQuery(labels models.Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	query := url.Values{}
+	for name, value := range labels {
+		query.Set(name, value)
+	}
+
+	return "?" + query.Encode()
+}
@@ -22,3 +22,8 @@ func (l *loggerResponseWriter) Write(data []byte) (int, error) {
 	l.data.size, err = l.ResponseWriter.Write(data)
 	return l.data.size, err
 }
+
+// Unwrap даёт http.ResponseController доступ к сбросу буфера исходного ResponseWriter.
+func (l *loggerResponseWriter) Unwrap() http.ResponseWriter {
+	return l.ResponseWriter
+}
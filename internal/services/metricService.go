@@ -4,13 +4,16 @@ import (
 	"bytes"
 	"compress/gzip"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/Oresst/goMetrics/internal/store"
 	"github.com/Oresst/goMetrics/internal/utils"
 	"github.com/Oresst/goMetrics/models"
 	"github.com/go-chi/chi/v5"
 	log "github.com/sirupsen/logrus"
+	"html"
 	"io"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
@@ -30,17 +33,31 @@ const template = `
 `
 
 type MetricsService struct {
-	storage     store.Store
-	fileService *FileService
+	storage          store.Store
+	histogramBuckets []float64
+	readiness        *Readiness
+	streamHub        *StreamHub
 }
 
-func NewMetricsService(storage store.Store, fileService *FileService) *MetricsService {
+func NewMetricsService(storage store.Store) *MetricsService {
 	return &MetricsService{
-		storage:     storage,
-		fileService: fileService,
+		storage:          storage,
+		histogramBuckets: models.DefaultHistogramBuckets,
+		readiness:        &Readiness{},
 	}
 }
 
+// SetHistogramBuckets задаёт границы корзин для новых гистограмм, пришедших отдельными наблюдениями.
+func (m *MetricsService) SetHistogramBuckets(buckets []float64) error {
+	histogram := models.NewHistogramData(buckets)
+	if err := histogram.Validate(); err != nil {
+		return err
+	}
+
+	m.histogramBuckets = histogram.Buckets
+	return nil
+}
+
 func (m *MetricsService) LoggerMiddleware(next http.Handler) http.Handler {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -103,13 +120,13 @@ func (m *MetricsService) AddMetricHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	if metricType != models.Counter && metricType != models.Gauge {
+	if !isKnownType(metricType) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	metricName := chi.URLParam(r, "name")
-	if metricName == "" {
+	if metricName == "" || models.ValidateName(metricName) != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -120,22 +137,70 @@ func (m *MetricsService) AddMetricHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	metricValue, err := strconv.ParseFloat(metricValueStr, 64)
+	labels, err := labelsFromQuery(r.URL.Query())
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	log.WithFields(log.Fields{
-		"place":      place,
-		"metricName": metricName,
-		"type":       metricType,
-		"value":      metricValue,
-	}).Info("New metric")
+	if metricType == models.Counter {
+		var delta int64
+		delta, err = strconv.ParseInt(metricValueStr, 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		log.WithFields(log.Fields{
+			"place":      place,
+			"metricName": metricName,
+			"type":       metricType,
+			"labels":     labels.String(),
+			"value":      delta,
+		}).Info("New metric")
+
+		err = m.storage.AddCounter(metricName, labels, delta)
+	} else if metricType == models.Histogram {
+		var value float64
+		value, err = strconv.ParseFloat(metricValueStr, 64)
+		if err != nil || !isFinite(value) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		log.WithFields(log.Fields{
+			"place":      place,
+			"metricName": metricName,
+			"type":       metricType,
+			"labels":     labels.String(),
+			"value":      value,
+		}).Info("New metric")
+
+		metric := models.Metrics{ID: metricName, MType: metricType, Labels: labels, Observations: []float64{value}}
+		if err = m.prepareHistogram(&metric); err == nil {
+			err = m.storage.AddHistogram(metricName, labels, *metric.Histogram)
+		}
+	} else {
+		var value float64
+		value, err = strconv.ParseFloat(metricValueStr, 64)
+		if err != nil || !isFinite(value) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		log.WithFields(log.Fields{
+			"place":      place,
+			"metricName": metricName,
+			"type":       metricType,
+			"labels":     labels.String(),
+			"value":      value,
+		}).Info("New metric")
+
+		err = m.storage.SetGauge(metricName, labels, value)
+	}
 
-	err = m.storage.AddMetric(metricType, metricName, metricValue)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(writeErrorStatus(err, http.StatusBadRequest))
 		return
 	}
 
@@ -151,7 +216,7 @@ func (m *MetricsService) GetMetricHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	if metricType != models.Counter && metricType != models.Gauge {
+	if !isKnownType(metricType) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -162,29 +227,172 @@ func (m *MetricsService) GetMetricHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	metricValue, err := m.storage.GetMetric(metricName)
+	labels, err := labelsFromQuery(r.URL.Query())
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	metric, err := m.getMetric(metricType, metricName, labels)
+	if err != nil {
+		writeReadError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(utils.BetterFormat(metricValue)))
+	w.Write([]byte(formatValue(metric)))
+}
+
+// storeMetric записывает в хранилище метрику, подготовленную prepareMetric.
+func (m *MetricsService) storeMetric(data models.Metrics) error {
+	switch data.MType {
+	case models.Counter:
+		return m.storage.AddCounter(data.ID, data.Labels, *data.Delta)
+	case models.Gauge:
+		return m.storage.SetGauge(data.ID, data.Labels, *data.Value)
+	case models.Histogram:
+		return m.storage.AddHistogram(data.ID, data.Labels, *data.Histogram)
+	default:
+		return fmt.Errorf("unknown metric type %s", data.MType)
+	}
+}
+
+// writeReadError отвечает 404, если ряда нет, и 400 с текстом ошибки, если ряд с таким именем
+// и метками зарегистрирован с другим типом.
+func writeReadError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, store.ErrMetricNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, store.ErrTypeMismatch):
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
 }
 
+// getMetric достаёт ряд из хранилища по типу, имени и меткам.
+func (m *MetricsService) getMetric(metricType string, name string, labels models.Labels) (models.Metrics, error) {
+	metric := models.Metrics{
+		ID:     name,
+		MType:  metricType,
+		Labels: labels.Copy(),
+	}
+
+	switch metricType {
+	case models.Counter:
+		delta, err := m.storage.GetCounter(name, labels)
+		if err != nil {
+			return metric, err
+		}
+		metric.Delta = &delta
+	case models.Gauge:
+		value, err := m.storage.GetGauge(name, labels)
+		if err != nil {
+			return metric, err
+		}
+		metric.Value = &value
+	case models.Histogram:
+		histogram, err := m.storage.GetHistogram(name, labels)
+		if err != nil {
+			return metric, err
+		}
+		metric.Histogram = &histogram
+	default:
+		return metric, fmt.Errorf("unknown metric type %s", metricType)
+	}
+
+	return metric, nil
+}
+
+func formatValue(metric models.Metrics) string {
+	if metric.Delta != nil {
+		return strconv.FormatInt(*metric.Delta, 10)
+	}
+
+	if metric.Value != nil {
+		return utils.BetterFormat(*metric.Value)
+	}
+
+	if metric.Histogram != nil {
+		data, _ := json.Marshal(metric.Histogram)
+		return string(data)
+	}
+
+	return ""
+}
+
+// GetAllMetricsHandler отдаёт список рядов в формате по заголовку Accept:
+// HTML со ссылками, JSON-массив models.Metrics, CSV или строки имя=значение.
 func (m *MetricsService) GetAllMetricsHandler(w http.ResponseWriter, r *http.Request) {
-	allMetrics := m.storage.GetAllMetrics()
-	strMetrics := make([]string, len(allMetrics))
+	place := "[MetricsService.GetAllMetricsHandler]"
 
-	for k, v := range allMetrics {
-		url := fmt.Sprintf("/value/%s/%s", v.MType, k)
-		strMetrics = append(strMetrics, fmt.Sprintf("<li><a href=\"%s\">%s</a></li>", url, k))
+	w.Header().Add("Vary", "Accept")
+
+	mediaType := negotiateMediaType(r.Header.Get("Accept"), rootMediaTypes)
+	if mediaType == "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusNotAcceptable)
+		io.WriteString(w, "supported types: "+strings.Join(rootMediaTypes, ", "))
+		return
 	}
 
-	responseText := fmt.Sprintf(template, strings.Join(strMetrics, "\n"))
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	matchers, err := store.ParseMatchers(r.URL.Query().Get("match"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	allMetrics, err := store.SelectMetrics(m.storage, matchers)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"place": place,
+			"error": err.Error(),
+		}).Error("Ошибка получения метрик")
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	switch mediaType {
+	case MediaJSON:
+		err = json.NewEncoder(&buf).Encode(allMetrics)
+	case MediaCSV:
+		err = writeCSVDump(&buf, allMetrics)
+	case MediaPlain:
+		for _, v := range allMetrics {
+			fmt.Fprintf(&buf, "%s=%s\n", models.SeriesID(v.ID, v.Labels), formatValue(v))
+		}
+	default:
+		strMetrics := make([]string, 0, len(allMetrics))
+		for _, v := range allMetrics {
+			url := fmt.Sprintf("/value/%s/%s%s", v.MType, v.ID, labelsQuery(v.Labels))
+			name := models.SeriesID(v.ID, v.Labels)
+			strMetrics = append(strMetrics, fmt.Sprintf("<li><a href=\"%s\">%s</a></li>", html.EscapeString(url), html.EscapeString(name)))
+		}
+
+		fmt.Fprintf(&buf, template, strings.Join(strMetrics, "\n"))
+	}
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"place": place,
+			"error": err.Error(),
+		}).Error("Ошибка сериализации метрик")
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if mediaType == MediaJSON {
+		w.Header().Set("Content-Type", mediaType)
+	} else {
+		w.Header().Set("Content-Type", mediaType+"; charset=utf-8")
+	}
 	w.WriteHeader(http.StatusOK)
-	io.WriteString(w, responseText)
+	w.Write(buf.Bytes())
 }
 
 func (m *MetricsService) AddMetricJSONHandler(w http.ResponseWriter, r *http.Request) {
@@ -218,61 +426,24 @@ func (m *MetricsService) AddMetricJSONHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	if data.MType != models.Counter && data.MType != models.Gauge {
+	if err = m.prepareMetric(&data); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("Поле type должно быть равно %s или %s", models.Counter, models.Gauge)))
+		w.Write([]byte(err.Error()))
 		return
 	}
 
-	if data.MType == models.Counter && data.Delta == nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("Поле value обязательно при type %s", models.Counter)))
-		return
-	}
+	if err = m.storeMetric(data); err != nil {
+		log.WithFields(log.Fields{
+			"place": place,
+			"error": err.Error(),
+			"type":  data.MType,
+			"id":    data.ID,
+		}).Error("Ошибка при добавлении метрики")
 
-	if data.MType == models.Gauge && data.Value == nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("Поле delta обязательно при type %s", models.Gauge)))
+		w.WriteHeader(writeErrorStatus(err, http.StatusBadRequest))
 		return
 	}
 
-	if m.fileService != nil {
-		m.fileService.Write(data)
-	}
-
-	if data.MType == models.Counter {
-		err = m.storage.AddMetric(data.MType, data.ID, float64(*data.Delta))
-
-		if err != nil {
-			log.WithFields(log.Fields{
-				"place": place,
-				"error": err.Error(),
-				"type":  data.MType,
-				"value": *data.Value,
-				"id":    data.ID,
-			}).Error("Ошибка при добавлении метрики")
-
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-
-	} else if data.MType == models.Gauge {
-		err = m.storage.AddMetric(data.MType, data.ID, *data.Value)
-
-		if err != nil {
-			log.WithFields(log.Fields{
-				"place": place,
-				"error": err.Error(),
-				"type":  data.MType,
-				"value": *data.Value,
-				"id":    data.ID,
-			}).Error("Ошибка при добавлении метрики")
-
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-	}
-
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -313,65 +484,386 @@ func (m *MetricsService) GetMetricJSONHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	if data.MType != models.Counter && data.MType != models.Gauge {
+	if !isKnownType(data.MType) {
 		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("Поле type должно быть равно %s или %s", models.Counter, models.Gauge)))
+		w.Write([]byte(fmt.Sprintf("Поле type должно быть равно %s, %s или %s", models.Counter, models.Gauge, models.Histogram)))
 		return
 	}
 
 	if data.ID == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("Поле id не может быть пустым"))
+		return
+	}
+
+	if err = validateLabels(data.Labels); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
 	}
 
-	var metric float64
-	metric, err = m.storage.GetMetric(data.ID)
+	metric, err := m.getMetric(data.MType, data.ID, data.Labels)
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
+		writeReadError(w, err)
 		return
 	}
 
-	metric, err = strconv.ParseFloat(utils.BetterFormat(metric), 64)
+	responseData := struct {
+		ID        string                `json:"id"`
+		Type      string                `json:"type"`
+		Value     *float64              `json:"value"`
+		Delta     *int64                `json:"delta"`
+		Labels    models.Labels         `json:"labels,omitempty"`
+		Histogram *models.HistogramData `json:"histogram,omitempty"`
+	}{
+		ID:        metric.ID,
+		Type:      metric.MType,
+		Value:     metric.Value,
+		Delta:     metric.Delta,
+		Labels:    metric.Labels,
+		Histogram: metric.Histogram,
+	}
+
+	var responseRawData []byte
+	responseRawData, err = json.Marshal(responseData)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"place": place,
 			"error": err.Error(),
+			"Id":    data.ID,
+			"Type":  data.MType,
 			"value": metric,
-		}).Error("Ошибка парсинга метрики")
+		}).Error("Ошибка сериализации JSON")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	responseData := struct {
-		ID    string   `json:"id"`
-		Type  string   `json:"type"`
-		Value *float64 `json:"value"`
-		Delta *float64 `json:"delta"`
-	}{
-		ID:   data.ID,
-		Type: data.MType,
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseRawData)
+}
+
+func (m *MetricsService) AddMetricsJSONHandler(w http.ResponseWriter, r *http.Request) {
+	place := "[MetricsService.AddMetricsJSONHandler]"
+
+	if r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	defer r.Body.Close()
+
+	var data []models.Metrics
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"place": place,
+			"error": err.Error(),
+		}).Error("Ошибка при парсинге JSON")
+
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
 	}
 
-	if data.MType == models.Counter {
-		responseData.Delta = utils.PointFloat64(metric)
-	} else if data.MType == models.Gauge {
-		responseData.Value = utils.PointFloat64(metric)
+	for i := range data {
+		if err = m.prepareMetric(&data[i]); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("Метрика #%d: %s", i, err.Error())))
+			return
+		}
 	}
 
-	var responseRawData []byte
-	responseRawData, err = json.Marshal(responseData)
+	err = m.storage.AddMetrics(data)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"place": place,
+			"error": err.Error(),
+			"count": len(data),
+		}).Error("Ошибка при добавлении метрик")
+
+		w.WriteHeader(writeErrorStatus(err, http.StatusInternalServerError))
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetSnapshotHandler отдаёт согласованный срез всех рядов хранилища.
+func (m *MetricsService) GetSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	place := "[MetricsService.GetSnapshotHandler]"
+
+	snapshot, err := m.storage.Snapshot()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"place": place,
+			"error": err.Error(),
+		}).Error("Ошибка получения среза хранилища")
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	responseRawData, err := json.Marshal(snapshot)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"place": place,
+			"error": err.Error(),
+		}).Error("Ошибка сериализации JSON")
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseRawData)
+}
+
+// RestoreSnapshotHandler загружает срез, ?mode=merge (по умолчанию) или ?mode=overwrite.
+func (m *MetricsService) RestoreSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	place := "[MetricsService.RestoreSnapshotHandler]"
+
+	if r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = ImportMerge
+	}
+
+	if mode != ImportMerge && mode != ImportOverwrite {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("неизвестный режим загрузки %q", mode)))
+		return
+	}
+
+	defer r.Body.Close()
+
+	var snapshot store.Snapshot
+	if err := json.NewDecoder(r.Body).Decode(&snapshot); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	for i := range snapshot.Metrics {
+		if err := validateLabels(snapshot.Metrics[i].Labels); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("Метрика #%d: %s", i, err.Error())))
+			return
+		}
+	}
+
+	err := ApplyDump(m.storage, snapshot, mode)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"place": place,
+			"error": err.Error(),
+			"mode":  mode,
+			"count": len(snapshot.Metrics),
+		}).Error("Ошибка загрузки среза")
+
+		status := http.StatusInternalServerError
+		if errors.Is(err, store.ErrSnapshotVersion) {
+			status = http.StatusBadRequest
+		}
+
+		w.WriteHeader(writeErrorStatus(err, status))
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"place": place,
+		"mode":  mode,
+		"count": len(snapshot.Metrics),
+	}).Info("Срез загружен")
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeErrorStatus возвращает 503, если запись отклонена из-за заполненной очереди сохранения,
+// и 400, если хранилище отвергло сами метрики.
+func writeErrorStatus(err error, fallback int) int {
+	switch {
+	case errors.Is(err, store.ErrRecorderFull):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, store.ErrInvalidMetric), errors.Is(err, store.ErrTypeMismatch),
+		errors.Is(err, models.ErrBucketsMismatch):
+		return http.StatusBadRequest
+	}
+
+	return fallback
+}
+
+// validateMetric проверяет метрику из JSON до записи в хранилище.
+func validateMetric(data models.Metrics) error {
+	if data.ID == "" {
+		return errors.New("поле id не может быть пустым")
+	}
+
+	if err := models.ValidateName(data.ID); err != nil {
+		return fmt.Errorf("поле id: %w", err)
+	}
+
+	if !isKnownType(data.MType) {
+		return fmt.Errorf("поле type должно быть равно %s, %s или %s", models.Counter, models.Gauge, models.Histogram)
+	}
+
+	if data.MType == models.Counter && data.Delta == nil {
+		return fmt.Errorf("поле delta обязательно при type %s", models.Counter)
+	}
+
+	if data.MType == models.Gauge && data.Value == nil {
+		return fmt.Errorf("поле value обязательно при type %s", models.Gauge)
+	}
+
+	if data.Value != nil && !isFinite(*data.Value) {
+		return errors.New("поле value должно быть конечным числом")
+	}
+
+	if data.MType == models.Histogram && len(data.Observations) == 0 && data.Histogram == nil {
+		return fmt.Errorf("поле observations или histogram обязательно при type %s", models.Histogram)
+	}
+
+	return validateLabels(data.Labels)
+}
+
+// isFinite отсекает NaN и ±Inf: json.Marshal их не кодирует, и один такой ряд ломает любой JSON-ответ со списком рядов.
+func isFinite(value float64) bool {
+	return !math.IsNaN(value) && !math.IsInf(value, 0)
+}
+
+func isKnownType(metricType string) bool {
+	return metricType == models.Counter || metricType == models.Gauge || metricType == models.Histogram
+}
+
+// prepareMetric проверяет метрику и приводит гистограмму к виду с корзинами.
+func (m *MetricsService) prepareMetric(data *models.Metrics) error {
+	if err := validateMetric(*data); err != nil {
+		return err
+	}
+
+	if data.MType == models.Histogram {
+		return m.prepareHistogram(data)
+	}
+
+	return nil
+}
+
+// prepareHistogram раскладывает Observations по корзинам. Границы берутся из запроса,
+// затем из уже сохранённого ряда, иначе используются границы по умолчанию.
+func (m *MetricsService) prepareHistogram(data *models.Metrics) error {
+	if len(data.Observations) == 0 {
+		return data.Histogram.Validate()
+	}
+
+	buckets := m.histogramBuckets
+	if data.Histogram != nil {
+		if data.Histogram.Count != 0 || len(data.Histogram.Counts) != 0 {
+			return errors.New("гистограмма передаётся либо наблюдениями, либо корзинами")
+		}
+		buckets = data.Histogram.Buckets
+	} else if current, err := m.storage.GetHistogram(data.ID, data.Labels); err == nil {
+		buckets = current.Buckets
+	}
+
+	histogram := models.NewHistogramData(buckets)
+	if err := histogram.Validate(); err != nil {
+		return err
+	}
+
+	for _, value := range data.Observations {
+		if !isFinite(value) {
+			return errors.New("наблюдение должно быть конечным числом")
+		}
+		histogram.Observe(value)
+	}
+
+	data.Histogram = &histogram
+	data.Observations = nil
+
+	return nil
+}
+
+func (m *MetricsService) GetHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	place := "[MetricsService.GetHistoryHandler]"
+
+	history, ok := m.storage.(store.HistoryReader)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	metricType := chi.URLParam(r, "type")
+	if metricType != models.Counter && metricType != models.Gauge {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	metricName := chi.URLParam(r, "name")
+	if metricName == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	labels, err := labelsFromQuery(r.URL.Query(), "from", "to")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	from, err := parseTimeParam(r, "from", time.Time{})
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	to, err := parseTimeParam(r, "to", time.Now())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	samples, err := history.GetHistory(metricType, metricName, labels, from, to)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	responseRawData, err := json.Marshal(samples)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"place": place,
 			"error": err.Error(),
-			"Id":    data.ID,
-			"Type":  data.MType,
-			"value": metric,
 		}).Error("Ошибка сериализации JSON")
+
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	w.Write(responseRawData)
 }
+
+// parseTimeParam читает из query-параметра время в формате RFC3339.
+func parseTimeParam(r *http.Request, name string, defaultValue time.Time) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("параметр %s должен быть в формате RFC3339", name)
+	}
+
+	return parsed, nil
+}
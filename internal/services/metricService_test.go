@@ -0,0 +1,120 @@
+package services
+
+import (
+	"github.com/Oresst/goMetrics/internal/store"
+	"github.com/Oresst/goMetrics/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// newTestRouter регистрирует обработчики MetricsService по тем же путям и с теми же middleware, что сервер.
+func newTestRouter(service *MetricsService) chi.Router {
+	r := chi.NewRouter()
+	r.Use(service.LoggerMiddleware)
+	r.Use(service.GzipMiddleware)
+
+	r.Post("/update/{type}/{name}/{value}", service.AddMetricHandler)
+	r.Post("/update", service.AddMetricJSONHandler)
+	r.Post("/updates", service.AddMetricsJSONHandler)
+	r.Post("/snapshot", service.RestoreSnapshotHandler)
+	r.Get("/snapshot", service.GetSnapshotHandler)
+	r.Get("/value/{type}/{name}", service.GetMetricHandler)
+	r.Delete("/value/{type}/{name}", service.DeleteMetricHandler)
+	r.Post("/reset/{type}/{name}", service.ResetMetricHandler)
+	r.Get("/metrics", service.PrometheusHandler)
+	r.Get("/api/metrics", service.ListMetricsHandler)
+	r.Get("/stream", service.StreamHandler)
+	r.Get("/", service.GetAllMetricsHandler)
+
+	return r
+}
+
+func TestNonFiniteValuesRejected(t *testing.T) {
+	service := NewMetricsService(store.NewMemStorage())
+	r := newTestRouter(service)
+
+	for _, path := range []string{
+		"/update/gauge/Alloc/NaN",
+		"/update/gauge/Alloc/+Inf",
+		"/update/gauge/Alloc/-Inf",
+		"/update/histogram/latency/Inf",
+	} {
+		response := httptest.NewRecorder()
+		r.ServeHTTP(response, httptest.NewRequest(http.MethodPost, path, nil))
+		assert.Equal(t, http.StatusBadRequest, response.Code, path)
+	}
+
+	nan := math.NaN()
+	assert.Error(t, service.prepareMetric(&models.Metrics{ID: "Alloc", MType: models.Gauge, Value: &nan}))
+	assert.Error(t, service.prepareMetric(&models.Metrics{ID: "latency", MType: models.Histogram, Observations: []float64{math.Inf(-1)}}))
+	assert.Error(t, service.prepareMetric(&models.Metrics{ID: "latency", MType: models.Histogram, Histogram: &models.HistogramData{
+		Buckets: []float64{1},
+		Counts:  []uint64{0, 1},
+		Count:   1,
+		Sum:     math.Inf(1),
+	}}))
+
+	_, err := ReadDump(strings.NewReader("type,id,labels,delta,value,histogram\ngauge,Alloc,,,NaN,\n"), DumpCSV)
+	assert.Error(t, err)
+
+	response := httptest.NewRecorder()
+	r.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/update/gauge/Alloc/1.5", nil))
+	require.Equal(t, http.StatusOK, response.Code)
+
+	response = httptest.NewRecorder()
+	r.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/api/metrics", nil))
+	assert.Equal(t, http.StatusOK, response.Code)
+}
+
+func TestAmbiguousMetricNamesRejected(t *testing.T) {
+	storage := store.NewMemStorage()
+	r := newTestRouter(NewMetricsService(storage))
+
+	for _, tc := range []struct {
+		name string
+		url  string
+		body string
+	}{
+		{name: "url", url: "/update/gauge/" + url.PathEscape(`cpu{host="a"}`) + "/1"},
+		{name: "json", url: "/update", body: `{"id":"cpu{host=\"a\"}","type":"gauge","value":1}`},
+		{name: "batch", url: "/updates", body: `[{"id":"cpu}","type":"counter","delta":1}]`},
+	} {
+		request := httptest.NewRequest(http.MethodPost, tc.url, strings.NewReader(tc.body))
+		request.Header.Set("Content-Type", "application/json")
+		response := httptest.NewRecorder()
+		r.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusBadRequest, response.Code, tc.name)
+	}
+
+	metrics, err := storage.GetAllMetrics()
+	require.NoError(t, err)
+	assert.Empty(t, metrics)
+}
+
+// Пачка, отвергнутая хранилищем как некорректная, получает 400, а не 500.
+func TestAddMetricsBucketsMismatch(t *testing.T) {
+	storage := store.NewMemStorage()
+	r := newTestRouter(NewMetricsService(storage))
+
+	post := func(body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/updates", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		response := httptest.NewRecorder()
+		r.ServeHTTP(response, request)
+		return response
+	}
+
+	response := post(`[{"id":"latency","type":"histogram","histogram":{"buckets":[1],"counts":[1,0],"count":1,"sum":0.5}}]`)
+	require.Equal(t, http.StatusOK, response.Code)
+
+	response = post(`[{"id":"latency","type":"histogram","histogram":{"buckets":[1,2],"counts":[1,0,0],"count":1,"sum":0.5}}]`)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	assert.Contains(t, response.Body.String(), models.ErrBucketsMismatch.Error())
+}
@@ -0,0 +1,322 @@
+package services
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Oresst/goMetrics/internal/store"
+	"github.com/Oresst/goMetrics/models"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Поля сортировки списка метрик; "-" перед полем в параметре sort сортирует по убыванию.
+const (
+	SortByID        = "id"
+	SortByType      = "type"
+	SortByUpdatedAt = "updated_at"
+	SortByValue     = "value"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+var ErrInvalidQuery = errors.New("invalid metrics query")
+
+// MetricsQuery — фильтры, сортировка и страница для списка рядов.
+type MetricsQuery struct {
+	Types    []string
+	Prefix   string
+	Regexp   *regexp.Regexp
+	Matchers []store.LabelMatcher
+	Sort     string
+	Desc     bool
+	Limit    int
+	Cursor   string
+}
+
+// MetricsPage — страница списка; NextCursor пуст на последней странице.
+type MetricsPage struct {
+	Metrics    []store.Series `json:"metrics"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// pageKey — позиция ряда в выбранном порядке. Курсор хранит ключ последнего ряда страницы,
+// поэтому следующая страница не сдвигается, если ряды добавились или удалились.
+type pageKey struct {
+	Sort      string    `json:"s"`
+	Desc      bool      `json:"d,omitempty"`
+	Type      string    `json:"t"`
+	ID        string    `json:"i"`
+	ValueBits uint64    `json:"v,omitempty"`
+	UpdatedAt time.Time `json:"u"`
+}
+
+// ParseMetricsQuery читает параметры type, prefix, regex, match, sort, limit и cursor.
+// type может перечислять несколько типов через запятую, match — условия на метки как в GET /.
+func ParseMetricsQuery(values url.Values) (MetricsQuery, error) {
+	query := MetricsQuery{
+		Prefix: values.Get("prefix"),
+		Sort:   SortByID,
+		Limit:  defaultPageLimit,
+		Cursor: values.Get("cursor"),
+	}
+
+	if types := values.Get("type"); types != "" {
+		for _, metricType := range strings.Split(types, ",") {
+			switch metricType {
+			case models.Counter, models.Gauge, models.Histogram:
+				query.Types = append(query.Types, metricType)
+			default:
+				return query, fmt.Errorf("%w: unknown type %q", ErrInvalidQuery, metricType)
+			}
+		}
+	}
+
+	if pattern := values.Get("regex"); pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return query, fmt.Errorf("%w: %s", ErrInvalidQuery, err.Error())
+		}
+		query.Regexp = re
+	}
+
+	matchers, err := store.ParseMatchers(values.Get("match"))
+	if err != nil {
+		return query, fmt.Errorf("%w: %s", ErrInvalidQuery, err.Error())
+	}
+	query.Matchers = matchers
+
+	if sortBy := values.Get("sort"); sortBy != "" {
+		query.Desc = strings.HasPrefix(sortBy, "-")
+		query.Sort = strings.TrimPrefix(sortBy, "-")
+
+		switch query.Sort {
+		case SortByID, SortByType, SortByUpdatedAt, SortByValue:
+		default:
+			return query, fmt.Errorf("%w: unknown sort field %q", ErrInvalidQuery, query.Sort)
+		}
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil || query.Limit <= 0 || query.Limit > maxPageLimit {
+			return query, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidQuery, maxPageLimit)
+		}
+	}
+
+	return query, nil
+}
+
+// QueryMetrics отбирает ряды хранилища по query, сортирует их и возвращает страницу после курсора.
+func QueryMetrics(storage store.Store, query MetricsQuery) (MetricsPage, error) {
+	var after *pageKey
+	if query.Cursor != "" {
+		cursor, err := decodeCursor(query.Cursor)
+		if err != nil {
+			return MetricsPage{}, err
+		}
+
+		if cursor.Sort != query.Sort || cursor.Desc != query.Desc {
+			return MetricsPage{}, fmt.Errorf("%w: cursor was issued for another sort order", ErrInvalidQuery)
+		}
+		after = &cursor
+	}
+
+	series, err := storage.GetAllSeries()
+	if err != nil {
+		return MetricsPage{}, err
+	}
+
+	selected := make([]store.Series, 0, len(series))
+	for _, item := range series {
+		if matchesQuery(item.Metrics, query) {
+			selected = append(selected, item)
+		}
+	}
+
+	keys := make([]pageKey, len(selected))
+	for i, item := range selected {
+		keys[i] = newPageKey(item, query)
+	}
+
+	order := make([]int, len(selected))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return keys[order[i]].less(keys[order[j]])
+	})
+
+	start := 0
+	if after != nil {
+		start = sort.Search(len(order), func(i int) bool {
+			return after.less(keys[order[i]])
+		})
+	}
+
+	end := min(start+query.Limit, len(order))
+	page := MetricsPage{Metrics: make([]store.Series, 0, end-start)}
+	for _, i := range order[start:end] {
+		page.Metrics = append(page.Metrics, selected[i])
+	}
+
+	if end < len(order) {
+		page.NextCursor = encodeCursor(keys[order[end-1]])
+	}
+
+	return page, nil
+}
+
+func matchesQuery(metric models.Metrics, query MetricsQuery) bool {
+	if len(query.Types) > 0 && !slices.Contains(query.Types, metric.MType) {
+		return false
+	}
+
+	if !strings.HasPrefix(metric.ID, query.Prefix) {
+		return false
+	}
+
+	if query.Regexp != nil && !query.Regexp.MatchString(metric.ID) {
+		return false
+	}
+
+	return store.MatchLabels(metric.Labels, query.Matchers)
+}
+
+func newPageKey(item store.Series, query MetricsQuery) pageKey {
+	key := pageKey{
+		Sort: query.Sort,
+		Desc: query.Desc,
+		Type: item.MType,
+		ID:   models.SeriesID(item.ID, item.Labels),
+	}
+
+	switch query.Sort {
+	case SortByUpdatedAt:
+		key.UpdatedAt = item.UpdatedAt.UTC()
+	case SortByValue:
+		key.ValueBits = math.Float64bits(seriesValue(item.Metrics))
+	}
+
+	return key
+}
+
+// seriesValue — значение для сортировки: delta счётчика, value гауджа, число наблюдений гистограммы.
+func seriesValue(metric models.Metrics) float64 {
+	switch {
+	case metric.Delta != nil:
+		return float64(*metric.Delta)
+	case metric.Value != nil:
+		return *metric.Value
+	case metric.Histogram != nil:
+		return float64(metric.Histogram.Count)
+	default:
+		return 0
+	}
+}
+
+// less сравнивает по полю сортировки, при равенстве — по имени с метками и типу, чтобы порядок был полным.
+func (k pageKey) less(other pageKey) bool {
+	if c := k.compareField(other); c != 0 {
+		if k.Desc {
+			return c > 0
+		}
+		return c < 0
+	}
+
+	if k.ID != other.ID {
+		return k.ID < other.ID
+	}
+	return k.Type < other.Type
+}
+
+func (k pageKey) compareField(other pageKey) int {
+	switch k.Sort {
+	case SortByType:
+		return strings.Compare(k.Type, other.Type)
+	case SortByUpdatedAt:
+		return k.UpdatedAt.Compare(other.UpdatedAt)
+	case SortByValue:
+		// cmp.Compare считает NaN меньше любого числа, поэтому порядок остаётся полным.
+		return cmp.Compare(math.Float64frombits(k.ValueBits), math.Float64frombits(other.ValueBits))
+	default:
+		return strings.Compare(k.ID, other.ID)
+	}
+}
+
+func encodeCursor(key pageKey) string {
+	data, _ := json.Marshal(key)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string) (pageKey, error) {
+	var key pageKey
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		err = json.Unmarshal(data, &key)
+	}
+
+	if err != nil {
+		return key, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+
+	return key, nil
+}
+
+// ListMetricsHandler отдаёт ряды хранилища в JSON с фильтрами, сортировкой и постраничным курсором.
+func (m *MetricsService) ListMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	place := "[MetricsService.ListMetricsHandler]"
+
+	query, err := ParseMetricsQuery(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	page, err := QueryMetrics(m.storage, query)
+	if errors.Is(err, ErrInvalidQuery) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"place": place,
+			"error": err.Error(),
+		}).Error("Ошибка получения метрик")
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	responseRawData, err := json.Marshal(page)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"place": place,
+			"error": err.Error(),
+		}).Error("Ошибка сериализации JSON")
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseRawData)
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"encoding/json"
+	"github.com/Oresst/goMetrics/internal/store"
+	"github.com/Oresst/goMetrics/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestListMetricsHandler(t *testing.T) {
+	storage := store.NewMemStorage()
+	require.NoError(t, storage.AddCounter("PollCount", nil, 5))
+	require.NoError(t, storage.AddCounter("PollCount", models.Labels{"host": "web-1"}, 2))
+	require.NoError(t, storage.SetGauge("HeapAlloc", models.Labels{"host": "web-2"}, 30))
+	require.NoError(t, storage.SetGauge("HeapInuse", nil, 10))
+	require.NoError(t, storage.SetGauge("Alloc", nil, 20))
+	r := newTestRouter(NewMetricsService(storage))
+
+	list := func(t *testing.T, query string) (int, MetricsPage) {
+		request := httptest.NewRequest(http.MethodGet, "/api/metrics"+query, nil)
+		response := httptest.NewRecorder()
+		r.ServeHTTP(response, request)
+
+		var page MetricsPage
+		if response.Code == http.StatusOK {
+			assert.Equal(t, "application/json", response.Header().Get("Content-Type"))
+			require.NoError(t, json.Unmarshal(response.Body.Bytes(), &page))
+		}
+		return response.Code, page
+	}
+
+	ids := func(page MetricsPage) []string {
+		result := make([]string, 0, len(page.Metrics))
+		for _, item := range page.Metrics {
+			result = append(result, models.SeriesID(item.ID, item.Labels))
+		}
+		return result
+	}
+
+	testCases := []struct {
+		name     string
+		query    string
+		code     int
+		expected []string
+	}{
+		{name: "all by id", code: http.StatusOK, expected: []string{"Alloc", "HeapAlloc{host=\"web-2\"}", "HeapInuse", "PollCount", "PollCount{host=\"web-1\"}"}},
+		{name: "type", query: "?type=counter", code: http.StatusOK, expected: []string{"PollCount", "PollCount{host=\"web-1\"}"}},
+		{name: "prefix", query: "?prefix=Heap", code: http.StatusOK, expected: []string{"HeapAlloc{host=\"web-2\"}", "HeapInuse"}},
+		{name: "regex", query: "?regex=" + url.QueryEscape("Alloc$"), code: http.StatusOK, expected: []string{"Alloc", "HeapAlloc{host=\"web-2\"}"}},
+		{name: "labels", query: "?match=" + url.QueryEscape(`host=~"web-.*"`), code: http.StatusOK, expected: []string{"HeapAlloc{host=\"web-2\"}", "PollCount{host=\"web-1\"}"}},
+		{name: "value desc", query: "?type=gauge&sort=-value", code: http.StatusOK, expected: []string{"HeapAlloc{host=\"web-2\"}", "Alloc", "HeapInuse"}},
+		{name: "unknown type", query: "?type=summary", code: http.StatusBadRequest},
+		{name: "bad regex", query: "?regex=(", code: http.StatusBadRequest},
+		{name: "bad sort", query: "?sort=size", code: http.StatusBadRequest},
+		{name: "bad limit", query: "?limit=0", code: http.StatusBadRequest},
+		{name: "bad cursor", query: "?cursor=!!", code: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			code, page := list(t, tc.query)
+			require.Equal(t, tc.code, code)
+			if code == http.StatusOK {
+				assert.Equal(t, tc.expected, ids(page))
+				assert.Empty(t, page.NextCursor)
+			}
+		})
+	}
+
+	t.Run("fields", func(t *testing.T) {
+		_, page := list(t, "?prefix=Poll&limit=1")
+		require.Len(t, page.Metrics, 1)
+		assert.Equal(t, models.Counter, page.Metrics[0].MType)
+		assert.Equal(t, int64(5), *page.Metrics[0].Delta)
+		assert.WithinDuration(t, time.Now(), page.Metrics[0].UpdatedAt, time.Minute)
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		for _, sortBy := range []string{"id", "-updated_at", "value", "-type"} {
+			_, all := list(t, "?sort="+sortBy)
+			require.Len(t, all.Metrics, 5)
+
+			var walked []string
+			query := "?limit=2&sort=" + sortBy
+			for pages := 0; ; pages++ {
+				require.Less(t, pages, 5, sortBy)
+
+				code, page := list(t, query)
+				require.Equal(t, http.StatusOK, code)
+				walked = append(walked, ids(page)...)
+
+				if page.NextCursor == "" {
+					break
+				}
+				query = "?limit=2&sort=" + sortBy + "&cursor=" + page.NextCursor
+			}
+			assert.Equal(t, ids(all), walked, sortBy)
+		}
+
+		_, page := list(t, "?limit=2")
+		code, _ := list(t, "?sort=value&cursor="+page.NextCursor)
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("stable cursor", func(t *testing.T) {
+		_, page := list(t, "?limit=2")
+		require.NoError(t, storage.SetGauge("AAA", nil, 1))
+
+		_, next := list(t, "?limit=10&cursor="+page.NextCursor)
+		assert.Equal(t, []string{"HeapInuse", "PollCount", "PollCount{host=\"web-1\"}"}, ids(next))
+	})
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Форматы списка метрик на GET /, в порядке предпочтения сервера.
+const (
+	MediaHTML  = "text/html"
+	MediaJSON  = "application/json"
+	MediaCSV   = "text/csv"
+	MediaPlain = "text/plain"
+)
+
+var rootMediaTypes = []string{MediaHTML, MediaJSON, MediaCSV, MediaPlain}
+
+// negotiateMediaType выбирает из offers тип с наибольшим q по заголовку Accept.
+// Для каждого типа берётся самый точный подходящий диапазон (type/subtype, затем type/*, затем */*),
+// при равном q побеждает тип, стоящий в offers раньше. Пустая строка — ни один тип не подошёл.
+func negotiateMediaType(accept string, offers []string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+
+	ranges := parseAccept(accept)
+
+	best := ""
+	bestQ := 0.0
+	for _, offer := range offers {
+		q, specificity := 0.0, -1
+		for _, mediaRange := range ranges {
+			s := mediaRange.matches(offer)
+			if s > specificity {
+				q, specificity = mediaRange.q, s
+			}
+		}
+
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+
+	return best
+}
+
+type acceptRange struct {
+	mediaType string
+	subtype   string
+	q         float64
+}
+
+func parseAccept(accept string) []acceptRange {
+	ranges := make([]acceptRange, 0)
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+
+		mediaType, subtype, ok := strings.Cut(strings.ToLower(strings.TrimSpace(params[0])), "/")
+		if !ok || mediaType == "" || subtype == "" {
+			continue
+		}
+
+		mediaRange := acceptRange{mediaType: mediaType, subtype: subtype, q: 1}
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.ToLower(name) != "q" {
+				continue
+			}
+
+			q, err := strconv.ParseFloat(value, 64)
+			if err != nil || q < 0 || q > 1 {
+				q = 0
+			}
+			mediaRange.q = q
+		}
+
+		ranges = append(ranges, mediaRange)
+	}
+
+	return ranges
+}
+
+// matches возвращает точность совпадения диапазона с типом: 2 — точное, 1 — type/*, 0 — */*, -1 — не подходит.
+func (a acceptRange) matches(offer string) int {
+	mediaType, subtype, _ := strings.Cut(offer, "/")
+
+	switch {
+	case a.mediaType == mediaType && a.subtype == subtype:
+		return 2
+	case a.mediaType == mediaType && a.subtype == "*":
+		return 1
+	case a.mediaType == "*" && a.subtype == "*":
+		return 0
+	default:
+		return -1
+	}
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"github.com/Oresst/goMetrics/internal/store"
+	"github.com/Oresst/goMetrics/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGetAllMetricsNegotiation(t *testing.T) {
+	storage := store.NewMemStorage()
+	require.NoError(t, storage.AddCounter("PollCount", nil, 5))
+	require.NoError(t, storage.SetGauge("Alloc", models.Labels{"host": "a"}, 1.5))
+	r := newTestRouter(NewMetricsService(storage))
+
+	testCases := []struct {
+		name        string
+		accept      string
+		code        int
+		contentType string
+		body        string
+	}{
+		{name: "no accept", code: http.StatusOK, contentType: "text/html; charset=utf-8"},
+		{name: "browser", accept: "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", code: http.StatusOK, contentType: "text/html; charset=utf-8"},
+		{name: "any", accept: "*/*", code: http.StatusOK, contentType: "text/html; charset=utf-8"},
+		{
+			name:        "json",
+			accept:      "application/json",
+			code:        http.StatusOK,
+			contentType: "application/json",
+			body:        `[{"id":"PollCount","type":"counter","delta":5},{"id":"Alloc","type":"gauge","value":1.5,"labels":{"host":"a"}}]` + "\n",
+		},
+		{
+			name:        "csv",
+			accept:      "text/csv",
+			code:        http.StatusOK,
+			contentType: "text/csv; charset=utf-8",
+			body:        "type,id,labels,delta,value,histogram\ncounter,PollCount,,5,,\ngauge,Alloc,\"{\"\"host\"\":\"\"a\"\"}\",,1.5,\n",
+		},
+		{
+			name:        "plain",
+			accept:      "text/plain",
+			code:        http.StatusOK,
+			contentType: "text/plain; charset=utf-8",
+			body:        "PollCount=5\nAlloc{host=\"a\"}=1.5\n",
+		},
+		{name: "quality", accept: "text/html;q=0.5, text/plain;q=0.9", code: http.StatusOK, contentType: "text/plain; charset=utf-8"},
+		{name: "wildcard subtype", accept: "text/*;q=0.3, application/json;q=0.2", code: http.StatusOK, contentType: "text/html; charset=utf-8"},
+		{name: "excluded", accept: "text/html;q=0, */*", code: http.StatusOK, contentType: "application/json"},
+		{name: "unsupported", accept: "application/xml", code: http.StatusNotAcceptable},
+		{name: "all refused", accept: "*/*;q=0", code: http.StatusNotAcceptable},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.accept != "" {
+				request.Header.Set("Accept", tc.accept)
+			}
+			response := httptest.NewRecorder()
+			r.ServeHTTP(response, request)
+
+			require.Equal(t, tc.code, response.Code)
+			assert.Contains(t, response.Header().Values("Vary"), "Accept")
+			if tc.code != http.StatusOK {
+				return
+			}
+
+			assert.Equal(t, tc.contentType, response.Header().Get("Content-Type"))
+			if tc.body != "" {
+				assert.Equal(t, tc.body, response.Body.String())
+			}
+			if strings.HasPrefix(tc.contentType, "text/html") {
+				assert.Contains(t, response.Body.String(), `<a href="/value/gauge/Alloc?host=a">`)
+			}
+		})
+	}
+
+	t.Run("empty json", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("Accept", "application/json")
+		response := httptest.NewRecorder()
+		newTestRouter(NewMetricsService(store.NewMemStorage())).ServeHTTP(response, request)
+
+		require.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "[]\n", response.Body.String())
+	})
+}
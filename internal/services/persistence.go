@@ -0,0 +1,83 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Oresst/goMetrics/internal/store"
+	"github.com/Oresst/goMetrics/models"
+	"time"
+)
+
+// Способы сохранения метрик между перезапусками сервера.
+const (
+	PersistenceFile = "file"
+	PersistenceSQL  = "sql"
+	PersistenceDir  = "dir"
+	PersistenceNone = "none"
+)
+
+// Persistence сохраняет изменения хранилища и восстанавливает их после перезапуска.
+// Admit, Write и Rewrite вызываются из store.PersistentStorage, Load — при старте,
+// Flush дописывает накопленное, Close сбрасывает остаток и освобождает ресурсы.
+type Persistence interface {
+	store.Recorder
+	Flush() error
+	Load() ([]models.Metrics, LoadReport, error)
+	Close() error
+}
+
+var ErrRestorePoint = errors.New("restore point is before the oldest persisted state")
+
+// RecordsUntil оставляет записи, принятые не позже until, для восстановления на момент времени.
+// Записи без времени приёма, сохранённые до его появления, считаются ранними и остаются.
+// Записи состояния (models.OpState) из сжатия и Rewrite хранят накопленное значение ряда на время
+// его последнего обновления, а прежние значения ряда уже не сохранены. Если такая запись или уже первая
+// запись новее until, состояние на этот момент не восстановить, и возвращается ErrRestorePoint.
+func RecordsUntil(records []models.Metrics, until time.Time) ([]models.Metrics, error) {
+	limit := until.UnixNano()
+	if len(records) > 0 && records[0].Timestamp > limit {
+		oldest := time.Unix(0, records[0].Timestamp).UTC()
+		return nil, fmt.Errorf("%w: oldest record is from %s", ErrRestorePoint, oldest.Format(time.RFC3339Nano))
+	}
+
+	result := make([]models.Metrics, 0, len(records))
+	for _, record := range records {
+		if record.Timestamp <= limit {
+			result = append(result, record)
+			continue
+		}
+
+		if record.Op == models.OpState {
+			updated := time.Unix(0, record.Timestamp).UTC()
+			return nil, fmt.Errorf("%w: %s %s is compacted at %s", ErrRestorePoint,
+				record.MType, models.SeriesID(record.ID, record.Labels), updated.Format(time.RFC3339Nano))
+		}
+	}
+
+	return result, nil
+}
+
+// NopPersistence ничего не сохраняет: метрики живут только в памяти.
+type NopPersistence struct{}
+
+func (NopPersistence) Admit(records int) error {
+	return nil
+}
+
+func (NopPersistence) Write(metric models.Metrics) {}
+
+func (NopPersistence) Rewrite(metrics []models.Metrics) error {
+	return nil
+}
+
+func (NopPersistence) Flush() error {
+	return nil
+}
+
+func (NopPersistence) Load() ([]models.Metrics, LoadReport, error) {
+	return nil, LoadReport{}, nil
+}
+
+func (NopPersistence) Close() error {
+	return nil
+}
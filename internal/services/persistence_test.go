@@ -0,0 +1,137 @@
+package services
+
+import (
+	"github.com/Oresst/goMetrics/internal/store"
+	"github.com/Oresst/goMetrics/internal/utils"
+	"github.com/Oresst/goMetrics/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPersistenceBackends(t *testing.T) {
+	testCases := []struct {
+		name string
+		open func(t *testing.T, dir string, source SnapshotSource) Persistence
+	}{
+		{
+			name: PersistenceFile,
+			open: func(t *testing.T, dir string, source SnapshotSource) Persistence {
+				fileService, err := NewFileService(filepath.Join(dir, "metrics.txt"), 0)
+				require.NoError(t, err)
+				return fileService
+			},
+		},
+		{
+			name: PersistenceDir,
+			open: func(t *testing.T, dir string, source SnapshotSource) Persistence {
+				persistence, err := NewSnapshotDirService(dir, 0, 2, source)
+				require.NoError(t, err)
+				return persistence
+			},
+		},
+		{
+			name: PersistenceSQL,
+			open: func(t *testing.T, dir string, source SnapshotSource) Persistence {
+				dsn := os.Getenv("TEST_DATABASE_DSN")
+				if dsn == "" {
+					t.Skip("TEST_DATABASE_DSN is not set")
+				}
+
+				persistence, err := NewSQLPersistence(dsn, 0)
+				require.NoError(t, err)
+				return persistence
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			mem := store.NewMemStorage()
+			persistence := tc.open(t, dir, mem)
+			require.NoError(t, persistence.Rewrite(nil))
+
+			storage := store.NewPersistentStorage(mem, persistence)
+			require.NoError(t, storage.AddCounter("PollCount", nil, 2))
+			require.NoError(t, storage.AddCounter("PollCount", nil, 3))
+			require.NoError(t, storage.SetGauge("Alloc", models.Labels{"host": "web-1"}, 1.5))
+			require.NoError(t, persistence.Flush())
+			require.NoError(t, persistence.Close())
+
+			persistence = tc.open(t, dir, store.NewMemStorage())
+			defer persistence.Close()
+
+			data, report, err := persistence.Load()
+			require.NoError(t, err)
+			assert.Zero(t, report.Skipped)
+
+			snapshot, err := store.ReplayLog(data)
+			require.NoError(t, err)
+			restored := store.NewMemStorage()
+			require.NoError(t, restored.Restore(snapshot))
+
+			expected, err := mem.GetAllMetrics()
+			require.NoError(t, err)
+			actual, err := restored.GetAllMetrics()
+			require.NoError(t, err)
+			assert.Equal(t, expected, actual)
+		})
+	}
+
+	data, _, err := NopPersistence{}.Load()
+	require.NoError(t, err)
+	assert.Empty(t, data)
+}
+
+func TestRecordsUntil(t *testing.T) {
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	record := func(id string, ts time.Time) models.Metrics {
+		metric := models.Metrics{ID: id, MType: models.Counter, Delta: utils.PointInt64(1)}
+		if !ts.IsZero() {
+			metric.Timestamp = ts.UnixNano()
+		}
+		return metric
+	}
+
+	records := []models.Metrics{
+		record("legacy", time.Time{}),
+		record("a", base),
+		record("b", base.Add(time.Minute)),
+		record("c", base.Add(2*time.Minute)),
+	}
+
+	testCases := []struct {
+		name     string
+		records  []models.Metrics
+		until    time.Time
+		expected []string
+		err      error
+	}{
+		{name: "before b", records: records, until: base.Add(30 * time.Second), expected: []string{"legacy", "a"}},
+		{name: "exactly b", records: records, until: base.Add(time.Minute), expected: []string{"legacy", "a", "b"}},
+		{name: "after all", records: records, until: base.Add(time.Hour), expected: []string{"legacy", "a", "b", "c"}},
+		{name: "before oldest", records: records[1:], until: base.Add(-time.Second), err: ErrRestorePoint},
+		{name: "empty", until: base, expected: []string{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := RecordsUntil(tc.records, tc.until)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+
+			ids := make([]string, 0, len(result))
+			for _, metric := range result {
+				ids = append(ids, metric.ID)
+			}
+			assert.Equal(t, tc.expected, ids)
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package services
+
+import (
+	"bufio"
+	"github.com/Oresst/goMetrics/models"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// promFamily — ряды с одним именем после приведения к правилам Prometheus.
+type promFamily struct {
+	name       string
+	metricType string
+	series     []models.Metrics
+}
+
+// PrometheusHandler отдаёт все ряды хранилища в текстовом формате Prometheus.
+func (m *MetricsService) PrometheusHandler(w http.ResponseWriter, r *http.Request) {
+	place := "[MetricsService.PrometheusHandler]"
+
+	metrics, err := m.storage.GetAllMetrics()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"place": place,
+			"error": err.Error(),
+		}).Error("Ошибка получения метрик")
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", prometheusContentType)
+	w.WriteHeader(http.StatusOK)
+
+	if err = writePrometheus(w, metrics); err != nil {
+		log.WithFields(log.Fields{
+			"place": place,
+			"error": err.Error(),
+		}).Error("Ошибка записи ответа")
+	}
+}
+
+// writePrometheus группирует ряды по имени и пишет для каждой группы строку TYPE и значения рядов.
+// Prometheus отклоняет весь ответ, если одно имя встречается с двумя типами или один ряд повторяется,
+// поэтому после приведения имён пропускаются с предупреждением:
+//   - ряды другого типа, чем у первого ряда с тем же именем;
+//   - группы, имя которых занято другой группой, например гаудж x_bucket рядом с гистограммой x;
+//   - повторы рядов группы, например a.b и a-b с одинаковыми метками.
+func writePrometheus(w io.Writer, metrics []models.Metrics) error {
+	families := make(map[string]*promFamily)
+	for _, metric := range metrics {
+		name := sanitizeMetricName(metric.ID)
+
+		family, ok := families[name]
+		if !ok {
+			family = &promFamily{name: name, metricType: metric.MType}
+			families[name] = family
+		}
+
+		if family.metricType != metric.MType {
+			log.WithFields(log.Fields{
+				"place":  "[writePrometheus]",
+				"metric": metric.ID,
+				"type":   metric.MType,
+				"name":   name,
+			}).Warn("Prometheus name is already used by another type")
+			continue
+		}
+
+		family.series = append(family.series, metric)
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Гистограмма x занимает и имена x_bucket, x_sum и x_count. Имена перебираются по порядку,
+	// поэтому гистограмма всегда раньше групп с именами на её суффиксы.
+	claimed := make(map[string]string)
+	buf := bufio.NewWriter(w)
+	for _, name := range names {
+		family := families[name]
+
+		family.series = uniqueSeries(name, family.series)
+		if len(family.series) == 0 {
+			continue
+		}
+
+		sampleNames := []string{name}
+		if family.metricType == models.Histogram {
+			sampleNames = append(sampleNames, name+"_bucket", name+"_sum", name+"_count")
+		}
+
+		if owner, ok := claimedBy(claimed, sampleNames); ok {
+			log.WithFields(log.Fields{
+				"place": "[writePrometheus]",
+				"type":  family.metricType,
+				"name":  name,
+				"owner": owner,
+			}).Warn("Prometheus name is already used by another family")
+			continue
+		}
+		for _, sampleName := range sampleNames {
+			claimed[sampleName] = name
+		}
+
+		buf.WriteString("# TYPE ")
+		buf.WriteString(name)
+		buf.WriteByte(' ')
+		buf.WriteString(family.metricType)
+		buf.WriteByte('\n')
+
+		for _, metric := range family.series {
+			switch metric.MType {
+			case models.Counter:
+				writeSample(buf, name, metric.Labels, "", "", strconv.FormatInt(*metric.Delta, 10))
+			case models.Gauge:
+				writeSample(buf, name, metric.Labels, "", "", formatPromFloat(*metric.Value))
+			case models.Histogram:
+				writeHistogram(buf, name, metric.Labels, *metric.Histogram)
+			}
+		}
+	}
+
+	return buf.Flush()
+}
+
+func claimedBy(claimed map[string]string, names []string) (string, bool) {
+	for _, name := range names {
+		if owner, ok := claimed[name]; ok {
+			return owner, true
+		}
+	}
+
+	return "", false
+}
+
+// uniqueSeries оставляет первый из рядов, которые после приведения имён меток совпали,
+// и пропускает ряды, у которых совпали имена двух меток.
+func uniqueSeries(name string, series []models.Metrics) []models.Metrics {
+	seen := make(map[string]struct{}, len(series))
+	unique := series[:0:0]
+	for _, metric := range series {
+		key, ok := promLabelsKey(metric.Labels)
+		if _, duplicate := seen[key]; !ok || duplicate {
+			log.WithFields(log.Fields{
+				"place":  "[writePrometheus]",
+				"metric": metric.ID,
+				"labels": metric.Labels.String(),
+				"name":   name,
+			}).Warn("Prometheus series is duplicated after name sanitizing")
+			continue
+		}
+
+		seen[key] = struct{}{}
+		unique = append(unique, metric)
+	}
+
+	return unique
+}
+
+// promLabelsKey возвращает метки ряда в виде строки после приведения имён; false — если имена двух меток совпали.
+func promLabelsKey(labels models.Labels) (string, bool) {
+	sanitized := make(models.Labels, len(labels))
+	for key, value := range labels {
+		name := sanitizeLabelName(key)
+		if _, ok := sanitized[name]; ok {
+			return "", false
+		}
+		sanitized[name] = value
+	}
+
+	return sanitized.String(), true
+}
+
+// writeHistogram пишет накопительные корзины _bucket с меткой le, затем _sum и _count.
+func writeHistogram(buf *bufio.Writer, name string, labels models.Labels, histogram models.HistogramData) {
+	var cumulative uint64
+	for i, bound := range histogram.Buckets {
+		if i < len(histogram.Counts) {
+			cumulative += histogram.Counts[i]
+		}
+		writeSample(buf, name+"_bucket", labels, "le", formatPromFloat(bound), strconv.FormatUint(cumulative, 10))
+	}
+
+	writeSample(buf, name+"_bucket", labels, "le", "+Inf", strconv.FormatUint(histogram.Count, 10))
+	writeSample(buf, name+"_sum", labels, "", "", formatPromFloat(histogram.Sum))
+	writeSample(buf, name+"_count", labels, "", "", strconv.FormatUint(histogram.Count, 10))
+}
+
+// writeSample пишет строку ряда; extraName и extraValue — дополнительная метка вроде le у корзин.
+func writeSample(buf *bufio.Writer, name string, labels models.Labels, extraName string, extraValue string, value string) {
+	buf.WriteString(name)
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		if key != extraName {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	if len(keys) > 0 || extraName != "" {
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeLabel(buf, sanitizeLabelName(key), labels[key])
+		}
+
+		if extraName != "" {
+			if len(keys) > 0 {
+				buf.WriteByte(',')
+			}
+			writeLabel(buf, extraName, extraValue)
+		}
+		buf.WriteByte('}')
+	}
+
+	buf.WriteByte(' ')
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+func writeLabel(buf *bufio.Writer, name string, value string) {
+	buf.WriteString(name)
+	buf.WriteString(`="`)
+	buf.WriteString(escapeLabelValue(value))
+	buf.WriteByte('"')
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueReplacer.Replace(value)
+}
+
+// sanitizeMetricName приводит имя к [a-zA-Z_:][a-zA-Z0-9_:]*, заменяя прочие символы на '_'.
+func sanitizeMetricName(name string) string {
+	return sanitizeName(name, true)
+}
+
+// sanitizeLabelName приводит имя метки к [a-zA-Z_][a-zA-Z0-9_]*.
+func sanitizeLabelName(name string) string {
+	return sanitizeName(name, false)
+}
+
+func sanitizeName(name string, colon bool) string {
+	if name == "" {
+		return "_"
+	}
+
+	var builder strings.Builder
+	builder.Grow(len(name) + 1)
+	for i, r := range name {
+		valid := r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
+			(colon && r == ':') || (i > 0 && r >= '0' && r <= '9')
+
+		switch {
+		case valid:
+			builder.WriteRune(r)
+		case i == 0 && r >= '0' && r <= '9':
+			builder.WriteByte('_')
+			builder.WriteRune(r)
+		default:
+			builder.WriteByte('_')
+		}
+	}
+
+	return builder.String()
+}
+
+func formatPromFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"github.com/Oresst/goMetrics/internal/store"
+	"github.com/Oresst/goMetrics/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPrometheusHandler(t *testing.T) {
+	storage := store.NewMemStorage()
+	require.NoError(t, storage.AddCounter("PollCount", nil, 5))
+	require.NoError(t, storage.AddCounter("PollCount", models.Labels{"host": "web-1"}, 2))
+	require.NoError(t, storage.SetGauge("Alloc", nil, 1.5))
+	require.NoError(t, storage.SetGauge("heap.in-use", models.Labels{"path": `C:\tmp "x"`}, math.Inf(1)))
+	require.NoError(t, storage.SetGauge("1up", nil, 0))
+	require.NoError(t, storage.AddHistogram("latency", models.Labels{"host": "web-1"}, models.HistogramData{
+		Buckets: []float64{0.1, 1},
+		Counts:  []uint64{1, 2, 3},
+		Count:   6,
+		Sum:     12.5,
+	}))
+
+	r := newTestRouter(NewMetricsService(storage))
+	request := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	response := httptest.NewRecorder()
+	r.ServeHTTP(response, request)
+
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", response.Header().Get("Content-Type"))
+
+	expected := `# TYPE Alloc gauge
+Alloc 1.5
+# TYPE PollCount counter
+PollCount 5
+PollCount{host="web-1"} 2
+# TYPE _1up gauge
+_1up 0
+# TYPE heap_in_use gauge
+heap_in_use{path="C:\\tmp \"x\""} +Inf
+# TYPE latency histogram
+latency_bucket{host="web-1",le="0.1"} 1
+latency_bucket{host="web-1",le="1"} 3
+latency_bucket{host="web-1",le="+Inf"} 6
+latency_sum{host="web-1"} 12.5
+latency_count{host="web-1"} 6
+`
+	assert.Equal(t, expected, response.Body.String())
+}
+
+func TestPrometheusNameCollision(t *testing.T) {
+	storage := store.NewMemStorage()
+	require.NoError(t, storage.AddCounter("requests_total", nil, 1))
+	require.NoError(t, storage.SetGauge("requests.total", nil, 2))
+	require.NoError(t, storage.SetGauge("requests-total", models.Labels{"host": "a"}, 3))
+
+	// Одинаковый тип и метки: a-b и a.b дают один ряд a_b, остаётся первый.
+	require.NoError(t, storage.SetGauge("a-b", nil, 4))
+	require.NoError(t, storage.SetGauge("a.b", nil, 5))
+	require.NoError(t, storage.SetGauge("a.b", models.Labels{"host": "a"}, 6))
+
+	// Совпали имена меток внутри ряда.
+	require.NoError(t, storage.SetGauge("c", models.Labels{"x.y": "1", "x-y": "2"}, 7))
+
+	// Гаудж занимает имя корзин гистограммы.
+	require.NoError(t, storage.AddHistogram("latency", nil, models.HistogramData{
+		Buckets: []float64{1},
+		Counts:  []uint64{1, 0},
+		Count:   1,
+		Sum:     0.5,
+	}))
+	require.NoError(t, storage.SetGauge("latency_bucket", nil, 8))
+	require.NoError(t, storage.SetGauge("latency.count", nil, 9))
+
+	r := newTestRouter(NewMetricsService(storage))
+	request := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	response := httptest.NewRecorder()
+	r.ServeHTTP(response, request)
+
+	require.Equal(t, http.StatusOK, response.Code)
+
+	expected := `# TYPE a_b gauge
+a_b 4
+a_b{host="a"} 6
+# TYPE latency histogram
+latency_bucket{le="1"} 1
+latency_bucket{le="+Inf"} 1
+latency_sum 0.5
+latency_count 1
+# TYPE requests_total counter
+requests_total 1
+`
+	assert.Equal(t, expected, response.Body.String())
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Этапы восстановления метрик при старте.
+const (
+	RestoreLoading   = "loading"
+	RestoreReplaying = "replaying"
+	RestoreApplying  = "applying"
+)
+
+// RestoreProgress — ход восстановления: этап, обработано записей из total.
+type RestoreProgress struct {
+	Phase     string    `json:"phase"`
+	Done      int       `json:"done"`
+	Total     int       `json:"total"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// Readiness отмечает, идёт ли восстановление. Пока оно идёт, сервер жив, но не готов принимать метрики.
+type Readiness struct {
+	mu        sync.RWMutex
+	restoring bool
+	progress  RestoreProgress
+}
+
+func (r *Readiness) StartRestore() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.restoring = true
+	r.progress = RestoreProgress{Phase: RestoreLoading, StartedAt: time.Now().UTC()}
+}
+
+func (r *Readiness) SetProgress(phase string, done int, total int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.progress.Phase = phase
+	r.progress.Done = done
+	r.progress.Total = total
+}
+
+func (r *Readiness) FinishRestore() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.restoring = false
+}
+
+// Restoring сообщает, идёт ли восстановление, и его ход.
+func (r *Readiness) Restoring() (bool, RestoreProgress) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.restoring, r.progress
+}
+
+type readinessResponse struct {
+	Status  string           `json:"status"`
+	Restore *RestoreProgress `json:"restore,omitempty"`
+}
+
+// Readiness возвращает состояние готовности сервиса.
+func (m *MetricsService) Readiness() *Readiness {
+	return m.readiness
+}
+
+// HealthzHandler отвечает 200, пока процесс способен обслуживать запросы.
+func (m *MetricsService) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeReadiness(w, http.StatusOK, readinessResponse{Status: "ok"})
+}
+
+// ReadyzHandler отвечает 503 с ходом восстановления, пока оно идёт, и 200 после.
+func (m *MetricsService) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	restoring, progress := m.readiness.Restoring()
+	if restoring {
+		writeReadiness(w, http.StatusServiceUnavailable, readinessResponse{Status: "restoring", Restore: &progress})
+		return
+	}
+
+	writeReadiness(w, http.StatusOK, readinessResponse{Status: "ready"})
+}
+
+// ReadyMiddleware отклоняет запись метрик с 503, пока идёт восстановление,
+// чтобы обновления не применились к хранилищу, которое будет заменено.
+func (m *MetricsService) ReadyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if restoring, _ := m.readiness.Restoring(); restoring {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("restore in progress"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeReadiness(w http.ResponseWriter, status int, response readinessResponse) {
+	data, err := json.Marshal(response)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
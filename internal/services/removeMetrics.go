@@ -0,0 +1,123 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/Oresst/goMetrics/internal/store"
+	"github.com/Oresst/goMetrics/models"
+	"github.com/go-chi/chi/v5"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// DeleteMetricHandler удаляет ряд: DELETE /value/{type}/{name}, метки — параметрами запроса.
+func (m *MetricsService) DeleteMetricHandler(w http.ResponseWriter, r *http.Request) {
+	m.changeMetric(w, r, "[MetricsService.DeleteMetricHandler]", m.storage.DeleteMetric)
+}
+
+// ResetMetricHandler обнуляет ряд: POST /reset/{type}/{name}, метки — параметрами запроса.
+func (m *MetricsService) ResetMetricHandler(w http.ResponseWriter, r *http.Request) {
+	m.changeMetric(w, r, "[MetricsService.ResetMetricHandler]", m.storage.ResetMetric)
+}
+
+// DeleteByPrefixHandler удаляет все ряды типа с именем на prefix: DELETE /value/{type}?prefix=...
+// В ответе — удалённые ряды со значениями до удаления.
+func (m *MetricsService) DeleteByPrefixHandler(w http.ResponseWriter, r *http.Request) {
+	m.changeByPrefix(w, r, "[MetricsService.DeleteByPrefixHandler]", m.storage.DeleteByPrefix)
+}
+
+// ResetByPrefixHandler обнуляет все ряды типа с именем на prefix: POST /reset/{type}?prefix=...
+// В ответе — обнулённые ряды со значениями до сброса.
+func (m *MetricsService) ResetByPrefixHandler(w http.ResponseWriter, r *http.Request) {
+	m.changeByPrefix(w, r, "[MetricsService.ResetByPrefixHandler]", m.storage.ResetByPrefix)
+}
+
+func (m *MetricsService) changeMetric(w http.ResponseWriter, r *http.Request, place string, change func(string, string, models.Labels) error) {
+	w.Header().Set("Content-Type", "text/plain")
+
+	metricType := chi.URLParam(r, "type")
+	if !isKnownType(metricType) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	metricName := chi.URLParam(r, "name")
+	if metricName == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	labels, err := labelsFromQuery(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if err = change(metricType, metricName, labels); err != nil {
+		log.WithFields(log.Fields{
+			"place": place,
+			"error": err.Error(),
+			"type":  metricType,
+			"id":    metricName,
+		}).Error("Ошибка при изменении метрики")
+
+		w.WriteHeader(changeErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (m *MetricsService) changeByPrefix(w http.ResponseWriter, r *http.Request, place string, change func(string, string) ([]models.Metrics, error)) {
+	metricType := chi.URLParam(r, "type")
+	if !isKnownType(metricType) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	prefix := r.URL.Query().Get("prefix")
+	if prefix == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("параметр prefix обязателен"))
+		return
+	}
+
+	changed, err := change(metricType, prefix)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"place":  place,
+			"error":  err.Error(),
+			"type":   metricType,
+			"prefix": prefix,
+		}).Error("Ошибка при изменении метрик")
+
+		w.WriteHeader(changeErrorStatus(err))
+		return
+	}
+
+	if changed == nil {
+		changed = []models.Metrics{}
+	}
+
+	data, err := json.Marshal(changed)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+func changeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, store.ErrMetricNotFound), errors.Is(err, store.ErrTypeMismatch):
+		return http.StatusNotFound
+	case errors.Is(err, store.ErrInvalidMetric):
+		return http.StatusBadRequest
+	default:
+		return writeErrorStatus(err, http.StatusInternalServerError)
+	}
+}
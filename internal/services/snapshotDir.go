@@ -0,0 +1,252 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Oresst/goMetrics/internal/store"
+	"github.com/Oresst/goMetrics/models"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var snapshotFilePattern = regexp.MustCompile(`^snapshot-(\d{20})\.json$`)
+
+// SnapshotDirService хранит состояние хранилища полными снимками store.Snapshot в каталоге,
+// по файлу на снимок, и оставляет keep последних. Если последний снимок повреждён,
+// восстановление берёт предыдущий.
+type SnapshotDirService struct {
+	dir      string
+	interval time.Duration
+	keep     int
+	source   SnapshotSource
+	recovery string
+	dirty    atomic.Bool
+	stopChan chan bool
+
+	// mu упорядочивает запись снимков и удаление старых.
+	mu sync.Mutex
+}
+
+func NewSnapshotDirService(dir string, duration time.Duration, keep int, source SnapshotSource) (*SnapshotDirService, error) {
+	if keep <= 0 {
+		return nil, fmt.Errorf("число хранимых снимков должно быть положительным: %d", keep)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &SnapshotDirService{
+		dir:      dir,
+		interval: duration,
+		keep:     keep,
+		source:   source,
+		recovery: RecoverySkip,
+		stopChan: make(chan bool),
+	}, nil
+}
+
+// SetRecovery задаёт режим чтения повреждённых снимков; quarantine для каталога равносилен skip.
+func (d *SnapshotDirService) SetRecovery(mode string) error {
+	if err := checkRecovery(mode); err != nil {
+		return err
+	}
+
+	d.recovery = mode
+	return nil
+}
+
+func (d *SnapshotDirService) Run() {
+	if d.interval == 0 {
+		return
+	}
+
+	go func() {
+		for {
+			select {
+			case <-d.stopChan:
+				return
+			case <-time.After(d.interval):
+			}
+
+			if err := d.Flush(); err != nil {
+				log.WithFields(log.Fields{
+					"place": "[SnapshotDirService.Run]",
+					"err":   err.Error(),
+				}).Error("Error writing snapshot")
+			}
+		}
+	}()
+}
+
+func (d *SnapshotDirService) Admit(records int) error {
+	return nil
+}
+
+func (d *SnapshotDirService) Write(metric models.Metrics) {
+	d.dirty.Store(true)
+	if d.interval != 0 {
+		return
+	}
+
+	if err := d.Flush(); err != nil {
+		log.WithFields(log.Fields{
+			"place": "[SnapshotDirService.Write]",
+			"err":   err.Error(),
+		}).Error("Error writing snapshot")
+	}
+}
+
+// Flush записывает снимок source, если с прошлого снимка были изменения.
+func (d *SnapshotDirService) Flush() error {
+	if !d.dirty.Swap(false) {
+		return nil
+	}
+
+	snapshot, err := d.source.Snapshot()
+	if err == nil {
+		err = d.Rewrite(snapshot.Metrics)
+	}
+
+	if err != nil {
+		// Снимок не записан, повторим при следующем сбросе.
+		d.dirty.Store(true)
+	}
+
+	return err
+}
+
+// Rewrite записывает metrics новым снимком и удаляет снимки сверх keep.
+func (d *SnapshotDirService) Rewrite(metrics []models.Metrics) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	names, err := d.snapshotFiles()
+	if err != nil {
+		return err
+	}
+
+	// Номер снимка — время записи, но не меньше номера последнего снимка в каталоге,
+	// чтобы новый снимок всегда оказывался последним.
+	stamp := time.Now().UnixNano()
+	if len(names) > 0 {
+		latest, _ := strconv.ParseInt(snapshotFilePattern.FindStringSubmatch(names[len(names)-1])[1], 10, 64)
+		if stamp <= latest {
+			stamp = latest + 1
+		}
+	}
+
+	snapshot := store.Snapshot{Version: store.SnapshotVersion, CreatedAt: time.Now().UTC(), Metrics: metrics}
+	path := filepath.Join(d.dir, fmt.Sprintf("snapshot-%020d.json", stamp))
+	err = writeFileAtomic(path, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(snapshot)
+	})
+	if err != nil {
+		return err
+	}
+
+	names = append(names, filepath.Base(path))
+	for _, name := range names[:max(len(names)-d.keep, 0)] {
+		if err = os.Remove(filepath.Join(d.dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Load читает самый новый снимок, который удаётся разобрать.
+func (d *SnapshotDirService) Load() ([]models.Metrics, LoadReport, error) {
+	var report LoadReport
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	names, err := d.snapshotFiles()
+	if err != nil {
+		return nil, report, err
+	}
+
+	for i := len(names) - 1; i >= 0; i-- {
+		snapshot, err := readSnapshotFile(filepath.Join(d.dir, names[i]))
+		if err == nil {
+			report.Loaded = len(snapshot.Metrics)
+			return snapshot.Metrics, report, nil
+		}
+
+		if d.recovery == RecoveryStrict {
+			return nil, report, fmt.Errorf("%w: %s: %s", ErrCorruptRecord, names[i], err.Error())
+		}
+
+		log.WithFields(log.Fields{
+			"place": "[SnapshotDirService.Load]",
+			"file":  names[i],
+			"err":   err.Error(),
+		}).Warn("Corrupt snapshot skipped")
+
+		report.Skipped++
+	}
+
+	return nil, report, nil
+}
+
+func (d *SnapshotDirService) Close() error {
+	if d.interval != 0 {
+		d.stopChan <- true
+	}
+
+	return d.Flush()
+}
+
+// snapshotFiles возвращает имена снимков от старого к новому.
+func (d *SnapshotDirService) snapshotFiles() ([]string, error) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if snapshotFilePattern.MatchString(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+func readSnapshotFile(path string) (store.Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return store.Snapshot{}, err
+	}
+
+	var snapshot store.Snapshot
+	if err = json.Unmarshal(data, &snapshot); err != nil {
+		return store.Snapshot{}, err
+	}
+
+	if snapshot.Version != store.SnapshotVersion {
+		return store.Snapshot{}, fmt.Errorf("%w: %d", store.ErrSnapshotVersion, snapshot.Version)
+	}
+
+	for _, metric := range snapshot.Metrics {
+		if metric.Op != "" {
+			return store.Snapshot{}, fmt.Errorf("операция %q в снимке: %s", metric.Op, metric.ID)
+		}
+		if err = checkRecord(metric); err != nil {
+			return store.Snapshot{}, err
+		}
+	}
+
+	return snapshot, nil
+}
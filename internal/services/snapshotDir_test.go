@@ -0,0 +1,40 @@
+package services
+
+import (
+	"github.com/Oresst/goMetrics/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotDirService(t *testing.T) {
+	dir := t.TempDir()
+	mem := store.NewMemStorage()
+	persistence, err := NewSnapshotDirService(dir, 0, 2, mem)
+	require.NoError(t, err)
+
+	storage := store.NewPersistentStorage(mem, persistence)
+	for i := int64(1); i <= 4; i++ {
+		require.NoError(t, storage.AddCounter("PollCount", nil, 1))
+	}
+	require.NoError(t, persistence.Close())
+
+	files, err := filepath.Glob(filepath.Join(dir, "snapshot-*.json"))
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+
+	// Повреждённый последний снимок пропускается, восстанавливается предыдущий.
+	require.NoError(t, os.WriteFile(files[1], []byte(`{"version":1,"metrics":[`), 0666))
+
+	data, report, err := persistence.Load()
+	require.NoError(t, err)
+	assert.Equal(t, LoadReport{Loaded: 1, Skipped: 1}, report)
+	require.Len(t, data, 1)
+	assert.Equal(t, int64(3), *data[0].Delta)
+
+	require.NoError(t, persistence.SetRecovery(RecoveryStrict))
+	_, _, err = persistence.Load()
+	assert.ErrorIs(t, err, ErrCorruptRecord)
+}
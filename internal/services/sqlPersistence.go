@@ -0,0 +1,273 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/Oresst/goMetrics/models"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const journalQueryTimeout = 30 * time.Second
+
+const journalSchema = `
+	CREATE TABLE IF NOT EXISTS metrics_journal (
+		id     BIGSERIAL PRIMARY KEY,
+		record JSONB NOT NULL
+	)
+`
+
+// SQLPersistence ведёт журнал изменений в таблице metrics_journal Postgres,
+// по строке на запись. Хранилище при этом остаётся в памяти.
+type SQLPersistence struct {
+	db       *sql.DB
+	interval time.Duration
+	recovery string
+	stopChan chan bool
+
+	// writeMu упорядочивает сбросы буфера и Rewrite, чтобы журнал не получил записи старше снимка.
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	buffer  []models.Metrics
+}
+
+// NewSQLPersistence подключается к dsn и создаёт таблицу журнала. При нулевом duration
+// каждая запись вставляется сразу, иначе записи копятся и вставляются раз в duration одной транзакцией.
+func NewSQLPersistence(dsn string, duration time.Duration) (*SQLPersistence, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), journalQueryTimeout)
+	defer cancel()
+
+	if err = db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if _, err = db.ExecContext(ctx, journalSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create journal schema: %w", err)
+	}
+
+	return &SQLPersistence{
+		db:       db,
+		interval: duration,
+		recovery: RecoverySkip,
+		stopChan: make(chan bool),
+		buffer:   make([]models.Metrics, 0),
+	}, nil
+}
+
+// SetRecovery задаёт режим чтения повреждённых записей; quarantine для таблицы равносилен skip.
+func (s *SQLPersistence) SetRecovery(mode string) error {
+	if err := checkRecovery(mode); err != nil {
+		return err
+	}
+
+	s.recovery = mode
+	return nil
+}
+
+func (s *SQLPersistence) Run() {
+	if s.interval == 0 {
+		return
+	}
+
+	go func() {
+		for {
+			select {
+			case <-s.stopChan:
+				return
+			case <-time.After(s.interval):
+			}
+
+			if err := s.Flush(); err != nil {
+				log.WithFields(log.Fields{
+					"place": "[SQLPersistence.Run]",
+					"err":   err.Error(),
+				}).Error("Error flushing journal")
+			}
+		}
+	}()
+}
+
+func (s *SQLPersistence) Admit(records int) error {
+	return nil
+}
+
+func (s *SQLPersistence) Write(metric models.Metrics) {
+	metric.Timestamp = time.Now().UnixNano()
+
+	s.mu.Lock()
+	s.buffer = append(s.buffer, metric)
+	s.mu.Unlock()
+
+	if s.interval != 0 {
+		return
+	}
+
+	if err := s.Flush(); err != nil {
+		log.WithFields(log.Fields{
+			"place": "[SQLPersistence.Write]",
+			"err":   err.Error(),
+		}).Error("Error writing to journal")
+	}
+}
+
+// Flush вставляет накопленные записи одной транзакцией. При ошибке записи возвращаются в буфер.
+func (s *SQLPersistence) Flush() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.mu.Lock()
+	pending := s.buffer
+	s.buffer = make([]models.Metrics, 0, len(pending))
+	s.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	err := s.inTx(func(ctx context.Context, tx *sql.Tx) error {
+		return insertJournal(ctx, tx, pending)
+	})
+	if err != nil {
+		s.mu.Lock()
+		s.buffer = append(pending, s.buffer...)
+		s.mu.Unlock()
+	}
+
+	return err
+}
+
+// Rewrite заменяет журнал текущим состоянием хранилища, недописанные записи отбрасываются.
+func (s *SQLPersistence) Rewrite(metrics []models.Metrics) error {
+	metrics = stampRecords(metrics, time.Now().UnixNano())
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	err := s.inTx(func(ctx context.Context, tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM metrics_journal"); err != nil {
+			return err
+		}
+
+		return insertJournal(ctx, tx, metrics)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.buffer = make([]models.Metrics, 0)
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Load читает журнал в порядке вставки. Записи, которые не разбираются как метрика,
+// пропускаются или прерывают чтение в режиме strict.
+func (s *SQLPersistence) Load() ([]models.Metrics, LoadReport, error) {
+	var report LoadReport
+
+	ctx, cancel := context.WithTimeout(context.Background(), journalQueryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, "SELECT id, record FROM metrics_journal ORDER BY id")
+	if err != nil {
+		return nil, report, err
+	}
+	defer rows.Close()
+
+	result := make([]models.Metrics, 0)
+	for rows.Next() {
+		var id int64
+		var record []byte
+		if err = rows.Scan(&id, &record); err != nil {
+			return nil, report, err
+		}
+
+		metric, err := decodeJSONRecord(record)
+		if err != nil {
+			if s.recovery == RecoveryStrict {
+				return nil, report, fmt.Errorf("%w: metrics_journal id %d: %s", ErrCorruptRecord, id, err.Error())
+			}
+
+			log.WithFields(log.Fields{
+				"place": "[SQLPersistence.Load]",
+				"id":    id,
+				"err":   err.Error(),
+			}).Warn("Corrupt journal record skipped")
+
+			report.Skipped++
+			continue
+		}
+
+		result = append(result, metric)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, report, err
+	}
+
+	report.Loaded = len(result)
+	return result, report, nil
+}
+
+func (s *SQLPersistence) Close() error {
+	if s.interval != 0 {
+		s.stopChan <- true
+	}
+
+	err := s.Flush()
+	if closeErr := s.db.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+func (s *SQLPersistence) inTx(fn func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), journalQueryTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = fn(ctx, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertJournal(ctx context.Context, tx *sql.Tx, metrics []models.Metrics) error {
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO metrics_journal (record) VALUES ($1)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, metric := range metrics {
+		record, err := json.Marshal(metric)
+		if err != nil {
+			return err
+		}
+
+		if _, err = stmt.ExecContext(ctx, string(record)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,390 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Oresst/goMetrics/internal/store"
+	"github.com/Oresst/goMetrics/models"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// События потока /stream.
+// update — принятое изменение ряда: приращение счётчика, новое значение гауджа или добавленные наблюдения гистограммы.
+// delete и reset — ряд удалён (в том числе по TTL) или обнулён, в событии только тип, имя и метки.
+// restore — хранилище заменено срезом целиком, клиенту нужно перечитать значения.
+const (
+	StreamUpdate  = "update"
+	StreamDelete  = "delete"
+	StreamReset   = "reset"
+	StreamRestore = "restore"
+)
+
+const (
+	DefaultStreamBuffer = 256
+	streamKeepAlive     = 15 * time.Second
+)
+
+type StreamEvent struct {
+	Type   string
+	Metric models.Metrics
+}
+
+// StreamSubscription — подписка на изменения. Done закрывается, когда подписку сняли:
+// клиент не успевал читать Events, отписался сам или хаб закрыт.
+type StreamSubscription struct {
+	Events <-chan StreamEvent
+	Done   <-chan struct{}
+
+	events  chan StreamEvent
+	done    chan struct{}
+	once    sync.Once
+	filter  func(models.Metrics) bool
+	dropped bool
+}
+
+// Dropped сообщает, была ли подписка снята из-за медленного клиента.
+func (s *StreamSubscription) Dropped() bool {
+	select {
+	case <-s.done:
+		return s.dropped
+	default:
+		return false
+	}
+}
+
+func (s *StreamSubscription) close(dropped bool) {
+	s.once.Do(func() {
+		s.dropped = dropped
+		close(s.done)
+	})
+}
+
+// StreamHub раздаёт изменения хранилища подписчикам. Хаб подключается к хранилищу как store.Recorder
+// через store.NewPersistentStorage, поэтому видит все успешно применённые изменения.
+// Запись никогда не ждёт подписчиков: если буфер подписчика полон, подписка снимается.
+type StreamHub struct {
+	buffer      int
+	closed      bool
+	subscribers map[*StreamSubscription]struct{}
+	mu          sync.RWMutex
+}
+
+func NewStreamHub(buffer int) *StreamHub {
+	if buffer <= 0 {
+		buffer = DefaultStreamBuffer
+	}
+
+	return &StreamHub{
+		buffer:      buffer,
+		subscribers: make(map[*StreamSubscription]struct{}),
+	}
+}
+
+// Subscribe подписывает на изменения рядов, для которых filter возвращает true; nil — на все.
+func (h *StreamHub) Subscribe(filter func(models.Metrics) bool) *StreamSubscription {
+	events := make(chan StreamEvent, h.buffer)
+	done := make(chan struct{})
+	subscription := &StreamSubscription{
+		Events: events,
+		Done:   done,
+		events: events,
+		done:   done,
+		filter: filter,
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		subscription.close(false)
+		return subscription
+	}
+
+	h.subscribers[subscription] = struct{}{}
+	return subscription
+}
+
+func (h *StreamHub) Unsubscribe(subscription *StreamSubscription) {
+	h.mu.Lock()
+	delete(h.subscribers, subscription)
+	h.mu.Unlock()
+
+	subscription.close(false)
+}
+
+// Subscribers возвращает число активных подписок.
+func (h *StreamHub) Subscribers() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.subscribers)
+}
+
+// Close снимает все подписки, чтобы открытые потоки завершились при остановке сервера.
+func (h *StreamHub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for subscription := range h.subscribers {
+		subscription.close(false)
+		delete(h.subscribers, subscription)
+	}
+}
+
+func (h *StreamHub) Admit(records int) error {
+	return nil
+}
+
+func (h *StreamHub) Write(metric models.Metrics) {
+	switch metric.Op {
+	case models.OpDelete:
+		h.publish(StreamEvent{Type: StreamDelete, Metric: metric})
+	case models.OpReset:
+		h.publish(StreamEvent{Type: StreamReset, Metric: metric})
+	default:
+		h.publish(StreamEvent{Type: StreamUpdate, Metric: metric})
+	}
+}
+
+// Rewrite вызывается после Restore и отправляет всем подписчикам событие restore.
+func (h *StreamHub) Rewrite(metrics []models.Metrics) error {
+	h.publish(StreamEvent{Type: StreamRestore})
+	return nil
+}
+
+func (h *StreamHub) publish(event StreamEvent) {
+	var slow []*StreamSubscription
+
+	h.mu.RLock()
+	for subscription := range h.subscribers {
+		if event.Type != StreamRestore && subscription.filter != nil && !subscription.filter(event.Metric) {
+			continue
+		}
+
+		select {
+		case subscription.events <- event:
+		default:
+			slow = append(slow, subscription)
+		}
+	}
+	h.mu.RUnlock()
+
+	if len(slow) == 0 {
+		return
+	}
+
+	h.mu.Lock()
+	for _, subscription := range slow {
+		delete(h.subscribers, subscription)
+		subscription.close(true)
+	}
+	h.mu.Unlock()
+
+	log.WithFields(log.Fields{
+		"place":   "[StreamHub.publish]",
+		"dropped": len(slow),
+	}).Warn("Slow stream subscribers dropped")
+}
+
+// SetStreamHub подключает хаб, из которого GET /stream берёт изменения.
+func (m *MetricsService) SetStreamHub(hub *StreamHub) {
+	m.streamHub = hub
+}
+
+// StreamHandler отдаёт изменения хранилища как Server-Sent Events.
+// Фильтры type, prefix, regex и match — как у GET /api/metrics.
+// С параметром throttle (например, 1s) вместо каждого изменения раз в интервал приходит
+// накопленное изменение каждого ряда: приращения счётчиков и наблюдения гистограмм суммируются,
+// у гауджа остаётся последнее значение. Удаление и сброс ряда отправляются сразу, накопленное
+// до них изменение ряда отбрасывается.
+func (m *MetricsService) StreamHandler(w http.ResponseWriter, r *http.Request) {
+	place := "[MetricsService.StreamHandler]"
+
+	if m.streamHub == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	query, err := ParseMetricsQuery(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	var throttle time.Duration
+	if value := r.URL.Query().Get("throttle"); value != "" {
+		throttle, err = time.ParseDuration(value)
+		if err != nil || throttle <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("throttle must be a positive duration"))
+			return
+		}
+	}
+
+	subscription := m.streamHub.Subscribe(func(metric models.Metrics) bool {
+		return matchesQuery(metric, query)
+	})
+	defer m.streamHub.Unsubscribe(subscription)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	stream := &eventStream{w: w, controller: http.NewResponseController(w)}
+	if err = stream.comment("connected"); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	// Без throttle тикер не нужен: канал nil никогда не срабатывает в select.
+	var flushTick <-chan time.Time
+	pending := make(map[store.SeriesKey]models.Metrics)
+	if throttle > 0 {
+		ticker := time.NewTicker(throttle)
+		defer ticker.Stop()
+		flushTick = ticker.C
+	}
+
+	for err == nil {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-subscription.Done:
+			if subscription.Dropped() {
+				stream.send("dropped", []byte(`{"reason":"slow consumer"}`))
+
+				log.WithFields(log.Fields{
+					"place":  place,
+					"remote": r.RemoteAddr,
+				}).Warn("Stream client dropped")
+			}
+			return
+		case <-keepAlive.C:
+			err = stream.comment("ping")
+		case <-flushTick:
+			err = stream.sendPending(pending)
+		case event := <-subscription.Events:
+			switch {
+			case event.Type == StreamRestore:
+				clear(pending)
+				err = stream.sendEvent(event)
+			case event.Type == StreamDelete || event.Type == StreamReset:
+				delete(pending, store.NewSeriesKey(event.Metric.MType, event.Metric.ID, event.Metric.Labels))
+				err = stream.sendEvent(event)
+			case throttle > 0:
+				coalesceUpdate(pending, event.Metric)
+			default:
+				err = stream.sendEvent(event)
+			}
+		}
+	}
+}
+
+// coalesceUpdate добавляет изменение к накопленному изменению ряда. Записи хаба общие
+// для всех подписчиков, поэтому накопленное значение всегда копия.
+func coalesceUpdate(pending map[store.SeriesKey]models.Metrics, metric models.Metrics) {
+	key := store.NewSeriesKey(metric.MType, metric.ID, metric.Labels)
+
+	current, ok := pending[key]
+	if !ok {
+		current = models.Metrics{ID: metric.ID, MType: metric.MType, Labels: metric.Labels}
+	}
+
+	switch metric.MType {
+	case models.Counter:
+		delta := *metric.Delta
+		if current.Delta != nil {
+			delta += *current.Delta
+		}
+		current.Delta = &delta
+	case models.Gauge:
+		value := *metric.Value
+		current.Value = &value
+	case models.Histogram:
+		histogram := metric.Histogram.Copy()
+		if current.Histogram != nil && current.Histogram.SameBuckets(histogram) {
+			histogram = current.Histogram.Copy()
+			histogram.Merge(*metric.Histogram)
+		}
+		current.Histogram = &histogram
+	}
+
+	pending[key] = current
+}
+
+type eventStream struct {
+	w          http.ResponseWriter
+	controller *http.ResponseController
+}
+
+func (s *eventStream) sendEvent(event StreamEvent) error {
+	if event.Type == StreamRestore {
+		return s.send(StreamRestore, []byte("{}"))
+	}
+
+	metric := event.Metric
+	metric.Op = ""
+	data, err := json.Marshal(metric)
+	if err != nil {
+		return err
+	}
+
+	return s.send(event.Type, data)
+}
+
+// sendPending отправляет накопленные изменения в порядке рядов и очищает их.
+func (s *eventStream) sendPending(pending map[store.SeriesKey]models.Metrics) error {
+	keys := make([]store.SeriesKey, 0, len(pending))
+	for key := range pending {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].ID != keys[j].ID {
+			return keys[i].ID < keys[j].ID
+		}
+		return keys[i].MType < keys[j].MType
+	})
+
+	for _, key := range keys {
+		data, err := json.Marshal(pending[key])
+		if err != nil {
+			return err
+		}
+
+		if _, err = fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", StreamUpdate, data); err != nil {
+			return err
+		}
+	}
+	clear(pending)
+
+	if len(keys) == 0 {
+		return nil
+	}
+	return s.controller.Flush()
+}
+
+func (s *eventStream) send(event string, data []byte) error {
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+
+	return s.controller.Flush()
+}
+
+func (s *eventStream) comment(text string) error {
+	if _, err := fmt.Fprintf(s.w, ": %s\n\n", text); err != nil {
+		return err
+	}
+
+	return s.controller.Flush()
+}
@@ -0,0 +1,225 @@
+package services
+
+import (
+	"bufio"
+	"github.com/Oresst/goMetrics/internal/store"
+	"github.com/Oresst/goMetrics/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type sseEvent struct {
+	name string
+	data string
+}
+
+// readSSE читает следующее событие потока, пропуская комментарии.
+func readSSE(t *testing.T, reader *bufio.Reader) sseEvent {
+	t.Helper()
+
+	var event sseEvent
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimRight(line, "\n")
+
+		switch {
+		case line == "" && event.name != "":
+			return event
+		case strings.HasPrefix(line, "event: "):
+			event.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			event.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestStreamHandler(t *testing.T) {
+	hub := NewStreamHub(DefaultStreamBuffer)
+	storage := store.NewPersistentStorage(store.NewMemStorage(), hub)
+	service := NewMetricsService(storage)
+	service.SetStreamHub(hub)
+
+	server := httptest.NewServer(newTestRouter(service))
+	defer server.Close()
+
+	subscribe := func(t *testing.T, query string) (*bufio.Reader, func()) {
+		response, err := http.Get(server.URL + "/stream" + query)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+
+		// Подписка регистрируется до заголовков ответа, поэтому после них изменения уже не теряются.
+		return bufio.NewReader(response.Body), func() { response.Body.Close() }
+	}
+
+	update := func(t *testing.T, path string) {
+		response, err := http.Post(server.URL+path, "text/plain", nil)
+		require.NoError(t, err)
+		response.Body.Close()
+		require.Equal(t, http.StatusOK, response.StatusCode)
+	}
+
+	t.Run("filtered updates", func(t *testing.T) {
+		reader, closeStream := subscribe(t, "?type=gauge&regex="+url.QueryEscape("^Heap"))
+		defer closeStream()
+
+		update(t, "/update/gauge/Alloc/1")
+		update(t, "/update/counter/HeapObjects/1")
+		update(t, "/update/gauge/HeapAlloc/2.5")
+
+		event := readSSE(t, reader)
+		assert.Equal(t, StreamUpdate, event.name)
+		assert.JSONEq(t, `{"id":"HeapAlloc","type":"gauge","value":2.5}`, event.data)
+	})
+
+	t.Run("throttle", func(t *testing.T) {
+		reader, closeStream := subscribe(t, "?throttle=200ms")
+		defer closeStream()
+
+		update(t, "/update/counter/PollCount/1")
+		update(t, "/update/gauge/Alloc/1")
+		update(t, "/update/counter/PollCount/2")
+		update(t, "/update/gauge/Alloc/3")
+
+		first, second := readSSE(t, reader), readSSE(t, reader)
+		assert.JSONEq(t, `{"id":"Alloc","type":"gauge","value":3}`, first.data)
+		assert.JSONEq(t, `{"id":"PollCount","type":"counter","delta":3}`, second.data)
+	})
+
+	t.Run("delete and reset", func(t *testing.T) {
+		reader, closeStream := subscribe(t, "?type=counter&throttle=1h")
+		defer closeStream()
+
+		update(t, "/update/counter/PollCount/1")
+		update(t, "/reset/counter/PollCount")
+
+		request, err := http.NewRequest(http.MethodDelete, server.URL+"/value/counter/PollCount", nil)
+		require.NoError(t, err)
+		response, err := http.DefaultClient.Do(request)
+		require.NoError(t, err)
+		response.Body.Close()
+		require.Equal(t, http.StatusOK, response.StatusCode)
+
+		// Накопленное приращение отбрасывается, сброс и удаление приходят сразу.
+		reset, deleted := readSSE(t, reader), readSSE(t, reader)
+		assert.Equal(t, StreamReset, reset.name)
+		assert.JSONEq(t, `{"id":"PollCount","type":"counter"}`, reset.data)
+		assert.Equal(t, StreamDelete, deleted.name)
+		assert.JSONEq(t, `{"id":"PollCount","type":"counter"}`, deleted.data)
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		reader, closeStream := subscribe(t, "?type=counter")
+		defer closeStream()
+
+		request, err := http.NewRequest(http.MethodPost, server.URL+"/snapshot?mode=overwrite", strings.NewReader(`{"version":1,"metrics":[]}`))
+		require.NoError(t, err)
+		request.Header.Set("Content-Type", "application/json")
+		response, err := http.DefaultClient.Do(request)
+		require.NoError(t, err)
+		response.Body.Close()
+		require.Equal(t, http.StatusOK, response.StatusCode)
+
+		assert.Equal(t, StreamRestore, readSSE(t, reader).name)
+	})
+
+	t.Run("hub closed", func(t *testing.T) {
+		closedHub := NewStreamHub(1)
+		closedService := NewMetricsService(store.NewMemStorage())
+		closedService.SetStreamHub(closedHub)
+		closedServer := httptest.NewServer(newTestRouter(closedService))
+		defer closedServer.Close()
+
+		response, err := http.Get(closedServer.URL + "/stream")
+		require.NoError(t, err)
+		defer response.Body.Close()
+
+		closedHub.Close()
+		_, err = io.ReadAll(response.Body)
+		assert.NoError(t, err)
+	})
+
+	t.Run("bad request", func(t *testing.T) {
+		for _, query := range []string{"?throttle=soon", "?throttle=-1s", "?type=summary", "?regex=("} {
+			response, err := http.Get(server.URL + "/stream" + query)
+			require.NoError(t, err)
+			response.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, response.StatusCode, query)
+		}
+	})
+
+	t.Run("no hub", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/stream", nil)
+		response := httptest.NewRecorder()
+		newTestRouter(NewMetricsService(store.NewMemStorage())).ServeHTTP(response, request)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+}
+
+func TestStreamHubDropsSlowSubscribers(t *testing.T) {
+	hub := NewStreamHub(2)
+	storage := store.NewPersistentStorage(store.NewMemStorage(), hub)
+
+	slow := hub.Subscribe(nil)
+	filtered := hub.Subscribe(func(metric models.Metrics) bool { return metric.MType == models.Gauge })
+	assert.Equal(t, 2, hub.Subscribers())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			require.NoError(t, storage.AddCounter("PollCount", nil, 1))
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ingestion blocked by a slow subscriber")
+	}
+
+	<-slow.Done
+	assert.True(t, slow.Dropped())
+	assert.Len(t, slow.Events, 2)
+
+	assert.False(t, filtered.Dropped())
+	assert.Equal(t, 1, hub.Subscribers())
+
+	require.NoError(t, storage.SetGauge("Alloc", nil, 1))
+	event := <-filtered.Events
+	assert.Equal(t, StreamUpdate, event.Type)
+	assert.Equal(t, "Alloc", event.Metric.ID)
+
+	hub.Unsubscribe(filtered)
+	<-filtered.Done
+	assert.False(t, filtered.Dropped())
+	assert.Equal(t, 0, hub.Subscribers())
+}
+
+func TestStreamExpirySweep(t *testing.T) {
+	hub := NewStreamHub(DefaultStreamBuffer)
+	storage := store.NewHistoryStorage(store.NewPersistentStorage(store.NewMemStorage(), hub), store.HistoryConfig{})
+	require.NoError(t, storage.SetGauge("stale", models.Labels{"host": "a"}, 1))
+	require.NoError(t, storage.AddCounter("PollCount", nil, 1))
+
+	subscription := hub.Subscribe(nil)
+	defer hub.Unsubscribe(subscription)
+
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 1, NewExpiryService(storage, time.Millisecond, time.Minute, true).Sweep())
+
+	require.Len(t, subscription.Events, 1)
+	event := <-subscription.Events
+	assert.Equal(t, StreamDelete, event.Type)
+	assert.Equal(t, models.Gauge, event.Metric.MType)
+	assert.Equal(t, "stale", event.Metric.ID)
+	assert.Equal(t, models.Labels{"host": "a"}, event.Metric.Labels)
+}
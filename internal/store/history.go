@@ -0,0 +1,329 @@
+package store
+
+import (
+	"github.com/Oresst/goMetrics/models"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// HistoryConfig ограничивает историю каждой метрики по возрасту и количеству значений,
+// а MaxSeries — число рядов с историей: для новых рядов сверх него история не ведётся.
+// MaxAge = 0 отключает ограничение по возрасту, MaxSamples = 0 и MaxSeries = 0 заменяются значениями по умолчанию.
+type HistoryConfig struct {
+	MaxAge     time.Duration
+	MaxSamples int
+	MaxSeries  int
+}
+
+const (
+	defaultHistorySamples = 1000
+	defaultHistorySeries  = 10000
+
+	// minHistoryRing — начальная ёмкость буфера ряда, дальше он растёт вдвое до MaxSamples.
+	minHistoryRing = 8
+)
+
+// HistoryReader отдаёт сохранённую историю метрики за интервал [from, to].
+type HistoryReader interface {
+	GetHistory(metricType string, name string, labels models.Labels, from time.Time, to time.Time) ([]models.Sample, error)
+}
+
+// samplesRing — кольцевой буфер значений ёмкостью до limit. Память выделяется по мере роста,
+// чтобы редко обновляемые ряды не занимали место под limit значений.
+type samplesRing struct {
+	samples []models.Sample
+	start   int
+	count   int
+	limit   int
+}
+
+func (r *samplesRing) push(sample models.Sample) {
+	if r.count == len(r.samples) && len(r.samples) < r.limit {
+		r.grow()
+	}
+
+	end := (r.start + r.count) % len(r.samples)
+	r.samples[end] = sample
+
+	if r.count < len(r.samples) {
+		r.count++
+	} else {
+		r.start = (r.start + 1) % len(r.samples)
+	}
+}
+
+func (r *samplesRing) grow() {
+	samples := make([]models.Sample, min(max(2*len(r.samples), minHistoryRing), r.limit))
+	for i := 0; i < r.count; i++ {
+		samples[i] = r.at(i)
+	}
+
+	r.samples = samples
+	r.start = 0
+}
+
+func (r *samplesRing) at(i int) models.Sample {
+	return r.samples[(r.start+i)%len(r.samples)]
+}
+
+// dropBefore удаляет из начала буфера значения старше since.
+func (r *samplesRing) dropBefore(since time.Time) {
+	for r.count > 0 && r.at(0).Timestamp.Before(since) {
+		r.samples[r.start] = models.Sample{}
+		r.start = (r.start + 1) % len(r.samples)
+		r.count--
+	}
+}
+
+// HistoryStorage — обёртка над Store, которая запоминает значения счётчиков и гауджей после каждого изменения.
+// Изменение ряда и запись в историю выполняются под замком ряда, поэтому накопленное значение счётчика
+// читается до того, как его изменит другая горутина, и история ряда не теряет и не повторяет значений.
+type HistoryStorage struct {
+	Store
+
+	config HistoryConfig
+	series map[SeriesKey]*samplesRing
+	now    func() time.Time
+	mu     sync.Mutex
+	locks  seriesLocks
+
+	// full отмечает, что новые ряды не попадают в историю из-за MaxSeries, чтобы предупредить об этом один раз.
+	full bool
+}
+
+func NewHistoryStorage(storage Store, config HistoryConfig) *HistoryStorage {
+	if config.MaxSamples <= 0 {
+		config.MaxSamples = defaultHistorySamples
+	}
+	if config.MaxSeries <= 0 {
+		config.MaxSeries = defaultHistorySeries
+	}
+
+	return &HistoryStorage{
+		Store:  storage,
+		config: config,
+		series: make(map[SeriesKey]*samplesRing),
+		now:    time.Now,
+	}
+}
+
+func (h *HistoryStorage) AddCounter(name string, labels models.Labels, delta int64) error {
+	defer h.locks.lock(NewSeriesKey(models.Counter, name, labels))()
+
+	if err := h.Store.AddCounter(name, labels, delta); err != nil {
+		return err
+	}
+
+	h.recordCounter(name, labels)
+	return nil
+}
+
+func (h *HistoryStorage) SetGauge(name string, labels models.Labels, value float64) error {
+	defer h.locks.lock(NewSeriesKey(models.Gauge, name, labels))()
+
+	if err := h.Store.SetGauge(name, labels, value); err != nil {
+		return err
+	}
+
+	h.record(NewSeriesKey(models.Gauge, name, labels), models.Sample{Value: &value})
+	return nil
+}
+
+func (h *HistoryStorage) AddMetrics(metrics []models.Metrics) error {
+	defer h.locks.lock(metricsKeys(metrics)...)()
+
+	if err := h.Store.AddMetrics(metrics); err != nil {
+		return err
+	}
+
+	for _, metric := range metrics {
+		switch metric.MType {
+		case models.Counter:
+			h.recordCounter(metric.ID, metric.Labels)
+		case models.Gauge:
+			h.record(NewSeriesKey(models.Gauge, metric.ID, metric.Labels), models.Sample{Value: metric.Value})
+		}
+	}
+
+	return nil
+}
+
+func (h *HistoryStorage) RemoveStale(updatedBefore time.Time, keepCounters bool) ([]models.Metrics, error) {
+	defer h.locks.lockAll()()
+
+	removed, err := h.Store.RemoveStale(updatedBefore, keepCounters)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, metric := range removed {
+		delete(h.series, NewSeriesKey(metric.MType, metric.ID, metric.Labels))
+	}
+	h.full = false
+
+	return removed, nil
+}
+
+func (h *HistoryStorage) DeleteMetric(metricType string, name string, labels models.Labels) error {
+	defer h.locks.lock(NewSeriesKey(metricType, name, labels))()
+
+	if err := h.Store.DeleteMetric(metricType, name, labels); err != nil {
+		return err
+	}
+
+	h.forget(NewSeriesKey(metricType, name, labels))
+	return nil
+}
+
+func (h *HistoryStorage) ResetMetric(metricType string, name string, labels models.Labels) error {
+	defer h.locks.lock(NewSeriesKey(metricType, name, labels))()
+
+	if err := h.Store.ResetMetric(metricType, name, labels); err != nil {
+		return err
+	}
+
+	h.recordReset(metricType, name, labels)
+	return nil
+}
+
+func (h *HistoryStorage) DeleteByPrefix(metricType string, prefix string) ([]models.Metrics, error) {
+	defer h.locks.lockAll()()
+
+	deleted, err := h.Store.DeleteByPrefix(metricType, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, metric := range deleted {
+		h.forget(NewSeriesKey(metric.MType, metric.ID, metric.Labels))
+	}
+
+	return deleted, nil
+}
+
+func (h *HistoryStorage) ResetByPrefix(metricType string, prefix string) ([]models.Metrics, error) {
+	defer h.locks.lockAll()()
+
+	reset, err := h.Store.ResetByPrefix(metricType, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, metric := range reset {
+		h.recordReset(metric.MType, metric.ID, metric.Labels)
+	}
+
+	return reset, nil
+}
+
+func (h *HistoryStorage) forget(key SeriesKey) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.series, key)
+	h.full = false
+}
+
+// recordReset добавляет в историю нулевое значение обнулённого счётчика или гауджа.
+func (h *HistoryStorage) recordReset(metricType string, name string, labels models.Labels) {
+	switch metricType {
+	case models.Counter:
+		var delta int64
+		h.record(NewSeriesKey(models.Counter, name, labels), models.Sample{Delta: &delta})
+	case models.Gauge:
+		var value float64
+		h.record(NewSeriesKey(models.Gauge, name, labels), models.Sample{Value: &value})
+	}
+}
+
+// Restore сбрасывает историю: значения после восстановления с ней не согласуются.
+func (h *HistoryStorage) Restore(snapshot Snapshot) error {
+	defer h.locks.lockAll()()
+
+	if err := h.Store.Restore(snapshot); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.series = make(map[SeriesKey]*samplesRing)
+	h.full = false
+	return nil
+}
+
+// recordCounter сохраняет накопленное значение счётчика, а не пришедшую дельту.
+func (h *HistoryStorage) recordCounter(name string, labels models.Labels) {
+	delta, err := h.Store.GetCounter(name, labels)
+	if err != nil {
+		return
+	}
+
+	h.record(NewSeriesKey(models.Counter, name, labels), models.Sample{Delta: &delta})
+}
+
+func (h *HistoryStorage) record(key SeriesKey, sample models.Sample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sample.Timestamp = h.now()
+
+	ring, ok := h.series[key]
+	if !ok {
+		if len(h.series) >= h.config.MaxSeries {
+			if !h.full {
+				h.full = true
+				log.WithFields(log.Fields{
+					"place":      "[HistoryStorage.record]",
+					"max_series": h.config.MaxSeries,
+					"series":     key.ID,
+				}).Warn("History series limit reached, new series are not recorded")
+			}
+			return
+		}
+
+		ring = &samplesRing{limit: h.config.MaxSamples}
+		h.series[key] = ring
+	}
+
+	ring.push(sample)
+	if h.config.MaxAge > 0 {
+		ring.dropBefore(sample.Timestamp.Add(-h.config.MaxAge))
+	}
+}
+
+func (h *HistoryStorage) GetHistory(
+	metricType string,
+	name string,
+	labels models.Labels,
+	from time.Time,
+	to time.Time,
+) ([]models.Sample, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ring, ok := h.series[NewSeriesKey(metricType, name, labels)]
+	if !ok {
+		return nil, ErrMetricNotFound
+	}
+
+	if h.config.MaxAge > 0 {
+		ring.dropBefore(h.now().Add(-h.config.MaxAge))
+	}
+
+	result := make([]models.Sample, 0, ring.count)
+	for i := 0; i < ring.count; i++ {
+		sample := ring.at(i)
+		if sample.Timestamp.Before(from) || sample.Timestamp.After(to) {
+			continue
+		}
+
+		result = append(result, sample)
+	}
+
+	return result, nil
+}
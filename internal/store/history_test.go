@@ -0,0 +1,179 @@
+package store
+
+import (
+	"github.com/Oresst/goMetrics/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestHistoryStorage(config HistoryConfig) (*HistoryStorage, *time.Time) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	storage := NewHistoryStorage(NewMemStorage(), config)
+	storage.now = func() time.Time {
+		return now
+	}
+
+	return storage, &now
+}
+
+func TestHistoryStorageMaxSamples(t *testing.T) {
+	storage, now := newTestHistoryStorage(HistoryConfig{MaxSamples: 3})
+
+	for i := 1; i <= 5; i++ {
+		*now = now.Add(time.Second)
+		require.NoError(t, storage.AddCounter("PollCount", nil, 1))
+	}
+
+	samples, err := storage.GetHistory("counter", "PollCount", nil, time.Time{}, *now)
+	require.NoError(t, err)
+	require.Len(t, samples, 3)
+
+	assert.Equal(t, int64(3), *samples[0].Delta)
+	assert.Equal(t, int64(5), *samples[2].Delta)
+}
+
+func TestHistoryStorageMaxAge(t *testing.T) {
+	storage, now := newTestHistoryStorage(HistoryConfig{MaxAge: time.Minute, MaxSamples: 100})
+
+	require.NoError(t, storage.SetGauge("Alloc", nil, 1))
+	*now = now.Add(50 * time.Second)
+	require.NoError(t, storage.SetGauge("Alloc", nil, 2))
+
+	samples, err := storage.GetHistory("gauge", "Alloc", nil, time.Time{}, *now)
+	require.NoError(t, err)
+	assert.Len(t, samples, 2)
+
+	*now = now.Add(20 * time.Second)
+	samples, err = storage.GetHistory("gauge", "Alloc", nil, time.Time{}, *now)
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+	assert.Equal(t, 2.0, *samples[0].Value)
+}
+
+func TestHistoryStorageRange(t *testing.T) {
+	storage, now := newTestHistoryStorage(HistoryConfig{})
+	start := *now
+
+	for i := 0; i < 10; i++ {
+		*now = start.Add(time.Duration(i) * time.Minute)
+		require.NoError(t, storage.SetGauge("Alloc", nil, float64(i)))
+	}
+
+	samples, err := storage.GetHistory("gauge", "Alloc", nil, start.Add(2*time.Minute), start.Add(4*time.Minute))
+	require.NoError(t, err)
+	require.Len(t, samples, 3)
+	assert.Equal(t, 2.0, *samples[0].Value)
+	assert.Equal(t, 4.0, *samples[2].Value)
+
+	_, err = storage.GetHistory("counter", "Alloc", nil, start, *now)
+	assert.ErrorIs(t, err, ErrMetricNotFound)
+}
+
+func TestHistoryStorageDeleteAndReset(t *testing.T) {
+	storage, now := newTestHistoryStorage(HistoryConfig{})
+
+	require.NoError(t, storage.AddCounter("PollCount", nil, 3))
+	require.NoError(t, storage.SetGauge("Alloc", nil, 2))
+
+	*now = now.Add(time.Second)
+	require.NoError(t, storage.ResetMetric("counter", "PollCount", nil))
+
+	samples, err := storage.GetHistory("counter", "PollCount", nil, time.Time{}, *now)
+	require.NoError(t, err)
+	require.Len(t, samples, 2)
+	assert.Equal(t, int64(0), *samples[1].Delta)
+
+	require.NoError(t, storage.DeleteMetric("gauge", "Alloc", nil))
+	_, err = storage.GetHistory("gauge", "Alloc", nil, time.Time{}, *now)
+	assert.ErrorIs(t, err, ErrMetricNotFound)
+}
+
+// yieldingStore уступает процессор после изменения, чтобы другие горутины успевали вклиниться.
+type yieldingStore struct {
+	Store
+}
+
+func (s yieldingStore) AddCounter(name string, labels models.Labels, delta int64) error {
+	defer runtime.Gosched()
+	return s.Store.AddCounter(name, labels, delta)
+}
+
+func (s yieldingStore) AddMetrics(metrics []models.Metrics) error {
+	defer runtime.Gosched()
+	return s.Store.AddMetrics(metrics)
+}
+
+// Одновременные приращения дают в истории каждое накопленное значение ровно один раз и по порядку.
+func TestHistoryStorageConcurrentCounter(t *testing.T) {
+	storage := NewHistoryStorage(yieldingStore{NewMemStorage()}, HistoryConfig{MaxSamples: 2000})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 50; j++ {
+				assert.NoError(t, storage.AddCounter("PollCount", nil, 1))
+				assert.NoError(t, storage.AddMetrics([]models.Metrics{
+					{ID: "PollCount", MType: models.Counter, Delta: &[]int64{1}[0]},
+				}))
+			}
+		}()
+	}
+	wg.Wait()
+
+	samples, err := storage.GetHistory("counter", "PollCount", nil, time.Time{}, time.Now())
+	require.NoError(t, err)
+	require.Len(t, samples, 2000)
+	for i, sample := range samples {
+		assert.Equal(t, int64(i+1), *sample.Delta)
+	}
+}
+
+func TestHistoryStorageMaxSeries(t *testing.T) {
+	storage, now := newTestHistoryStorage(HistoryConfig{MaxSeries: 2})
+
+	require.NoError(t, storage.SetGauge("a", nil, 1))
+	require.NoError(t, storage.SetGauge("b", nil, 2))
+	require.NoError(t, storage.SetGauge("c", nil, 3))
+
+	_, err := storage.GetHistory("gauge", "c", nil, time.Time{}, *now)
+	assert.ErrorIs(t, err, ErrMetricNotFound)
+
+	value, err := storage.GetGauge("c", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 3.0, value)
+
+	// Удалённый ряд освобождает место.
+	require.NoError(t, storage.DeleteMetric("gauge", "a", nil))
+	require.NoError(t, storage.SetGauge("c", nil, 4))
+
+	samples, err := storage.GetHistory("gauge", "c", nil, time.Time{}, *now)
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+	assert.Equal(t, 4.0, *samples[0].Value)
+}
+
+func TestHistoryStorageRingGrowth(t *testing.T) {
+	storage, now := newTestHistoryStorage(HistoryConfig{MaxSamples: 20, MaxAge: 10 * time.Second})
+
+	for i := 1; i <= 30; i++ {
+		*now = now.Add(time.Second)
+		require.NoError(t, storage.SetGauge("Alloc", nil, float64(i)))
+	}
+
+	ring := storage.series[NewSeriesKey("gauge", "Alloc", nil)]
+	assert.LessOrEqual(t, len(ring.samples), 20)
+
+	samples, err := storage.GetHistory("gauge", "Alloc", nil, time.Time{}, *now)
+	require.NoError(t, err)
+	require.Len(t, samples, 11)
+	for i, sample := range samples {
+		assert.Equal(t, float64(20+i), *sample.Value)
+	}
+}
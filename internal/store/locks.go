@@ -0,0 +1,75 @@
+package store
+
+import (
+	"github.com/Oresst/goMetrics/models"
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+const seriesLockStripes = 64
+
+// seriesLocks упорядочивает изменения одного ряда в обёртках над Store: изменение хранилища
+// и то, что обёртка делает после него, выполняются под одним замком ряда и не перемешиваются
+// с изменениями того же ряда из других горутин. Ряды делят замки по хешу ключа.
+type seriesLocks struct {
+	stripes [seriesLockStripes]sync.Mutex
+}
+
+func (l *seriesLocks) stripe(key SeriesKey) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(key.MType))
+	hash.Write([]byte{0})
+	hash.Write([]byte(key.ID))
+
+	return int(hash.Sum32() % seriesLockStripes)
+}
+
+// lock захватывает замки рядов по возрастанию номера, чтобы пачки не блокировали друг друга,
+// и возвращает функцию, которая их отпускает.
+func (l *seriesLocks) lock(keys ...SeriesKey) func() {
+	stripes := make([]int, 0, len(keys))
+	seen := make(map[int]struct{}, len(keys))
+	for _, key := range keys {
+		stripe := l.stripe(key)
+		if _, ok := seen[stripe]; ok {
+			continue
+		}
+
+		seen[stripe] = struct{}{}
+		stripes = append(stripes, stripe)
+	}
+	sort.Ints(stripes)
+
+	for _, stripe := range stripes {
+		l.stripes[stripe].Lock()
+	}
+
+	return func() {
+		for i := len(stripes) - 1; i >= 0; i-- {
+			l.stripes[stripes[i]].Unlock()
+		}
+	}
+}
+
+// lockAll захватывает замки всех рядов для операций, заранее не знающих затронутых рядов.
+func (l *seriesLocks) lockAll() func() {
+	for i := range l.stripes {
+		l.stripes[i].Lock()
+	}
+
+	return func() {
+		for i := len(l.stripes) - 1; i >= 0; i-- {
+			l.stripes[i].Unlock()
+		}
+	}
+}
+
+func metricsKeys(metrics []models.Metrics) []SeriesKey {
+	keys := make([]SeriesKey, len(metrics))
+	for i, metric := range metrics {
+		keys[i] = NewSeriesKey(metric.MType, metric.ID, metric.Labels)
+	}
+
+	return keys
+}
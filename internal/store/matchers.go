@@ -0,0 +1,144 @@
+package store
+
+import (
+	"fmt"
+	"github.com/Oresst/goMetrics/models"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	MatchEqual     = "="
+	MatchNotEqual  = "!="
+	MatchRegexp    = "=~"
+	MatchNotRegexp = "!~"
+)
+
+// LabelMatcher — условие на одну метку в стиле Prometheus: host="a", env!="prod", svc=~"api.*".
+// Отсутствующая метка считается пустой строкой.
+type LabelMatcher struct {
+	Name  string
+	Op    string
+	Value string
+	re    *regexp.Regexp
+}
+
+func NewLabelMatcher(name string, op string, value string) (LabelMatcher, error) {
+	matcher := LabelMatcher{Name: name, Op: op, Value: value}
+
+	switch op {
+	case MatchEqual, MatchNotEqual:
+	case MatchRegexp, MatchNotRegexp:
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return matcher, err
+		}
+		matcher.re = re
+	default:
+		return matcher, fmt.Errorf("unknown match operator %q", op)
+	}
+
+	return matcher, nil
+}
+
+func (m LabelMatcher) Matches(labels models.Labels) bool {
+	value := labels[m.Name]
+
+	switch m.Op {
+	case MatchEqual:
+		return value == m.Value
+	case MatchNotEqual:
+		return value != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(value)
+	case MatchNotRegexp:
+		return !m.re.MatchString(value)
+	}
+
+	return false
+}
+
+// ParseMatchers разбирает список условий вида host="a",svc=~"api.*", фигурные скобки вокруг необязательны.
+func ParseMatchers(input string) ([]LabelMatcher, error) {
+	input = strings.TrimSpace(input)
+	input = strings.TrimPrefix(input, "{")
+	input = strings.TrimSuffix(input, "}")
+
+	matchers := make([]LabelMatcher, 0)
+	for rest := strings.TrimSpace(input); rest != ""; {
+		opIndex := strings.IndexAny(rest, "=!")
+		if opIndex <= 0 {
+			return nil, fmt.Errorf("invalid matcher %q", rest)
+		}
+
+		name := strings.TrimSpace(rest[:opIndex])
+		rest = rest[opIndex:]
+
+		op := MatchEqual
+		for _, candidate := range []string{MatchRegexp, MatchNotRegexp, MatchNotEqual} {
+			if strings.HasPrefix(rest, candidate) {
+				op = candidate
+				break
+			}
+		}
+		rest = strings.TrimSpace(rest[len(op):])
+
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return nil, fmt.Errorf("value of %q must be quoted", name)
+		}
+
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, err
+		}
+
+		matcher, err := NewLabelMatcher(name, op, value)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, matcher)
+
+		rest = strings.TrimSpace(rest[len(quoted):])
+		if rest != "" {
+			if rest[0] != ',' {
+				return nil, fmt.Errorf("expected ',' after %s", quoted)
+			}
+			rest = strings.TrimSpace(rest[1:])
+		}
+	}
+
+	return matchers, nil
+}
+
+func MatchLabels(labels models.Labels, matchers []LabelMatcher) bool {
+	for _, matcher := range matchers {
+		if !matcher.Matches(labels) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SelectMetrics возвращает ряды хранилища, метки которых удовлетворяют всем условиям.
+func SelectMetrics(storage Store, matchers []LabelMatcher) ([]models.Metrics, error) {
+	metrics, err := storage.GetAllMetrics()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(matchers) == 0 {
+		return metrics, nil
+	}
+
+	result := make([]models.Metrics, 0, len(metrics))
+	for _, metric := range metrics {
+		if MatchLabels(metric.Labels, matchers) {
+			result = append(result, metric)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,45 @@
+package store
+
+import (
+	"github.com/Oresst/goMetrics/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParseMatchers(t *testing.T) {
+	labels := models.Labels{"host": "web-1", "service": "api"}
+
+	testCases := []struct {
+		testName string
+		input    string
+		wantErr  bool
+		matches  bool
+	}{
+		{testName: "empty", input: "", matches: true},
+		{testName: "equal", input: `host="web-1"`, matches: true},
+		{testName: "braces and spaces", input: `{ host = "web-1" , service="api" }`, matches: true},
+		{testName: "not equal", input: `service!="api"`, matches: false},
+		{testName: "regexp", input: `host=~"web-.*"`, matches: true},
+		{testName: "regexp is anchored", input: `host=~"web"`, matches: false},
+		{testName: "not regexp", input: `host!~"db-.*"`, matches: true},
+		{testName: "missing label is empty", input: `env=""`, matches: true},
+		{testName: "unquoted value", input: `host=web-1`, wantErr: true},
+		{testName: "missing name", input: `="web-1"`, wantErr: true},
+		{testName: "bad regexp", input: `host=~"("`, wantErr: true},
+		{testName: "missing comma", input: `host="web-1" service="api"`, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			matchers, err := ParseMatchers(tc.input)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.matches, MatchLabels(labels, matchers))
+		})
+	}
+}
@@ -1,72 +1,562 @@
 package store
 
 import (
-	"errors"
+	"fmt"
 	"github.com/Oresst/goMetrics/models"
-	"github.com/google/uuid"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
+const shardsCount = 32
+
+type memSeries struct {
+	name      string
+	labels    models.Labels
+	delta     int64
+	value     float64
+	histogram models.HistogramData
+	updatedAt time.Time
+}
+
+func (s *memSeries) metric(metricType string) models.Metrics {
+	metric := models.Metrics{
+		ID:     s.name,
+		MType:  metricType,
+		Labels: s.labels.Copy(),
+	}
+
+	switch metricType {
+	case models.Counter:
+		delta := s.delta
+		metric.Delta = &delta
+	case models.Gauge:
+		value := s.value
+		metric.Value = &value
+	case models.Histogram:
+		histogram := s.histogram.Copy()
+		metric.Histogram = &histogram
+	}
+
+	return metric
+}
+
+// reset обнуляет значение ряда, границы корзин гистограммы сохраняются.
+func (s *memSeries) reset(now time.Time) {
+	s.delta = 0
+	s.value = 0
+	if s.histogram.Buckets != nil {
+		s.histogram = models.NewHistogramData(s.histogram.Buckets)
+	}
+	s.updatedAt = now
+}
+
+// stamped возвращает метрику ряда со временем последнего обновления в Timestamp, как её кладёт Snapshot.
+func (s *memSeries) stamped(metricType string) models.Metrics {
+	metric := s.metric(metricType)
+	metric.Timestamp = s.updatedAt.UnixNano()
+	return metric
+}
+
+func (s *memSeries) info(metricType string) Series {
+	return Series{Metrics: s.metric(metricType), UpdatedAt: s.updatedAt}
+}
+
+// memShard хранит часть рядов; все ряды с одним именем всегда попадают в один шард.
+// Ключ карт — models.SeriesID.
+type memShard struct {
+	counters   map[string]*memSeries
+	gauges     map[string]*memSeries
+	histograms map[string]*memSeries
+	sync.RWMutex
+}
+
+func (s *memShard) byType(metricType string) map[string]*memSeries {
+	switch metricType {
+	case models.Counter:
+		return s.counters
+	case models.Gauge:
+		return s.gauges
+	default:
+		return s.histograms
+	}
+}
+
+// lookupError различает отсутствующий ряд и ряд, записанный с другим типом.
+func (s *memShard) lookupError(id string, metricType string) error {
+	for _, other := range []string{models.Counter, models.Gauge, models.Histogram} {
+		if other == metricType {
+			continue
+		}
+
+		if _, ok := s.byType(other)[id]; ok {
+			return fmt.Errorf("%w: %s is %s", ErrTypeMismatch, id, other)
+		}
+	}
+
+	return ErrMetricNotFound
+}
+
+func (s *memShard) series(series map[string]*memSeries, name string, labels models.Labels) *memSeries {
+	id := models.SeriesID(name, labels)
+
+	item, ok := series[id]
+	if !ok {
+		item = &memSeries{
+			name:   name,
+			labels: labels.Copy(),
+		}
+		series[id] = item
+	}
+
+	return item
+}
+
+// MemStorage раскладывает метрики по шардам по хешу имени,
+// поэтому запись и чтение разных метрик не блокируют друг друга.
 type MemStorage struct {
-	metrics map[string]models.Metrics
-	sync.Mutex
+	shards [shardsCount]*memShard
+	now    func() time.Time
 }
 
 func NewMemStorage() *MemStorage {
-	return &MemStorage{
-		metrics: make(map[string]models.Metrics),
+	m := &MemStorage{now: time.Now}
+	for i := range m.shards {
+		m.shards[i] = &memShard{
+			counters:   make(map[string]*memSeries),
+			gauges:     make(map[string]*memSeries),
+			histograms: make(map[string]*memSeries),
+		}
 	}
+
+	return m
 }
 
-func (m *MemStorage) AddMetric(metricType string, name string, value float64) error {
-	m.Lock()
-	defer m.Unlock()
+// shardIndex считает FNV-1a от имени без аллокаций.
+func shardIndex(name string) int {
+	const (
+		offset32 = 2166136261
+		prime32  = 16777619
+	)
 
-	uuid4 := uuid.New()
+	hash := uint32(offset32)
+	for i := 0; i < len(name); i++ {
+		hash ^= uint32(name[i])
+		hash *= prime32
+	}
 
-	metric, ok := m.metrics[name]
-	if !ok {
-		metric = models.Metrics{
-			ID:    uuid4.String(),
-			MType: metricType,
-			Value: &value,
+	return int(hash % shardsCount)
+}
+
+func (m *MemStorage) shard(name string) *memShard {
+	return m.shards[shardIndex(name)]
+}
+
+func (m *MemStorage) AddCounter(name string, labels models.Labels, delta int64) error {
+	shard := m.shard(name)
+	shard.Lock()
+	defer shard.Unlock()
+
+	series := shard.series(shard.counters, name, labels)
+	series.delta += delta
+	series.updatedAt = m.now()
+	return nil
+}
+
+func (m *MemStorage) SetGauge(name string, labels models.Labels, value float64) error {
+	shard := m.shard(name)
+	shard.Lock()
+	defer shard.Unlock()
+
+	series := shard.series(shard.gauges, name, labels)
+	series.value = value
+	series.updatedAt = m.now()
+	return nil
+}
+
+func (m *MemStorage) AddHistogram(name string, labels models.Labels, histogram models.HistogramData) error {
+	if err := histogram.Validate(); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidMetric, err.Error())
+	}
+
+	shard := m.shard(name)
+	shard.Lock()
+	defer shard.Unlock()
+
+	return m.addHistogram(shard, name, labels, histogram, m.now())
+}
+
+func (m *MemStorage) addHistogram(
+	shard *memShard,
+	name string,
+	labels models.Labels,
+	histogram models.HistogramData,
+	now time.Time,
+) error {
+	series := shard.series(shard.histograms, name, labels)
+	if series.histogram.Buckets == nil {
+		series.histogram = models.NewHistogramData(histogram.Buckets)
+	}
+
+	if err := series.histogram.Merge(histogram); err != nil {
+		return err
+	}
+
+	series.updatedAt = now
+	return nil
+}
+
+// AddMetrics блокирует все затронутые шарды в порядке возрастания индекса,
+// чтобы пачка применилась целиком и без взаимных блокировок.
+func (m *MemStorage) AddMetrics(metrics []models.Metrics) error {
+	if err := validateMetrics(metrics); err != nil {
+		return err
+	}
+
+	var locked [shardsCount]bool
+	for _, metric := range metrics {
+		locked[shardIndex(metric.ID)] = true
+	}
+
+	for i, ok := range locked {
+		if ok {
+			m.shards[i].Lock()
+			defer m.shards[i].Unlock()
 		}
+	}
 
-		m.metrics[name] = metric
-		return nil
+	// Гистограммы с другими границами корзин проверяются до изменений, чтобы пачка не применилась частично.
+	buckets := make(map[string]models.HistogramData)
+	for _, metric := range metrics {
+		if metric.MType != models.Histogram {
+			continue
+		}
+
+		id := models.SeriesID(metric.ID, metric.Labels)
+		current, ok := buckets[id]
+		if !ok {
+			var series *memSeries
+			if series, ok = m.shard(metric.ID).histograms[id]; ok {
+				current = series.histogram
+			}
+		}
+
+		if ok && !current.SameBuckets(*metric.Histogram) {
+			return fmt.Errorf("%w: %s", models.ErrBucketsMismatch, id)
+		}
+		buckets[id] = *metric.Histogram
 	}
 
-	if metric.MType == models.Counter {
-		*metric.Value += value
-	} else if metric.MType == models.Gauge {
-		*metric.Value = value
-	} else {
-		return errors.New("unknown metric type")
+	now := m.now()
+	for _, metric := range metrics {
+		shard := m.shard(metric.ID)
+		switch metric.MType {
+		case models.Counter:
+			series := shard.series(shard.counters, metric.ID, metric.Labels)
+			series.delta += *metric.Delta
+			series.updatedAt = now
+		case models.Gauge:
+			series := shard.series(shard.gauges, metric.ID, metric.Labels)
+			series.value = *metric.Value
+			series.updatedAt = now
+		case models.Histogram:
+			if err := m.addHistogram(shard, metric.ID, metric.Labels, *metric.Histogram, now); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
 }
 
-func (m *MemStorage) GetMetric(name string) (float64, error) {
-	m.Lock()
-	defer m.Unlock()
+func (m *MemStorage) GetCounter(name string, labels models.Labels) (int64, error) {
+	shard := m.shard(name)
+	shard.RLock()
+	defer shard.RUnlock()
+
+	id := models.SeriesID(name, labels)
+	series, ok := shard.counters[id]
+	if !ok {
+		return 0, shard.lookupError(id, models.Counter)
+	}
+
+	return series.delta, nil
+}
+
+func (m *MemStorage) GetGauge(name string, labels models.Labels) (float64, error) {
+	shard := m.shard(name)
+	shard.RLock()
+	defer shard.RUnlock()
+
+	id := models.SeriesID(name, labels)
+	series, ok := shard.gauges[id]
+	if !ok {
+		return 0, shard.lookupError(id, models.Gauge)
+	}
+
+	return series.value, nil
+}
+
+func (m *MemStorage) GetHistogram(name string, labels models.Labels) (models.HistogramData, error) {
+	shard := m.shard(name)
+	shard.RLock()
+	defer shard.RUnlock()
 
-	metric, ok := m.metrics[name]
+	id := models.SeriesID(name, labels)
+	series, ok := shard.histograms[id]
 	if !ok {
-		return 0, errors.New("metric not found")
+		return models.HistogramData{}, shard.lookupError(id, models.Histogram)
+	}
+
+	return series.histogram.Copy(), nil
+}
+
+// GetAllMetrics копирует шарды по очереди и держит блокировку только одного из них.
+func (m *MemStorage) GetAllMetrics() ([]models.Metrics, error) {
+	size := 0
+	for _, shard := range m.shards {
+		shard.RLock()
+		size += len(shard.counters) + len(shard.gauges) + len(shard.histograms)
+		shard.RUnlock()
+	}
+
+	result := make([]models.Metrics, 0, size)
+
+	for _, shard := range m.shards {
+		shard.RLock()
+		for _, series := range shard.counters {
+			result = append(result, series.metric(models.Counter))
+		}
+
+		for _, series := range shard.gauges {
+			result = append(result, series.metric(models.Gauge))
+		}
+
+		for _, series := range shard.histograms {
+			result = append(result, series.metric(models.Histogram))
+		}
+		shard.RUnlock()
+	}
+
+	sortMetrics(result)
+	return result, nil
+}
+
+func (m *MemStorage) GetAllSeries() ([]Series, error) {
+	result := make([]Series, 0)
+
+	for _, shard := range m.shards {
+		shard.RLock()
+		for _, series := range shard.counters {
+			result = append(result, series.info(models.Counter))
+		}
+
+		for _, series := range shard.gauges {
+			result = append(result, series.info(models.Gauge))
+		}
+
+		for _, series := range shard.histograms {
+			result = append(result, series.info(models.Histogram))
+		}
+		shard.RUnlock()
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return metricLess(result[i].Metrics, result[j].Metrics)
+	})
+	return result, nil
+}
+
+func (m *MemStorage) RemoveStale(updatedBefore time.Time, keepCounters bool) ([]models.Metrics, error) {
+	removed := make([]models.Metrics, 0)
+
+	for _, shard := range m.shards {
+		shard.Lock()
+		if !keepCounters {
+			removed = removeStaleSeries(removed, shard.counters, models.Counter, updatedBefore)
+		}
+		removed = removeStaleSeries(removed, shard.gauges, models.Gauge, updatedBefore)
+		removed = removeStaleSeries(removed, shard.histograms, models.Histogram, updatedBefore)
+		shard.Unlock()
+	}
+
+	sortMetrics(removed)
+	return removed, nil
+}
+
+func (m *MemStorage) DeleteMetric(metricType string, name string, labels models.Labels) error {
+	return m.updateSeries(metricType, name, labels, func(series map[string]*memSeries, id string) {
+		delete(series, id)
+	})
+}
+
+func (m *MemStorage) ResetMetric(metricType string, name string, labels models.Labels) error {
+	now := m.now()
+	return m.updateSeries(metricType, name, labels, func(series map[string]*memSeries, id string) {
+		series[id].reset(now)
+	})
+}
+
+func (m *MemStorage) updateSeries(
+	metricType string,
+	name string,
+	labels models.Labels,
+	update func(series map[string]*memSeries, id string),
+) error {
+	if err := checkType(metricType); err != nil {
+		return err
+	}
+
+	shard := m.shard(name)
+	shard.Lock()
+	defer shard.Unlock()
+
+	id := models.SeriesID(name, labels)
+	series := shard.byType(metricType)
+	if _, ok := series[id]; !ok {
+		return shard.lookupError(id, metricType)
+	}
+
+	update(series, id)
+	return nil
+}
+
+// DeleteByPrefix, как RemoveStale, обходит шарды по очереди: ряды с подходящим именем
+// могут лежать в любом шарде.
+func (m *MemStorage) DeleteByPrefix(metricType string, prefix string) ([]models.Metrics, error) {
+	return m.updateByPrefix(metricType, prefix, func(series map[string]*memSeries, id string) {
+		delete(series, id)
+	})
+}
+
+func (m *MemStorage) ResetByPrefix(metricType string, prefix string) ([]models.Metrics, error) {
+	now := m.now()
+	return m.updateByPrefix(metricType, prefix, func(series map[string]*memSeries, id string) {
+		series[id].reset(now)
+	})
+}
+
+func (m *MemStorage) updateByPrefix(
+	metricType string,
+	prefix string,
+	update func(series map[string]*memSeries, id string),
+) ([]models.Metrics, error) {
+	if err := checkType(metricType); err != nil {
+		return nil, err
+	}
+
+	affected := make([]models.Metrics, 0)
+	for _, shard := range m.shards {
+		shard.Lock()
+		series := shard.byType(metricType)
+		for id, item := range series {
+			if strings.HasPrefix(item.name, prefix) {
+				affected = append(affected, item.metric(metricType))
+				update(series, id)
+			}
+		}
+		shard.Unlock()
+	}
+
+	sortMetrics(affected)
+	return affected, nil
+}
+
+// Snapshot держит блокировки всех шардов сразу, чтобы срез был согласованным.
+func (m *MemStorage) Snapshot() (Snapshot, error) {
+	for _, shard := range m.shards {
+		shard.RLock()
+		defer shard.RUnlock()
+	}
+
+	result := make([]models.Metrics, 0)
+	for _, shard := range m.shards {
+		for _, series := range shard.counters {
+			result = append(result, series.stamped(models.Counter))
+		}
+
+		for _, series := range shard.gauges {
+			result = append(result, series.stamped(models.Gauge))
+		}
+
+		for _, series := range shard.histograms {
+			result = append(result, series.stamped(models.Histogram))
+		}
 	}
 
-	return *metric.Value, nil
+	return newSnapshot(result), nil
 }
 
-func (m *MemStorage) GetAllMetrics() map[string]models.Metrics {
-	m.Lock()
-	defer m.Unlock()
+// Restore собирает новые шарды заранее и подменяет их под блокировками всех шардов.
+func (m *MemStorage) Restore(snapshot Snapshot) error {
+	if err := validateSnapshot(snapshot); err != nil {
+		return err
+	}
+
+	var restored [shardsCount]memShard
+	for i := range restored {
+		restored[i].counters = make(map[string]*memSeries)
+		restored[i].gauges = make(map[string]*memSeries)
+		restored[i].histograms = make(map[string]*memSeries)
+	}
+
+	now := m.now()
+	for _, metric := range snapshot.Metrics {
+		shard := &restored[shardIndex(metric.ID)]
+		series := shard.series(shard.byType(metric.MType), metric.ID, metric.Labels)
+		series.updatedAt = restoredAt(metric, now)
 
-	copiedMetrics := make(map[string]models.Metrics)
-	for key, metric := range m.metrics {
-		copiedMetrics[key] = metric
+		switch metric.MType {
+		case models.Counter:
+			series.delta = *metric.Delta
+		case models.Gauge:
+			series.value = *metric.Value
+		case models.Histogram:
+			series.histogram = metric.Histogram.Copy()
+		}
 	}
 
-	return copiedMetrics
+	for _, shard := range m.shards {
+		shard.Lock()
+		defer shard.Unlock()
+	}
+
+	for i, shard := range m.shards {
+		shard.counters = restored[i].counters
+		shard.gauges = restored[i].gauges
+		shard.histograms = restored[i].histograms
+	}
+
+	return nil
+}
+
+func removeStaleSeries(
+	removed []models.Metrics,
+	series map[string]*memSeries,
+	metricType string,
+	updatedBefore time.Time,
+) []models.Metrics {
+	for id, item := range series {
+		if item.updatedAt.Before(updatedBefore) {
+			removed = append(removed, item.metric(metricType))
+			delete(series, id)
+		}
+	}
+
+	return removed
+}
+
+func sortMetrics(metrics []models.Metrics) {
+	sort.Slice(metrics, func(i, j int) bool {
+		return metricLess(metrics[i], metrics[j])
+	})
+}
+
+// metricLess упорядочивает ряды по типу, затем по имени с метками.
+func metricLess(a models.Metrics, b models.Metrics) bool {
+	if a.MType != b.MType {
+		return a.MType < b.MType
+	}
+	return models.SeriesID(a.ID, a.Labels) < models.SeriesID(b.ID, b.Labels)
 }
@@ -0,0 +1,293 @@
+package store
+
+import (
+	"fmt"
+	"github.com/Oresst/goMetrics/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// singleMutexStorage повторяет MemStorage до шардирования
+// и служит базой для сравнения в бенчмарках.
+type singleMutexStorage struct {
+	counters map[string]int64
+	gauges   map[string]float64
+	sync.Mutex
+}
+
+func newSingleMutexStorage() *singleMutexStorage {
+	return &singleMutexStorage{
+		counters: make(map[string]int64),
+		gauges:   make(map[string]float64),
+	}
+}
+
+func (s *singleMutexStorage) AddCounter(name string, labels models.Labels, delta int64) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.counters[models.SeriesID(name, labels)] += delta
+	return nil
+}
+
+func (s *singleMutexStorage) SetGauge(name string, labels models.Labels, value float64) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.gauges[models.SeriesID(name, labels)] = value
+	return nil
+}
+
+func (s *singleMutexStorage) GetGauge(name string, labels models.Labels) (float64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	value, ok := s.gauges[models.SeriesID(name, labels)]
+	if !ok {
+		return 0, ErrMetricNotFound
+	}
+
+	return value, nil
+}
+
+func (s *singleMutexStorage) GetAllMetrics() ([]models.Metrics, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	result := make([]models.Metrics, 0, len(s.counters)+len(s.gauges))
+	for name, delta := range s.counters {
+		result = append(result, models.Metrics{ID: name, MType: models.Counter, Delta: &delta})
+	}
+
+	for name, value := range s.gauges {
+		result = append(result, models.Metrics{ID: name, MType: models.Gauge, Value: &value})
+	}
+
+	sortMetrics(result)
+	return result, nil
+}
+
+type benchStorage interface {
+	AddCounter(name string, labels models.Labels, delta int64) error
+	SetGauge(name string, labels models.Labels, value float64) error
+	GetGauge(name string, labels models.Labels) (float64, error)
+	GetAllMetrics() ([]models.Metrics, error)
+}
+
+var benchNames = func() []string {
+	names := make([]string, 256)
+	for i := range names {
+		names[i] = fmt.Sprintf("metric%d", i)
+	}
+	return names
+}()
+
+// runParallelLoad имитирует множество агентов: в основном запись,
+// часть чтений и редкий полный список метрик.
+func runParallelLoad(b *testing.B, storage benchStorage) {
+	var seq atomic.Uint64
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := seq.Add(1)
+			name := benchNames[i%uint64(len(benchNames))]
+
+			switch {
+			case i%1000 == 0:
+				storage.GetAllMetrics()
+			case i%4 == 0:
+				storage.GetGauge(name, nil)
+			case i%2 == 0:
+				storage.AddCounter(name, nil, 1)
+			default:
+				storage.SetGauge(name, nil, float64(i))
+			}
+		}
+	})
+}
+
+func BenchmarkStorageParallel(b *testing.B) {
+	b.Run("sharded", func(b *testing.B) {
+		runParallelLoad(b, NewMemStorage())
+	})
+
+	b.Run("single-mutex", func(b *testing.B) {
+		runParallelLoad(b, newSingleMutexStorage())
+	})
+}
+
+func TestMemStorageConcurrentUpdates(t *testing.T) {
+	storage := NewMemStorage()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			name := benchNames[i%len(benchNames)]
+			assert.NoError(t, storage.AddCounter("PollCount", nil, 1))
+			assert.NoError(t, storage.SetGauge(name, nil, float64(i)))
+			assert.NoError(t, storage.AddMetrics([]models.Metrics{
+				{ID: "PollCount", MType: models.Counter, Delta: &[]int64{1}[0]},
+				{ID: name, MType: models.Counter, Delta: &[]int64{1}[0]},
+			}))
+			_, err := storage.GetAllMetrics()
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	delta, err := storage.GetCounter("PollCount", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(200), delta)
+
+	metrics, err := storage.GetAllMetrics()
+	require.NoError(t, err)
+	assert.Len(t, metrics, 201)
+}
+
+func TestMemStorageRemoveStale(t *testing.T) {
+	storage := NewMemStorage()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	storage.now = func() time.Time {
+		return now
+	}
+
+	require.NoError(t, storage.AddCounter("oldCounter", nil, 1))
+	require.NoError(t, storage.SetGauge("oldGauge", nil, 1))
+
+	now = now.Add(time.Hour)
+	require.NoError(t, storage.SetGauge("freshGauge", nil, 2))
+
+	removed, err := storage.RemoveStale(now.Add(-time.Minute), true)
+	require.NoError(t, err)
+	require.Len(t, removed, 1)
+	assert.Equal(t, "oldGauge", removed[0].ID)
+
+	_, err = storage.GetCounter("oldCounter", nil)
+	assert.NoError(t, err)
+
+	removed, err = storage.RemoveStale(now.Add(-time.Minute), false)
+	require.NoError(t, err)
+	require.Len(t, removed, 1)
+	assert.Equal(t, "oldCounter", removed[0].ID)
+	assert.Equal(t, int64(1), *removed[0].Delta)
+
+	metrics, err := storage.GetAllMetrics()
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+	assert.Equal(t, "freshGauge", metrics[0].ID)
+}
+
+// После Restore TTL отсчитывается от времени обновления ряда из среза, а не от момента загрузки.
+func TestMemStorageRestoreKeepsUpdateTime(t *testing.T) {
+	storage := NewMemStorage()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	storage.now = func() time.Time {
+		return now
+	}
+
+	snapshot := newSnapshot([]models.Metrics{
+		{ID: "old", MType: models.Gauge, Value: &[]float64{1}[0], Timestamp: now.Add(-2 * time.Hour).UnixNano()},
+		{ID: "recent", MType: models.Gauge, Value: &[]float64{2}[0], Timestamp: now.Add(-time.Minute).UnixNano()},
+		{ID: "unstamped", MType: models.Gauge, Value: &[]float64{3}[0]},
+	})
+	require.NoError(t, storage.Restore(snapshot))
+
+	removed, err := storage.RemoveStale(now.Add(-time.Hour), true)
+	require.NoError(t, err)
+	require.Len(t, removed, 1)
+	assert.Equal(t, "old", removed[0].ID)
+
+	series, err := storage.GetAllSeries()
+	require.NoError(t, err)
+	updated := make(map[string]int64, len(series))
+	for _, item := range series {
+		updated[item.ID] = item.UpdatedAt.UnixNano()
+	}
+	assert.Equal(t, map[string]int64{
+		"recent":    now.Add(-time.Minute).UnixNano(),
+		"unstamped": now.UnixNano(),
+	}, updated)
+}
+
+func TestMemStorageGetAllSeries(t *testing.T) {
+	storage := NewMemStorage()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	storage.now = func() time.Time {
+		return now
+	}
+
+	require.NoError(t, storage.AddCounter("PollCount", nil, 1))
+	now = now.Add(time.Minute)
+	require.NoError(t, storage.SetGauge("Alloc", models.Labels{"host": "a"}, 2))
+
+	series, err := storage.GetAllSeries()
+	require.NoError(t, err)
+	require.Len(t, series, 2)
+
+	assert.Equal(t, "PollCount", series[0].ID)
+	assert.Equal(t, now.Add(-time.Minute), series[0].UpdatedAt)
+	assert.Equal(t, "Alloc", series[1].ID)
+	assert.Equal(t, models.Labels{"host": "a"}, series[1].Labels)
+	assert.Equal(t, now, series[1].UpdatedAt)
+}
+
+func TestMemStorageDeleteAndReset(t *testing.T) {
+	storage := NewMemStorage()
+
+	h := models.NewHistogramData([]float64{1, 5})
+	h.Observe(0.5)
+
+	require.NoError(t, storage.AddCounter("PollCount", nil, 3))
+	require.NoError(t, storage.AddCounter("PollCount", models.Labels{"host": "a"}, 2))
+	require.NoError(t, storage.SetGauge("HeapAlloc", nil, 1.5))
+	require.NoError(t, storage.SetGauge("HeapInuse", nil, 2.5))
+	require.NoError(t, storage.SetGauge("Alloc", nil, 3))
+	require.NoError(t, storage.AddHistogram("latency", nil, h))
+
+	require.NoError(t, storage.DeleteMetric(models.Counter, "PollCount", models.Labels{"host": "a"}))
+	_, err := storage.GetCounter("PollCount", models.Labels{"host": "a"})
+	assert.ErrorIs(t, err, ErrMetricNotFound)
+	assert.ErrorIs(t, storage.DeleteMetric(models.Counter, "PollCount", models.Labels{"host": "a"}), ErrMetricNotFound)
+	assert.ErrorIs(t, storage.DeleteMetric(models.Gauge, "PollCount", nil), ErrTypeMismatch)
+	assert.ErrorIs(t, storage.DeleteMetric("summary", "PollCount", nil), ErrInvalidMetric)
+
+	require.NoError(t, storage.ResetMetric(models.Counter, "PollCount", nil))
+	delta, err := storage.GetCounter("PollCount", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), delta)
+
+	require.NoError(t, storage.ResetMetric(models.Histogram, "latency", nil))
+	histogram, err := storage.GetHistogram("latency", nil)
+	require.NoError(t, err)
+	assert.Equal(t, models.NewHistogramData([]float64{1, 5}), histogram)
+
+	deleted, err := storage.DeleteByPrefix(models.Gauge, "Heap")
+	require.NoError(t, err)
+	require.Len(t, deleted, 2)
+	assert.Equal(t, "HeapAlloc", deleted[0].ID)
+	assert.Equal(t, 1.5, *deleted[0].Value)
+	assert.Equal(t, "HeapInuse", deleted[1].ID)
+
+	reset, err := storage.ResetByPrefix(models.Gauge, "")
+	require.NoError(t, err)
+	require.Len(t, reset, 1)
+	assert.Equal(t, 3.0, *reset[0].Value)
+
+	value, err := storage.GetGauge("Alloc", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, value)
+
+	metrics, err := storage.GetAllMetrics()
+	require.NoError(t, err)
+	assert.Len(t, metrics, 3)
+}
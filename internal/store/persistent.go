@@ -0,0 +1,206 @@
+package store
+
+import (
+	"errors"
+	"github.com/Oresst/goMetrics/models"
+	"time"
+)
+
+var ErrRecorderFull = errors.New("persistence queue is full")
+
+// Recorder получает каждое успешно применённое изменение хранилища.
+// Admit вызывается до изменения и может отказать с ErrRecorderFull,
+// тогда хранилище не меняется и запись не попадает в журнал.
+// Rewrite заменяет весь журнал состоянием после Restore.
+type Recorder interface {
+	Admit(records int) error
+	Write(metric models.Metrics)
+	Rewrite(metrics []models.Metrics) error
+}
+
+// PersistentStorage передаёт в Recorder все изменения, откуда бы они ни пришли:
+// URL, JSON, пачки и любые будущие способы записи.
+// Счётчики записываются приращениями, поэтому журнал воспроизводится через ReplayLog.
+// Удаление и сброс записываются записями с Op без значения, по записи на каждый затронутый ряд.
+// Изменение ряда и его запись в Recorder выполняются под замком ряда, поэтому записи одного ряда
+// попадают в журнал в том же порядке, в каком применялись к хранилищу.
+type PersistentStorage struct {
+	Store
+
+	recorder Recorder
+	locks    seriesLocks
+}
+
+func NewPersistentStorage(storage Store, recorder Recorder) *PersistentStorage {
+	return &PersistentStorage{
+		Store:    storage,
+		recorder: recorder,
+	}
+}
+
+func (p *PersistentStorage) AddCounter(name string, labels models.Labels, delta int64) error {
+	if err := p.recorder.Admit(1); err != nil {
+		return err
+	}
+
+	defer p.locks.lock(NewSeriesKey(models.Counter, name, labels))()
+
+	if err := p.Store.AddCounter(name, labels, delta); err != nil {
+		return err
+	}
+
+	p.recorder.Write(models.Metrics{ID: name, MType: models.Counter, Labels: labels.Copy(), Delta: &delta})
+	return nil
+}
+
+func (p *PersistentStorage) SetGauge(name string, labels models.Labels, value float64) error {
+	if err := p.recorder.Admit(1); err != nil {
+		return err
+	}
+
+	defer p.locks.lock(NewSeriesKey(models.Gauge, name, labels))()
+
+	if err := p.Store.SetGauge(name, labels, value); err != nil {
+		return err
+	}
+
+	p.recorder.Write(models.Metrics{ID: name, MType: models.Gauge, Labels: labels.Copy(), Value: &value})
+	return nil
+}
+
+func (p *PersistentStorage) AddHistogram(name string, labels models.Labels, histogram models.HistogramData) error {
+	if err := p.recorder.Admit(1); err != nil {
+		return err
+	}
+
+	defer p.locks.lock(NewSeriesKey(models.Histogram, name, labels))()
+
+	if err := p.Store.AddHistogram(name, labels, histogram); err != nil {
+		return err
+	}
+
+	histogram = histogram.Copy()
+	p.recorder.Write(models.Metrics{ID: name, MType: models.Histogram, Labels: labels.Copy(), Histogram: &histogram})
+	return nil
+}
+
+func (p *PersistentStorage) AddMetrics(metrics []models.Metrics) error {
+	if err := p.recorder.Admit(len(metrics)); err != nil {
+		return err
+	}
+
+	defer p.locks.lock(metricsKeys(metrics)...)()
+
+	if err := p.Store.AddMetrics(metrics); err != nil {
+		return err
+	}
+
+	for _, metric := range metrics {
+		record := models.Metrics{ID: metric.ID, MType: metric.MType, Labels: metric.Labels.Copy()}
+		switch metric.MType {
+		case models.Counter:
+			record.Delta = metric.Delta
+		case models.Gauge:
+			record.Value = metric.Value
+		case models.Histogram:
+			histogram := metric.Histogram.Copy()
+			record.Histogram = &histogram
+		}
+
+		p.recorder.Write(record)
+	}
+
+	return nil
+}
+
+func (p *PersistentStorage) DeleteMetric(metricType string, name string, labels models.Labels) error {
+	if err := p.recorder.Admit(1); err != nil {
+		return err
+	}
+
+	defer p.locks.lock(NewSeriesKey(metricType, name, labels))()
+
+	if err := p.Store.DeleteMetric(metricType, name, labels); err != nil {
+		return err
+	}
+
+	p.recorder.Write(models.Metrics{ID: name, MType: metricType, Labels: labels.Copy(), Op: models.OpDelete})
+	return nil
+}
+
+func (p *PersistentStorage) ResetMetric(metricType string, name string, labels models.Labels) error {
+	if err := p.recorder.Admit(1); err != nil {
+		return err
+	}
+
+	defer p.locks.lock(NewSeriesKey(metricType, name, labels))()
+
+	if err := p.Store.ResetMetric(metricType, name, labels); err != nil {
+		return err
+	}
+
+	p.recorder.Write(models.Metrics{ID: name, MType: metricType, Labels: labels.Copy(), Op: models.OpReset})
+	return nil
+}
+
+// DeleteByPrefix заранее не знает числа затронутых рядов, поэтому Admit проверяет место под одну запись.
+func (p *PersistentStorage) DeleteByPrefix(metricType string, prefix string) ([]models.Metrics, error) {
+	if err := p.recorder.Admit(1); err != nil {
+		return nil, err
+	}
+
+	defer p.locks.lockAll()()
+
+	deleted, err := p.Store.DeleteByPrefix(metricType, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	p.writeOps(models.OpDelete, deleted)
+	return deleted, nil
+}
+
+func (p *PersistentStorage) ResetByPrefix(metricType string, prefix string) ([]models.Metrics, error) {
+	if err := p.recorder.Admit(1); err != nil {
+		return nil, err
+	}
+
+	defer p.locks.lockAll()()
+
+	reset, err := p.Store.ResetByPrefix(metricType, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	p.writeOps(models.OpReset, reset)
+	return reset, nil
+}
+
+// RemoveStale записывает удаление каждого устаревшего ряда, как DeleteMetric.
+func (p *PersistentStorage) RemoveStale(updatedBefore time.Time, keepCounters bool) ([]models.Metrics, error) {
+	defer p.locks.lockAll()()
+
+	removed, err := p.Store.RemoveStale(updatedBefore, keepCounters)
+	if err != nil {
+		return nil, err
+	}
+
+	p.writeOps(models.OpDelete, removed)
+	return removed, nil
+}
+
+func (p *PersistentStorage) writeOps(op string, metrics []models.Metrics) {
+	for _, metric := range metrics {
+		p.recorder.Write(models.Metrics{ID: metric.ID, MType: metric.MType, Labels: metric.Labels, Op: op})
+	}
+}
+
+func (p *PersistentStorage) Restore(snapshot Snapshot) error {
+	defer p.locks.lockAll()()
+
+	if err := p.Store.Restore(snapshot); err != nil {
+		return err
+	}
+
+	return p.recorder.Rewrite(snapshot.Metrics)
+}
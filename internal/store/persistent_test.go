@@ -0,0 +1,88 @@
+package store
+
+import (
+	"errors"
+	"github.com/Oresst/goMetrics/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+type recordingRecorder struct {
+	mu      sync.Mutex
+	records []models.Metrics
+}
+
+func (r *recordingRecorder) Admit(records int) error {
+	return nil
+}
+
+// Write уступает процессор перед записью, чтобы изменения из других горутин успевали вклиниться.
+func (r *recordingRecorder) Write(metric models.Metrics) {
+	runtime.Gosched()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.records = append(r.records, metric)
+}
+
+func (r *recordingRecorder) Rewrite(metrics []models.Metrics) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.records = append([]models.Metrics(nil), metrics...)
+	return nil
+}
+
+// Журнал, записанный при одновременных изменениях одних и тех же рядов, воспроизводится в то же состояние.
+func TestPersistentStorageConcurrentReplay(t *testing.T) {
+	recorder := &recordingRecorder{}
+	storage := NewPersistentStorage(NewMemStorage(), recorder)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 50; j++ {
+				name := "series" + strconv.Itoa(j%3)
+				assert.NoError(t, storage.SetGauge(name, nil, float64(i*100+j)))
+				assert.NoError(t, storage.AddCounter(name, nil, 1))
+				assert.NoError(t, storage.AddMetrics([]models.Metrics{
+					{ID: name, MType: models.Counter, Delta: &[]int64{2}[0]},
+					{ID: "batch", MType: models.Gauge, Value: &[]float64{float64(j)}[0]},
+				}))
+
+				switch j % 10 {
+				case 3:
+					// Гаудж мог быть удалён другой горутиной, тогда остаётся только счётчик с тем же именем.
+					err := storage.DeleteMetric(models.Gauge, name, nil)
+					if !errors.Is(err, ErrMetricNotFound) && !errors.Is(err, ErrTypeMismatch) {
+						assert.NoError(t, err)
+					}
+				case 7:
+					_, err := storage.ResetByPrefix(models.Counter, "series")
+					assert.NoError(t, err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	snapshot, err := ReplayLog(recorder.records)
+	require.NoError(t, err)
+
+	replayed := NewMemStorage()
+	require.NoError(t, replayed.Restore(snapshot))
+
+	expected, err := storage.GetAllMetrics()
+	require.NoError(t, err)
+	actual, err := replayed.GetAllMetrics()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, expected, actual)
+}
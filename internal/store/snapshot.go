@@ -0,0 +1,149 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Oresst/goMetrics/models"
+	"time"
+)
+
+// SnapshotVersion — текущая версия формата Snapshot.
+const SnapshotVersion = 1
+
+var ErrSnapshotVersion = errors.New("unsupported snapshot version")
+
+// Snapshot — согласованное состояние всех рядов хранилища на момент CreatedAt.
+// Счётчики хранят накопленное значение, поэтому повторный Restore даёт тот же результат.
+// Timestamp метрик — время последнего обновления ряда; Restore переносит его в ряды, чтобы TTL отсчитывался от него.
+type Snapshot struct {
+	Version   int              `json:"version"`
+	CreatedAt time.Time        `json:"created_at"`
+	Metrics   []models.Metrics `json:"metrics"`
+}
+
+func newSnapshot(metrics []models.Metrics) Snapshot {
+	sortMetrics(metrics)
+
+	return Snapshot{
+		Version:   SnapshotVersion,
+		CreatedAt: time.Now().UTC(),
+		Metrics:   metrics,
+	}
+}
+
+// validateSnapshot проверяет версию, метрики и отсутствие повторов одного ряда.
+func validateSnapshot(snapshot Snapshot) error {
+	if snapshot.Version != SnapshotVersion {
+		return fmt.Errorf("%w: %d", ErrSnapshotVersion, snapshot.Version)
+	}
+
+	if err := validateMetrics(snapshot.Metrics); err != nil {
+		return err
+	}
+
+	seen := make(map[SeriesKey]struct{}, len(snapshot.Metrics))
+	for _, metric := range snapshot.Metrics {
+		key := NewSeriesKey(metric.MType, metric.ID, metric.Labels)
+		if _, ok := seen[key]; ok {
+			return fmt.Errorf("%w: duplicate %s %s", ErrInvalidMetric, metric.MType, key.ID)
+		}
+		seen[key] = struct{}{}
+	}
+
+	return nil
+}
+
+// restoredAt возвращает время последнего изменения ряда из среза или now, если срез его не хранит.
+func restoredAt(metric models.Metrics, now time.Time) time.Time {
+	if metric.Timestamp == 0 {
+		return now
+	}
+
+	return time.Unix(0, metric.Timestamp)
+}
+
+// MergeSnapshot добавляет срез к текущему содержимому хранилища одной пачкой:
+// счётчики суммируются, гауджи заменяются, гистограммы сливаются.
+func MergeSnapshot(storage Store, snapshot Snapshot) error {
+	if err := validateSnapshot(snapshot); err != nil {
+		return err
+	}
+
+	if len(snapshot.Metrics) == 0 {
+		return nil
+	}
+
+	return storage.AddMetrics(snapshot.Metrics)
+}
+
+// replayProgressEvery — через сколько записей ReplayLogProgress сообщает о ходе воспроизведения.
+const replayProgressEvery = 10000
+
+// ReplayLog воспроизводит журнал обновлений по порядку и возвращает итоговое состояние.
+// Запись неизвестного типа или без значения считается ошибкой. Удаление или сброс отсутствующего ряда
+// пропускается: ряд мог не попасть в журнал, например при восстановлении на момент времени.
+// Timestamp метрик итогового состояния — время приёма последней записи ряда.
+func ReplayLog(records []models.Metrics) (Snapshot, error) {
+	return ReplayLogProgress(records, nil)
+}
+
+// ReplayLogProgress работает как ReplayLog и вызывает progress с числом воспроизведённых записей
+// каждые replayProgressEvery записей и по завершении.
+func ReplayLogProgress(records []models.Metrics, progress func(done int)) (Snapshot, error) {
+	replayed := NewMemStorage()
+	stamps := make(map[SeriesKey]int64)
+
+	for i, record := range records {
+		err := validateSeries(record.MType, record.ID, record.Labels)
+		switch {
+		case err != nil:
+		case record.Op == models.OpDelete:
+			err = ignoreNotFound(replayed.DeleteMetric(record.MType, record.ID, record.Labels))
+		case record.Op == models.OpReset:
+			err = ignoreNotFound(replayed.ResetMetric(record.MType, record.ID, record.Labels))
+		case record.Op != "" && record.Op != models.OpState:
+			err = fmt.Errorf("%w: unknown op %q", ErrInvalidMetric, record.Op)
+		case record.MType == models.Counter && record.Delta != nil:
+			err = replayed.AddCounter(record.ID, record.Labels, *record.Delta)
+		case record.MType == models.Gauge && record.Value != nil:
+			err = replayed.SetGauge(record.ID, record.Labels, *record.Value)
+		case record.MType == models.Histogram && record.Histogram != nil:
+			err = replayed.AddHistogram(record.ID, record.Labels, *record.Histogram)
+		default:
+			err = fmt.Errorf("%w: %s %s", ErrInvalidMetric, record.MType, record.ID)
+		}
+
+		if err != nil {
+			return Snapshot{}, fmt.Errorf("record %d: %w", i+1, err)
+		}
+		key := NewSeriesKey(record.MType, record.ID, record.Labels)
+		stamps[key] = max(stamps[key], record.Timestamp)
+
+		if progress != nil && (i+1)%replayProgressEvery == 0 {
+			progress(i + 1)
+		}
+	}
+
+	if progress != nil {
+		progress(len(records))
+	}
+
+	snapshot, err := replayed.Snapshot()
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	for i, metric := range snapshot.Metrics {
+		snapshot.Metrics[i].Timestamp = stamps[NewSeriesKey(metric.MType, metric.ID, metric.Labels)]
+	}
+
+	return snapshot, nil
+}
+
+func ignoreNotFound(err error) error {
+	if errors.Is(err, ErrMetricNotFound) || errors.Is(err, ErrTypeMismatch) {
+		return nil
+	}
+
+	return err
+}
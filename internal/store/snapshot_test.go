@@ -0,0 +1,193 @@
+package store
+
+import (
+	"github.com/Oresst/goMetrics/internal/utils"
+	"github.com/Oresst/goMetrics/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestMemStorageSnapshotRestore(t *testing.T) {
+	storage := NewMemStorage()
+	require.NoError(t, storage.AddCounter("PollCount", nil, 3))
+	require.NoError(t, storage.AddCounter("requests", models.Labels{"host": "web-1"}, 7))
+	require.NoError(t, storage.SetGauge("Alloc", nil, 1.5))
+
+	h := models.NewHistogramData([]float64{1, 5})
+	h.Observe(2)
+	require.NoError(t, storage.AddHistogram("latency", nil, h))
+
+	snapshot, err := storage.Snapshot()
+	require.NoError(t, err)
+	assert.Equal(t, SnapshotVersion, snapshot.Version)
+	require.Len(t, snapshot.Metrics, 4)
+
+	restored := NewMemStorage()
+	require.NoError(t, restored.SetGauge("Obsolete", nil, 1))
+
+	// Повторное восстановление не должно удваивать счётчики.
+	require.NoError(t, restored.Restore(snapshot))
+	require.NoError(t, restored.Restore(snapshot))
+
+	delta, err := restored.GetCounter("requests", models.Labels{"host": "web-1"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), delta)
+
+	_, err = restored.GetGauge("Obsolete", nil)
+	assert.ErrorIs(t, err, ErrMetricNotFound)
+
+	expected, err := storage.GetAllMetrics()
+	require.NoError(t, err)
+	actual, err := restored.GetAllMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestMemStorageRestoreInvalid(t *testing.T) {
+	testCases := []struct {
+		name     string
+		snapshot Snapshot
+		err      error
+	}{
+		{
+			name:     "unknown version",
+			snapshot: Snapshot{Version: SnapshotVersion + 1},
+			err:      ErrSnapshotVersion,
+		},
+		{
+			name: "duplicate series",
+			snapshot: Snapshot{Version: SnapshotVersion, Metrics: []models.Metrics{
+				{ID: "PollCount", MType: models.Counter, Delta: utils.PointInt64(1)},
+				{ID: "PollCount", MType: models.Counter, Delta: utils.PointInt64(2)},
+			}},
+			err: ErrInvalidMetric,
+		},
+		{
+			name: "ambiguous name",
+			snapshot: Snapshot{Version: SnapshotVersion, Metrics: []models.Metrics{
+				{ID: `cpu{host="a"}`, MType: models.Gauge, Value: utils.PointFloat64(1)},
+				{ID: "cpu", MType: models.Gauge, Labels: models.Labels{"host": "a"}, Value: utils.PointFloat64(2)},
+			}},
+			err: ErrInvalidMetric,
+		},
+		{
+			name: "missing value",
+			snapshot: Snapshot{Version: SnapshotVersion, Metrics: []models.Metrics{
+				{ID: "Alloc", MType: models.Gauge},
+			}},
+			err: ErrInvalidMetric,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			storage := NewMemStorage()
+			require.NoError(t, storage.AddCounter("kept", nil, 1))
+
+			assert.ErrorIs(t, storage.Restore(tc.snapshot), tc.err)
+
+			delta, err := storage.GetCounter("kept", nil)
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), delta)
+		})
+	}
+}
+
+func TestReplayLog(t *testing.T) {
+	snapshot, err := ReplayLog([]models.Metrics{
+		{ID: "PollCount", MType: models.Counter, Delta: utils.PointInt64(1)},
+		{ID: "Alloc", MType: models.Gauge, Value: utils.PointFloat64(1)},
+		{ID: "PollCount", MType: models.Counter, Delta: utils.PointInt64(2)},
+		{ID: "Alloc", MType: models.Gauge, Value: utils.PointFloat64(2)},
+	})
+	require.NoError(t, err)
+	require.Len(t, snapshot.Metrics, 2)
+
+	storage := NewMemStorage()
+	require.NoError(t, storage.Restore(snapshot))
+
+	delta, err := storage.GetCounter("PollCount", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), delta)
+
+	value, err := storage.GetGauge("Alloc", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, value)
+
+	_, err = ReplayLog([]models.Metrics{{ID: "x", MType: "summary"}})
+	assert.ErrorIs(t, err, ErrInvalidMetric)
+}
+
+func TestReplayLogOps(t *testing.T) {
+	snapshot, err := ReplayLog([]models.Metrics{
+		{ID: "PollCount", MType: models.Counter, Delta: utils.PointInt64(5)},
+		{ID: "Alloc", MType: models.Gauge, Value: utils.PointFloat64(1)},
+		{ID: "PollCount", MType: models.Counter, Op: models.OpReset},
+		{ID: "PollCount", MType: models.Counter, Delta: utils.PointInt64(2)},
+		{ID: "Alloc", MType: models.Gauge, Op: models.OpDelete},
+		{ID: "Missing", MType: models.Gauge, Op: models.OpDelete},
+	})
+	require.NoError(t, err)
+	require.Len(t, snapshot.Metrics, 1)
+	assert.Equal(t, "PollCount", snapshot.Metrics[0].ID)
+	assert.Equal(t, int64(2), *snapshot.Metrics[0].Delta)
+
+	_, err = ReplayLog([]models.Metrics{{ID: "PollCount", MType: models.Counter, Op: "rename"}})
+	assert.ErrorIs(t, err, ErrInvalidMetric)
+}
+
+func TestReplayLogProgress(t *testing.T) {
+	records := make([]models.Metrics, replayProgressEvery*2+5)
+	for i := range records {
+		records[i] = models.Metrics{ID: "PollCount", MType: models.Counter, Delta: utils.PointInt64(1)}
+	}
+
+	var reported []int
+	snapshot, err := ReplayLogProgress(records, func(done int) {
+		reported = append(reported, done)
+	})
+	require.NoError(t, err)
+	require.Len(t, snapshot.Metrics, 1)
+	assert.Equal(t, int64(len(records)), *snapshot.Metrics[0].Delta)
+	assert.Equal(t, []int{replayProgressEvery, replayProgressEvery * 2, len(records)}, reported)
+}
+
+func TestReplayLogTimestamps(t *testing.T) {
+	snapshot, err := ReplayLog([]models.Metrics{
+		{ID: "PollCount", MType: models.Counter, Delta: utils.PointInt64(1), Timestamp: 10},
+		{ID: "Alloc", MType: models.Gauge, Value: utils.PointFloat64(1), Timestamp: 20},
+		{ID: "PollCount", MType: models.Counter, Delta: utils.PointInt64(2), Timestamp: 30},
+		{ID: "Alloc", MType: models.Gauge, Op: models.OpReset, Timestamp: 40},
+	})
+	require.NoError(t, err)
+	require.Len(t, snapshot.Metrics, 2)
+
+	stamps := make(map[string]int64)
+	for _, metric := range snapshot.Metrics {
+		stamps[metric.ID] = metric.Timestamp
+	}
+	assert.Equal(t, map[string]int64{"PollCount": 30, "Alloc": 40}, stamps)
+}
+
+// Метки с недопустимыми именами отвергаются на входе в хранилище: иначе разные наборы меток
+// дали бы один SeriesID.
+func TestAmbiguousLabelsRejected(t *testing.T) {
+	ambiguous := models.Labels{`a="1",b`: "2"}
+	require.Equal(t, models.Labels{"a": "1", "b": "2"}.String(), ambiguous.String())
+
+	_, err := ReplayLog([]models.Metrics{
+		{ID: "requests", MType: models.Counter, Labels: models.Labels{"a": "1", "b": "2"}, Delta: utils.PointInt64(1)},
+		{ID: "requests", MType: models.Counter, Labels: ambiguous, Delta: utils.PointInt64(1)},
+	})
+	assert.ErrorIs(t, err, ErrInvalidMetric)
+
+	_, err = ReplayLog([]models.Metrics{{ID: "requests", MType: models.Counter, Labels: ambiguous, Op: models.OpDelete}})
+	assert.ErrorIs(t, err, ErrInvalidMetric)
+
+	metrics := []models.Metrics{{ID: "requests", MType: models.Counter, Labels: ambiguous, Delta: utils.PointInt64(1)}}
+	storage := NewMemStorage()
+	assert.ErrorIs(t, storage.AddMetrics(metrics), ErrInvalidMetric)
+	assert.ErrorIs(t, storage.Restore(newSnapshot(metrics)), ErrInvalidMetric)
+	assert.ErrorIs(t, MergeSnapshot(storage, newSnapshot(metrics)), ErrInvalidMetric)
+}
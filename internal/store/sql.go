@@ -1,2 +1,679 @@
 package store
 
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Oresst/goMetrics/models"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"sort"
+	"time"
+)
+
+const queryTimeout = 5 * time.Second
+
+// schema создаёт таблицы и доводит до текущего вида таблицы, созданные предыдущими версиями:
+// добавляет updated_at и labels и переносит первичный ключ на (name, labels).
+const schema = `
+	CREATE TABLE IF NOT EXISTS counters (
+		name   TEXT NOT NULL,
+		labels JSONB NOT NULL DEFAULT '{}',
+		delta  BIGINT NOT NULL,
+		PRIMARY KEY (name, labels)
+	);
+	CREATE TABLE IF NOT EXISTS gauges (
+		name   TEXT NOT NULL,
+		labels JSONB NOT NULL DEFAULT '{}',
+		value  DOUBLE PRECISION NOT NULL,
+		PRIMARY KEY (name, labels)
+	);
+	CREATE TABLE IF NOT EXISTS histograms (
+		name       TEXT NOT NULL,
+		labels     JSONB NOT NULL DEFAULT '{}',
+		data       JSONB NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (name, labels)
+	);
+	ALTER TABLE counters ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+	ALTER TABLE gauges ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+	ALTER TABLE counters ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+	ALTER TABLE gauges ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+	DO $$
+	DECLARE
+		t TEXT;
+	BEGIN
+		FOREACH t IN ARRAY ARRAY['counters', 'gauges'] LOOP
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.key_column_usage
+				WHERE table_schema = current_schema() AND table_name = t
+					AND constraint_name = t || '_pkey' AND column_name = 'labels'
+			) THEN
+				EXECUTE format('ALTER TABLE %I DROP CONSTRAINT IF EXISTS %I', t, t || '_pkey');
+				EXECUTE format('ALTER TABLE %I ADD PRIMARY KEY (name, labels)', t);
+			END IF;
+		END LOOP;
+	END $$;
+`
+
+const upsertCounter = `
+	INSERT INTO counters (name, labels, delta, updated_at) VALUES ($1, $2, $3, now())
+	ON CONFLICT (name, labels) DO UPDATE SET delta = counters.delta + EXCLUDED.delta, updated_at = EXCLUDED.updated_at
+`
+
+const upsertGauge = `
+	INSERT INTO gauges (name, labels, value, updated_at) VALUES ($1, $2, $3, now())
+	ON CONFLICT (name, labels) DO UPDATE SET value = EXCLUDED.value, updated_at = EXCLUDED.updated_at
+`
+
+var metricTables = map[string]string{
+	models.Counter:   "counters",
+	models.Gauge:     "gauges",
+	models.Histogram: "histograms",
+}
+
+// SQLStorage хранит метрики в Postgres через database/sql.
+type SQLStorage struct {
+	db *sql.DB
+}
+
+func NewSQLStorage(dsn string) (*SQLStorage, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	if err = db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if _, err = db.ExecContext(ctx, schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create schema: %w", err)
+	}
+
+	return &SQLStorage{db: db}, nil
+}
+
+// labelsJSON кодирует метки для колонки labels; jsonb сравнивает объекты независимо от порядка ключей.
+func labelsJSON(labels models.Labels) (string, error) {
+	if len(labels) == 0 {
+		return "{}", nil
+	}
+
+	data, err := json.Marshal(labels)
+	return string(data), err
+}
+
+func scanLabels(data []byte) (models.Labels, error) {
+	var labels models.Labels
+	if err := json.Unmarshal(data, &labels); err != nil {
+		return nil, err
+	}
+
+	return labels.Copy(), nil
+}
+
+func (s *SQLStorage) AddCounter(name string, labels models.Labels, delta int64) error {
+	encoded, err := labelsJSON(labels)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	_, err = s.db.ExecContext(ctx, upsertCounter, name, encoded, delta)
+	return err
+}
+
+func (s *SQLStorage) SetGauge(name string, labels models.Labels, value float64) error {
+	encoded, err := labelsJSON(labels)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	_, err = s.db.ExecContext(ctx, upsertGauge, name, encoded, value)
+	return err
+}
+
+func (s *SQLStorage) AddHistogram(name string, labels models.Labels, histogram models.HistogramData) error {
+	if err := histogram.Validate(); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidMetric, err.Error())
+	}
+
+	encoded, err := labelsJSON(labels)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = addHistogram(ctx, tx, name, encoded, histogram); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// addHistogram сливает наблюдения в строке, заблокированной SELECT ... FOR UPDATE.
+// Пустая гистограмма вставляется заранее, чтобы параллельные первые записи не затёрли друг друга.
+func addHistogram(ctx context.Context, tx *sql.Tx, name string, labels string, histogram models.HistogramData) error {
+	empty, err := json.Marshal(models.NewHistogramData(histogram.Buckets))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO histograms (name, labels, data) VALUES ($1, $2, $3)
+		ON CONFLICT (name, labels) DO NOTHING
+	`, name, labels, string(empty))
+	if err != nil {
+		return err
+	}
+
+	var raw []byte
+	err = tx.QueryRowContext(ctx, `SELECT data FROM histograms WHERE name = $1 AND labels = $2 FOR UPDATE`, name, labels).Scan(&raw)
+	if err != nil {
+		return err
+	}
+
+	var current models.HistogramData
+	if err = json.Unmarshal(raw, &current); err != nil {
+		return err
+	}
+
+	if err = current.Merge(histogram); err != nil {
+		return fmt.Errorf("%w: %s%s", err, name, labels)
+	}
+
+	merged, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE histograms SET data = $3, updated_at = now() WHERE name = $1 AND labels = $2
+	`, name, labels, string(merged))
+
+	return err
+}
+
+func (s *SQLStorage) AddMetrics(metrics []models.Metrics) error {
+	if err := validateMetrics(metrics); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, metric := range metrics {
+		var encoded string
+		encoded, err = labelsJSON(metric.Labels)
+		if err != nil {
+			return err
+		}
+
+		switch metric.MType {
+		case models.Counter:
+			_, err = tx.ExecContext(ctx, upsertCounter, metric.ID, encoded, *metric.Delta)
+		case models.Gauge:
+			_, err = tx.ExecContext(ctx, upsertGauge, metric.ID, encoded, *metric.Value)
+		case models.Histogram:
+			err = addHistogram(ctx, tx, metric.ID, encoded, *metric.Histogram)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLStorage) GetCounter(name string, labels models.Labels) (int64, error) {
+	encoded, err := labelsJSON(labels)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	var delta int64
+	err = s.db.QueryRowContext(ctx, `SELECT delta FROM counters WHERE name = $1 AND labels = $2`, name, encoded).Scan(&delta)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, s.notFound(ctx, models.Counter, name, encoded)
+	}
+
+	return delta, err
+}
+
+func (s *SQLStorage) GetGauge(name string, labels models.Labels) (float64, error) {
+	encoded, err := labelsJSON(labels)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	var value float64
+	err = s.db.QueryRowContext(ctx, `SELECT value FROM gauges WHERE name = $1 AND labels = $2`, name, encoded).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, s.notFound(ctx, models.Gauge, name, encoded)
+	}
+
+	return value, err
+}
+
+func (s *SQLStorage) GetHistogram(name string, labels models.Labels) (models.HistogramData, error) {
+	encoded, err := labelsJSON(labels)
+	if err != nil {
+		return models.HistogramData{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	var raw []byte
+	err = s.db.QueryRowContext(ctx, `SELECT data FROM histograms WHERE name = $1 AND labels = $2`, name, encoded).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.HistogramData{}, s.notFound(ctx, models.Histogram, name, encoded)
+	}
+	if err != nil {
+		return models.HistogramData{}, err
+	}
+
+	var histogram models.HistogramData
+	err = json.Unmarshal(raw, &histogram)
+	return histogram, err
+}
+
+// notFound различает отсутствующий ряд и ряд, записанный с другим типом.
+func (s *SQLStorage) notFound(ctx context.Context, metricType string, name string, labels string) error {
+	for otherType, table := range metricTables {
+		if otherType == metricType {
+			continue
+		}
+
+		var exists bool
+		query := fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s WHERE name = $1 AND labels = $2)`, table)
+		if err := s.db.QueryRowContext(ctx, query, name, labels).Scan(&exists); err != nil {
+			return err
+		}
+
+		if exists {
+			return fmt.Errorf("%w: %s%s is %s", ErrTypeMismatch, name, labels, otherType)
+		}
+	}
+
+	return ErrMetricNotFound
+}
+
+// Все выборки метрик возвращают колонки name, labels, type, delta, value, data, updated_at для scanSeries.
+var (
+	counterColumns   = fmt.Sprintf(`name, labels, '%s', delta, NULL::DOUBLE PRECISION, NULL::JSONB, updated_at`, models.Counter)
+	gaugeColumns     = fmt.Sprintf(`name, labels, '%s', NULL::BIGINT, value, NULL::JSONB, updated_at`, models.Gauge)
+	histogramColumns = fmt.Sprintf(`name, labels, '%s', NULL::BIGINT, NULL::DOUBLE PRECISION, data, updated_at`, models.Histogram)
+)
+
+var allMetricsQuery = fmt.Sprintf(`
+	SELECT %s FROM counters
+	UNION ALL
+	SELECT %s FROM gauges
+	UNION ALL
+	SELECT %s FROM histograms
+`, counterColumns, gaugeColumns, histogramColumns)
+
+func scanMetrics(rows *sql.Rows, result []models.Metrics) ([]models.Metrics, error) {
+	series, err := scanSeries(rows, make([]Series, 0))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range series {
+		result = append(result, item.Metrics)
+	}
+
+	return result, nil
+}
+
+func scanSeries(rows *sql.Rows, result []Series) ([]Series, error) {
+	for rows.Next() {
+		var metric models.Metrics
+		var labels []byte
+		var delta sql.NullInt64
+		var value sql.NullFloat64
+		var histogram []byte
+		var updatedAt time.Time
+
+		if err := rows.Scan(&metric.ID, &labels, &metric.MType, &delta, &value, &histogram, &updatedAt); err != nil {
+			return nil, err
+		}
+
+		var err error
+		if metric.Labels, err = scanLabels(labels); err != nil {
+			return nil, err
+		}
+
+		if delta.Valid {
+			metric.Delta = &delta.Int64
+		}
+		if value.Valid {
+			metric.Value = &value.Float64
+		}
+		if histogram != nil {
+			metric.Histogram = &models.HistogramData{}
+			if err = json.Unmarshal(histogram, metric.Histogram); err != nil {
+				return nil, err
+			}
+		}
+
+		result = append(result, Series{Metrics: metric, UpdatedAt: updatedAt})
+	}
+
+	return result, rows.Err()
+}
+
+func (s *SQLStorage) GetAllMetrics() ([]models.Metrics, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, allMetricsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result, err := scanMetrics(rows, make([]models.Metrics, 0))
+	if err != nil {
+		return nil, err
+	}
+
+	sortMetrics(result)
+	return result, nil
+}
+
+func (s *SQLStorage) GetAllSeries() ([]Series, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, allMetricsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result, err := scanSeries(rows, make([]Series, 0))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return metricLess(result[i].Metrics, result[j].Metrics)
+	})
+	return result, nil
+}
+
+func (s *SQLStorage) RemoveStale(updatedBefore time.Time, keepCounters bool) ([]models.Metrics, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	removed := make([]models.Metrics, 0)
+
+	if !keepCounters {
+		query := fmt.Sprintf(`DELETE FROM counters WHERE updated_at < $1 RETURNING %s`, counterColumns)
+		if removed, err = queryMetrics(ctx, tx, removed, query, updatedBefore); err != nil {
+			return nil, err
+		}
+	}
+
+	query := fmt.Sprintf(`DELETE FROM gauges WHERE updated_at < $1 RETURNING %s`, gaugeColumns)
+	if removed, err = queryMetrics(ctx, tx, removed, query, updatedBefore); err != nil {
+		return nil, err
+	}
+
+	query = fmt.Sprintf(`DELETE FROM histograms WHERE updated_at < $1 RETURNING %s`, histogramColumns)
+	if removed, err = queryMetrics(ctx, tx, removed, query, updatedBefore); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	sortMetrics(removed)
+	return removed, nil
+}
+
+// seriesColumns и resetColumns — колонки выборки и SET-часть UPDATE, обнуляющая ряд, по типу метрики.
+// Гистограмма сохраняет границы корзин, счётчики корзин заменяются нулями.
+var (
+	seriesColumns = map[string]string{
+		models.Counter:   counterColumns,
+		models.Gauge:     gaugeColumns,
+		models.Histogram: histogramColumns,
+	}
+	resetColumns = map[string]string{
+		models.Counter: `delta = 0`,
+		models.Gauge:   `value = 0`,
+		models.Histogram: `data = jsonb_build_object(
+			'buckets', data->'buckets',
+			'counts', (SELECT COALESCE(jsonb_agg(0), '[]'::jsonb) FROM jsonb_array_elements(data->'counts')),
+			'count', 0,
+			'sum', 0
+		)`,
+	}
+)
+
+func (s *SQLStorage) DeleteMetric(metricType string, name string, labels models.Labels) error {
+	return s.updateSeries(metricType, false, name, labels)
+}
+
+func (s *SQLStorage) ResetMetric(metricType string, name string, labels models.Labels) error {
+	return s.updateSeries(metricType, true, name, labels)
+}
+
+func (s *SQLStorage) updateSeries(metricType string, reset bool, name string, labels models.Labels) error {
+	encoded, err := labelsJSON(labels)
+	if err != nil {
+		return err
+	}
+
+	affected, err := s.changeSeries(metricType, reset, `name = $1 AND labels = $2`, name, encoded)
+	if err != nil {
+		return err
+	}
+
+	if len(affected) == 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+		defer cancel()
+
+		return s.notFound(ctx, metricType, name, encoded)
+	}
+
+	return nil
+}
+
+func (s *SQLStorage) DeleteByPrefix(metricType string, prefix string) ([]models.Metrics, error) {
+	return s.changeSeries(metricType, false, `left(name, length($1::text)) = $1::text`, prefix)
+}
+
+func (s *SQLStorage) ResetByPrefix(metricType string, prefix string) ([]models.Metrics, error) {
+	return s.changeSeries(metricType, true, `left(name, length($1::text)) = $1::text`, prefix)
+}
+
+// changeSeries удаляет или, если reset, обнуляет ряды типа metricType, подходящие под where,
+// и возвращает их значения до изменения. Ряды блокируются до конца транзакции.
+func (s *SQLStorage) changeSeries(metricType string, reset bool, where string, args ...any) ([]models.Metrics, error) {
+	if err := checkType(metricType); err != nil {
+		return nil, err
+	}
+	table := metricTables[metricType]
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf(`SELECT %s FROM %s WHERE %s FOR UPDATE`, seriesColumns[metricType], table, where)
+	affected, err := queryMetrics(ctx, tx, make([]models.Metrics, 0), query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(affected) == 0 {
+		return affected, nil
+	}
+
+	if reset {
+		query = fmt.Sprintf(`UPDATE %s SET %s, updated_at = now() WHERE %s`, table, resetColumns[metricType], where)
+	} else {
+		query = fmt.Sprintf(`DELETE FROM %s WHERE %s`, table, where)
+	}
+
+	if _, err = tx.ExecContext(ctx, query, args...); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	sortMetrics(affected)
+	return affected, nil
+}
+
+// Snapshot читает все таблицы в одной транзакции REPEATABLE READ.
+func (s *SQLStorage) Snapshot() (Snapshot, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, allMetricsQuery)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer rows.Close()
+
+	series, err := scanSeries(rows, make([]Series, 0))
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return Snapshot{}, err
+	}
+
+	metrics := make([]models.Metrics, len(series))
+	for i, s := range series {
+		metrics[i] = s.Metrics
+		metrics[i].Timestamp = s.UpdatedAt.UnixNano()
+	}
+
+	return newSnapshot(metrics), nil
+}
+
+// Restore очищает таблицы и записывает срез в одной транзакции.
+func (s *SQLStorage) Restore(snapshot Snapshot) error {
+	if err := validateSnapshot(snapshot); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM counters; DELETE FROM gauges; DELETE FROM histograms`); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, metric := range snapshot.Metrics {
+		var encoded string
+		encoded, err = labelsJSON(metric.Labels)
+		if err != nil {
+			return err
+		}
+
+		updatedAt := restoredAt(metric, now)
+		switch metric.MType {
+		case models.Counter:
+			_, err = tx.ExecContext(ctx, `INSERT INTO counters (name, labels, delta, updated_at) VALUES ($1, $2, $3, $4)`,
+				metric.ID, encoded, *metric.Delta, updatedAt)
+		case models.Gauge:
+			_, err = tx.ExecContext(ctx, `INSERT INTO gauges (name, labels, value, updated_at) VALUES ($1, $2, $3, $4)`,
+				metric.ID, encoded, *metric.Value, updatedAt)
+		case models.Histogram:
+			var data []byte
+			if data, err = json.Marshal(metric.Histogram); err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, `INSERT INTO histograms (name, labels, data, updated_at) VALUES ($1, $2, $3, $4)`,
+				metric.ID, encoded, string(data), updatedAt)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// queryMetrics выполняет запрос в транзакции и дописывает полученные метрики в result.
+func queryMetrics(ctx context.Context, tx *sql.Tx, result []models.Metrics, query string, args ...any) ([]models.Metrics, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanMetrics(rows, result)
+}
+
+func (s *SQLStorage) Close() error {
+	return s.db.Close()
+}
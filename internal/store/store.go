@@ -1,9 +1,103 @@
 package store
 
-import "github.com/Oresst/goMetrics/models"
+import (
+	"errors"
+	"fmt"
+	"github.com/Oresst/goMetrics/models"
+	"time"
+)
 
+var (
+	ErrMetricNotFound = errors.New("metric not found")
+	ErrTypeMismatch   = errors.New("metric registered with another type")
+	ErrInvalidMetric  = errors.New("invalid metric")
+)
+
+// Store хранит счётчики (int64), гауджи (float64) и гистограммы в раздельных пространствах имён.
+// Ряд определяется именем и набором меток, nil и пустые метки равнозначны.
+// Get* возвращает ErrTypeMismatch, если ряд с таким именем и метками есть только с другим типом.
+// AddHistogram добавляет наблюдения к гистограмме, границы корзин ряда менять нельзя.
+// AddMetrics применяет пачку метрик целиком или не применяет ни одной.
+// GetAllSeries возвращает то же, что GetAllMetrics, вместе со временем последнего обновления рядов.
+// RemoveStale удаляет метрики, не обновлявшиеся с updatedBefore, и возвращает удалённые.
+// DeleteMetric удаляет ряд, ResetMetric обнуляет его: счётчик и гаудж становятся 0, у гистограммы
+// остаются границы корзин без наблюдений. Если ряда нет, возвращается ErrMetricNotFound или ErrTypeMismatch.
+// DeleteByPrefix и ResetByPrefix делают то же со всеми рядами типа, имя которых начинается с prefix,
+// и возвращают затронутые ряды со значениями до операции.
+// Snapshot возвращает согласованный срез всех рядов, Restore заменяет им всё содержимое хранилища.
 type Store interface {
-	AddMetric(metricType string, name string, value float64) error
-	GetMetric(name string) (float64, error)
-	GetAllMetrics() map[string]models.Metrics
+	AddCounter(name string, labels models.Labels, delta int64) error
+	SetGauge(name string, labels models.Labels, value float64) error
+	AddHistogram(name string, labels models.Labels, histogram models.HistogramData) error
+	AddMetrics(metrics []models.Metrics) error
+	GetCounter(name string, labels models.Labels) (int64, error)
+	GetGauge(name string, labels models.Labels) (float64, error)
+	GetHistogram(name string, labels models.Labels) (models.HistogramData, error)
+	GetAllMetrics() ([]models.Metrics, error)
+	GetAllSeries() ([]Series, error)
+	RemoveStale(updatedBefore time.Time, keepCounters bool) ([]models.Metrics, error)
+	DeleteMetric(metricType string, name string, labels models.Labels) error
+	ResetMetric(metricType string, name string, labels models.Labels) error
+	DeleteByPrefix(metricType string, prefix string) ([]models.Metrics, error)
+	ResetByPrefix(metricType string, prefix string) ([]models.Metrics, error)
+	Snapshot() (Snapshot, error)
+	Restore(snapshot Snapshot) error
+}
+
+// Series — ряд со временем последнего обновления.
+type Series struct {
+	models.Metrics
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SeriesKey — тип метрики и models.SeriesID: ключ ряда в картах хранилища и его обёрток.
+type SeriesKey struct {
+	MType string
+	ID    string
+}
+
+func NewSeriesKey(metricType string, name string, labels models.Labels) SeriesKey {
+	return SeriesKey{MType: metricType, ID: models.SeriesID(name, labels)}
+}
+
+func checkType(metricType string) error {
+	switch metricType {
+	case models.Counter, models.Gauge, models.Histogram:
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidMetric, metricType)
+	}
+}
+
+// validateSeries проверяет имя и метки ряда, от которых зависит однозначность SeriesID.
+func validateSeries(metricType string, id string, labels models.Labels) error {
+	if err := models.ValidateName(id); err != nil {
+		return fmt.Errorf("%w: %s %q: %s", ErrInvalidMetric, metricType, id, err.Error())
+	}
+	if err := models.ValidateLabels(labels); err != nil {
+		return fmt.Errorf("%w: %s %q: %s", ErrInvalidMetric, metricType, id, err.Error())
+	}
+
+	return nil
+}
+
+func validateMetrics(metrics []models.Metrics) error {
+	for _, metric := range metrics {
+		if err := validateSeries(metric.MType, metric.ID, metric.Labels); err != nil {
+			return err
+		}
+
+		switch {
+		case metric.MType == models.Counter && metric.Delta != nil:
+		case metric.MType == models.Gauge && metric.Value != nil:
+		case metric.MType == models.Histogram && metric.Histogram != nil:
+			if err := metric.Histogram.Validate(); err != nil {
+				return fmt.Errorf("%w: %s %s: %s", ErrInvalidMetric, metric.MType, metric.ID, err.Error())
+			}
+		default:
+			return fmt.Errorf("%w: %s %s", ErrInvalidMetric, metric.MType, metric.ID)
+		}
+	}
+
+	return nil
 }
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"strconv"
+	"strings"
 )
 
 func BetterFormat(num float64) string {
@@ -25,3 +26,19 @@ func PointFloat64(value float64) *float64 {
 func PointInt64(value int64) *int64 {
 	return &value
 }
+
+// ParseFloats разбирает список чисел через запятую.
+func ParseFloats(value string) ([]float64, error) {
+	parts := strings.Split(value, ",")
+	result := make([]float64, 0, len(parts))
+
+	for _, part := range parts {
+		number, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, number)
+	}
+
+	return result, nil
+}
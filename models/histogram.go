@@ -0,0 +1,106 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// DefaultHistogramBuckets — границы корзин по умолчанию, как в клиенте Prometheus.
+var DefaultHistogramBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var ErrBucketsMismatch = errors.New("histogram buckets mismatch")
+
+// HistogramData хранит распределение наблюдений.
+// Buckets — верхние границы корзин по возрастанию, Counts[i] — число наблюдений в корзине i
+// (не накопительно), последний элемент Counts — наблюдения больше последней границы.
+type HistogramData struct {
+	Buckets []float64 `json:"buckets"`
+	Counts  []uint64  `json:"counts"`
+	Count   uint64    `json:"count"`
+	Sum     float64   `json:"sum"`
+}
+
+func NewHistogramData(buckets []float64) HistogramData {
+	return HistogramData{
+		Buckets: append([]float64(nil), buckets...),
+		Counts:  make([]uint64, len(buckets)+1),
+	}
+}
+
+func (h *HistogramData) Observe(value float64) {
+	h.Counts[sort.SearchFloat64s(h.Buckets, value)]++
+	h.Count++
+	h.Sum += value
+}
+
+// Merge добавляет к h наблюдения other; границы корзин должны совпадать.
+func (h *HistogramData) Merge(other HistogramData) error {
+	if !h.SameBuckets(other) {
+		return ErrBucketsMismatch
+	}
+
+	for i, count := range other.Counts {
+		h.Counts[i] += count
+	}
+	h.Count += other.Count
+	h.Sum += other.Sum
+
+	return nil
+}
+
+func (h HistogramData) SameBuckets(other HistogramData) bool {
+	if len(h.Buckets) != len(other.Buckets) {
+		return false
+	}
+
+	for i, bound := range h.Buckets {
+		if bound != other.Buckets[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (h HistogramData) Validate() error {
+	if len(h.Buckets) == 0 {
+		return errors.New("histogram must have at least one bucket")
+	}
+
+	for i, bound := range h.Buckets {
+		if math.IsNaN(bound) || math.IsInf(bound, 0) {
+			return errors.New("histogram bucket bounds must be finite")
+		}
+		if i > 0 && bound <= h.Buckets[i-1] {
+			return errors.New("histogram bucket bounds must be strictly increasing")
+		}
+	}
+
+	if len(h.Counts) != len(h.Buckets)+1 {
+		return errors.New("histogram must have one count per bucket plus overflow")
+	}
+
+	var total uint64
+	for _, count := range h.Counts {
+		total += count
+	}
+	if total != h.Count {
+		return errors.New("histogram count must equal sum of bucket counts")
+	}
+
+	if math.IsNaN(h.Sum) || math.IsInf(h.Sum, 0) {
+		return errors.New("histogram sum must be finite")
+	}
+
+	return nil
+}
+
+func (h HistogramData) Copy() HistogramData {
+	return HistogramData{
+		Buckets: append([]float64(nil), h.Buckets...),
+		Counts:  append([]uint64(nil), h.Counts...),
+		Count:   h.Count,
+		Sum:     h.Sum,
+	}
+}
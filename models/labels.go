@@ -0,0 +1,94 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Labels — необязательный набор измерений метрики (host, service и т.п.).
+// Метрики с одним именем, но разными метками считаются разными рядами.
+type Labels map[string]string
+
+// String возвращает каноническое представление меток: {a="1",b="2"} с ключами по алфавиту.
+// Для пустого набора возвращается пустая строка.
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(l))
+	for key := range l {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var builder strings.Builder
+	builder.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(key)
+		builder.WriteByte('=')
+		builder.WriteString(strconv.Quote(l[key]))
+	}
+	builder.WriteByte('}')
+
+	return builder.String()
+}
+
+// Copy возвращает независимую копию меток, nil для пустого набора.
+func (l Labels) Copy() Labels {
+	if len(l) == 0 {
+		return nil
+	}
+
+	copied := make(Labels, len(l))
+	for key, value := range l {
+		copied[key] = value
+	}
+
+	return copied
+}
+
+var ErrInvalidName = errors.New("metric name must not contain '{', '}' or '\"'")
+
+// ValidateName отклоняет имена со скобками и кавычками: иначе имя cpu{host="a"} без меток
+// и имя cpu с меткой host="a" дали бы один SeriesID.
+func ValidateName(name string) error {
+	if strings.ContainsAny(name, `{}"`) {
+		return ErrInvalidName
+	}
+
+	return nil
+}
+
+var ErrInvalidLabel = errors.New("label name must match [a-zA-Z_][a-zA-Z0-9_]*")
+
+var labelNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// ValidLabelName сообщает, допустимо ли имя метки.
+func ValidLabelName(name string) bool {
+	return labelNameRe.MatchString(name)
+}
+
+// ValidateLabels отклоняет метки с недопустимыми именами: '=', ',' или '}' в ключе
+// сделали бы SeriesID разных наборов меток одинаковым.
+func ValidateLabels(labels Labels) error {
+	for name := range labels {
+		if !ValidLabelName(name) {
+			return fmt.Errorf("%w: %q", ErrInvalidLabel, name)
+		}
+	}
+
+	return nil
+}
+
+// SeriesID однозначно определяет ряд по имени и меткам, если они прошли ValidateName и ValidateLabels.
+func SeriesID(name string, labels Labels) string {
+	return name + labels.String()
+}
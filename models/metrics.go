@@ -1,8 +1,20 @@
 package models
 
+import "time"
+
 const (
-	Counter = "counter"
-	Gauge   = "gauge"
+	Counter   = "counter"
+	Gauge     = "gauge"
+	Histogram = "histogram"
+)
+
+// Операции записи журнала, кроме обновления.
+// OpState отмечает запись состояния из сжатия или перезаписи журнала: накопленное значение ряда
+// на момент его последнего обновления.
+const (
+	OpDelete = "delete"
+	OpReset  = "reset"
+	OpState  = "state"
 )
 
 // NOTE: Не усложняем пример, вводя иерархическую вложенность структур.
@@ -11,9 +23,30 @@ const (
 // что бы отличать значение "0", от не заданного значения
 // и соответственно не кодировать в структуру.
 type Metrics struct {
-	ID    string   `json:"id"`
-	MType string   `json:"type"`
-	Delta *int64   `json:"delta,omitempty"`
-	Value *float64 `json:"value,omitempty"`
-	Hash  string   `json:"hash,omitempty"`
+	ID     string   `json:"id"`
+	MType  string   `json:"type"`
+	Delta  *int64   `json:"delta,omitempty"`
+	Value  *float64 `json:"value,omitempty"`
+	Hash   string   `json:"hash,omitempty"`
+	Labels Labels   `json:"labels,omitempty"`
+
+	// Для type histogram передаются либо отдельные наблюдения Observations,
+	// либо уже разложенные по корзинам данные Histogram.
+	Observations []float64      `json:"observations,omitempty"`
+	Histogram    *HistogramData `json:"histogram,omitempty"`
+
+	// Timestamp — время приёма записи журнала в наносекундах Unix, а в снимках хранилища —
+	// время последнего обновления ряда. Для обновлений через API не используется.
+	Timestamp int64 `json:"ts,omitempty"`
+
+	// Op — операция записи журнала: пусто для обновления, OpDelete, OpReset или OpState.
+	// У удаления и сброса значение не заполняется. В API не используется.
+	Op string `json:"op,omitempty"`
+}
+
+// Sample — значение метрики в момент времени Timestamp.
+type Sample struct {
+	Timestamp time.Time `json:"timestamp"`
+	Delta     *int64    `json:"delta,omitempty"`
+	Value     *float64  `json:"value,omitempty"`
 }